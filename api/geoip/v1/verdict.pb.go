@@ -0,0 +1,182 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: geoip/v1/verdict.proto
+
+package geoipv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CheckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ip            string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_geoip_v1_verdict_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_geoip_v1_verdict_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_geoip_v1_verdict_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CheckRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type CheckResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Allowed       bool                   `protobuf:"varint,1,opt,name=allowed,proto3" json:"allowed,omitempty"`
+	Country       string                 `protobuf:"bytes,2,opt,name=country,proto3" json:"country,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_geoip_v1_verdict_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_geoip_v1_verdict_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_geoip_v1_verdict_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CheckResponse) GetAllowed() bool {
+	if x != nil {
+		return x.Allowed
+	}
+	return false
+}
+
+func (x *CheckResponse) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+var File_geoip_v1_verdict_proto protoreflect.FileDescriptor
+
+const file_geoip_v1_verdict_proto_rawDesc = "" +
+	"\n" +
+	"\x16geoip/v1/verdict.proto\x12\bgeoip.v1\"\x1e\n" +
+	"\fCheckRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\"C\n" +
+	"\rCheckResponse\x12\x18\n" +
+	"\aallowed\x18\x01 \x01(\bR\aallowed\x12\x18\n" +
+	"\acountry\x18\x02 \x01(\tR\acountry2J\n" +
+	"\x0eVerdictService\x128\n" +
+	"\x05Check\x12\x16.geoip.v1.CheckRequest\x1a\x17.geoip.v1.CheckResponseB7Z5github.com/rdwr-valentineg/GeoIP/api/geoip/v1;geoipv1b\x06proto3"
+
+var (
+	file_geoip_v1_verdict_proto_rawDescOnce sync.Once
+	file_geoip_v1_verdict_proto_rawDescData []byte
+)
+
+func file_geoip_v1_verdict_proto_rawDescGZIP() []byte {
+	file_geoip_v1_verdict_proto_rawDescOnce.Do(func() {
+		file_geoip_v1_verdict_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_geoip_v1_verdict_proto_rawDesc), len(file_geoip_v1_verdict_proto_rawDesc)))
+	})
+	return file_geoip_v1_verdict_proto_rawDescData
+}
+
+var file_geoip_v1_verdict_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_geoip_v1_verdict_proto_goTypes = []any{
+	(*CheckRequest)(nil),  // 0: geoip.v1.CheckRequest
+	(*CheckResponse)(nil), // 1: geoip.v1.CheckResponse
+}
+var file_geoip_v1_verdict_proto_depIdxs = []int32{
+	0, // 0: geoip.v1.VerdictService.Check:input_type -> geoip.v1.CheckRequest
+	1, // 1: geoip.v1.VerdictService.Check:output_type -> geoip.v1.CheckResponse
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_geoip_v1_verdict_proto_init() }
+func file_geoip_v1_verdict_proto_init() {
+	if File_geoip_v1_verdict_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_geoip_v1_verdict_proto_rawDesc), len(file_geoip_v1_verdict_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_geoip_v1_verdict_proto_goTypes,
+		DependencyIndexes: file_geoip_v1_verdict_proto_depIdxs,
+		MessageInfos:      file_geoip_v1_verdict_proto_msgTypes,
+	}.Build()
+	File_geoip_v1_verdict_proto = out.File
+	file_geoip_v1_verdict_proto_goTypes = nil
+	file_geoip_v1_verdict_proto_depIdxs = nil
+}
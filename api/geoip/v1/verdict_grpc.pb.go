@@ -0,0 +1,131 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: geoip/v1/verdict.proto
+
+package geoipv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	VerdictService_Check_FullMethodName = "/geoip.v1.VerdictService/Check"
+)
+
+// VerdictServiceClient is the client API for VerdictService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// VerdictService answers the same allow/deny question as /auth, sharing its
+// verdict cache and database reader.
+type VerdictServiceClient interface {
+	// Check returns the verdict for ip. When ip is empty, the client IP is taken
+	// from the call metadata key named by -ip-header, then from the peer address.
+	Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckResponse, error)
+}
+
+type verdictServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVerdictServiceClient(cc grpc.ClientConnInterface) VerdictServiceClient {
+	return &verdictServiceClient{cc}
+}
+
+func (c *verdictServiceClient) Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckResponse)
+	err := c.cc.Invoke(ctx, VerdictService_Check_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VerdictServiceServer is the server API for VerdictService service.
+// All implementations must embed UnimplementedVerdictServiceServer
+// for forward compatibility.
+//
+// VerdictService answers the same allow/deny question as /auth, sharing its
+// verdict cache and database reader.
+type VerdictServiceServer interface {
+	// Check returns the verdict for ip. When ip is empty, the client IP is taken
+	// from the call metadata key named by -ip-header, then from the peer address.
+	Check(context.Context, *CheckRequest) (*CheckResponse, error)
+	mustEmbedUnimplementedVerdictServiceServer()
+}
+
+// UnimplementedVerdictServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVerdictServiceServer struct{}
+
+func (UnimplementedVerdictServiceServer) Check(context.Context, *CheckRequest) (*CheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Check not implemented")
+}
+func (UnimplementedVerdictServiceServer) mustEmbedUnimplementedVerdictServiceServer() {}
+func (UnimplementedVerdictServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeVerdictServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VerdictServiceServer will
+// result in compilation errors.
+type UnsafeVerdictServiceServer interface {
+	mustEmbedUnimplementedVerdictServiceServer()
+}
+
+func RegisterVerdictServiceServer(s grpc.ServiceRegistrar, srv VerdictServiceServer) {
+	// If the following call pancis, it indicates UnimplementedVerdictServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&VerdictService_ServiceDesc, srv)
+}
+
+func _VerdictService_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VerdictServiceServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VerdictService_Check_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VerdictServiceServer).Check(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// VerdictService_ServiceDesc is the grpc.ServiceDesc for VerdictService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VerdictService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "geoip.v1.VerdictService",
+	HandlerType: (*VerdictServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Check",
+			Handler:    _VerdictService_Check_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "geoip/v1/verdict.proto",
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	allowListTimeout = 10 * time.Second
+	// allowListMaxSize bounds the fetched list; country lists are tiny.
+	allowListMaxSize = 1 << 20
+)
+
+// allowedFromURL is the allow-list last fetched from -allow-url. Once set it
+// replaces the -allow countries; it is swapped as a whole, like ipOverrides.
+var allowedFromURL atomic.Pointer[map[string]bool]
+
+// AllowListFetcher polls -allow-url for a newline-delimited country list. It
+// keeps the validators of the last response, so an unchanged list is answered
+// with 304 Not Modified instead of being downloaded again.
+type AllowListFetcher struct {
+	URL    string
+	Client *http.Client
+
+	etag         string
+	lastModified string
+}
+
+func NewAllowListFetcher(url string) *AllowListFetcher {
+	return &AllowListFetcher{
+		URL:    url,
+		Client: &http.Client{Timeout: allowListTimeout},
+	}
+}
+
+// Fetch downloads the list and installs it, reporting whether it changed.
+// On failure the current list is kept.
+func (f *AllowListFetcher) Fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create allow-list request: %w", err)
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch allow-list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("allow-list fetch returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, allowListMaxSize))
+	if err != nil {
+		return false, fmt.Errorf("failed to read allow-list: %w", err)
+	}
+	codes, err := parseAllowList(string(body))
+	if err != nil {
+		return false, err
+	}
+	allowedFromURL.Store(&codes)
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	return true, nil
+}
+
+// parseAllowList parses a fetched allow-list. It takes the same entries as
+// -allow, one or more per line, and ignores lines starting with '#'. ASN
+// entries are rejected, since the ASN allow-list is not reloaded.
+func parseAllowList(body string) (map[string]bool, error) {
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines = append(lines, line)
+		}
+	}
+	value := strings.Join(lines, "\n")
+	if len(parseAllowedASNs(value)) > 0 {
+		return nil, fmt.Errorf("allow-list from URL cannot contain ASN entries")
+	}
+	codes := parseAllowedCodes(value)
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("allow-list from URL is empty")
+	}
+	for code := range codes {
+		if isRegionMacro(code) {
+			if _, err := expandRegion(code); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return codes, nil
+}
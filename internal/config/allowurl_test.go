@@ -0,0 +1,100 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAllowListFetcher_Fetch(t *testing.T) {
+	defer allowedFromURL.Store(nil)
+
+	var (
+		mutex    sync.Mutex
+		body     = "# partners\nUS\nde\n"
+		etag     = `"v1"`
+		status   = http.StatusOK
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	fetcher := NewAllowListFetcher(server.URL)
+	fetch := func() bool {
+		t.Helper()
+		changed, err := fetcher.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		return changed
+	}
+	assertCodes := func(want ...string) {
+		t.Helper()
+		codes := GetAllowedCodes()
+		if len(codes) != len(want) {
+			t.Fatalf("Expected %v, got %v", want, codes)
+		}
+		for _, code := range want {
+			if !codes[code] {
+				t.Errorf("Expected %s to be allowed, got %v", code, codes)
+			}
+		}
+	}
+
+	if !fetch() {
+		t.Error("Expected the first fetch to install the list")
+	}
+	assertCodes("US", "DE")
+
+	// An unchanged list is answered with 304 and keeps the current one.
+	if fetch() {
+		t.Error("Expected an unchanged list not to be reported as changed")
+	}
+	assertCodes("US", "DE")
+
+	mutex.Lock()
+	body, etag = "FR\n@NATO\n", `"v2"`
+	mutex.Unlock()
+	if !fetch() {
+		t.Error("Expected the updated list to be installed")
+	}
+	if codes := GetAllowedCodes(); !codes["FR"] || !codes["CA"] {
+		t.Errorf("Expected the updated list with @NATO expanded, got %v", codes)
+	}
+
+	// Failures keep the previous list.
+	mutex.Lock()
+	body, etag = "AS15169\n", `"v3"`
+	mutex.Unlock()
+	if _, err := fetcher.Fetch(context.Background()); err == nil {
+		t.Error("Expected a list with ASN entries to be rejected")
+	}
+	mutex.Lock()
+	status = http.StatusInternalServerError
+	mutex.Unlock()
+	if _, err := fetcher.Fetch(context.Background()); err == nil {
+		t.Error("Expected a failed fetch to return an error")
+	}
+	if codes := GetAllowedCodes(); !codes["FR"] {
+		t.Errorf("Expected the previous list to survive failures, got %v", codes)
+	}
+	if requests != 5 {
+		t.Errorf("Expected 5 requests, got %d", requests)
+	}
+}
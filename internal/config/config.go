@@ -4,7 +4,14 @@ import (
 	"errors"
 	"flag"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -12,82 +19,559 @@ import (
 
 type config struct {
 	DbPath               string
+	ASNDbPath            string
+	DbURL                string
+	DbURLAuth            bool
+	DbStorage            string
+	DbMmap               bool
+	DbFileLock           bool
+	UpdateWebhook        string
+	EnableFallbackDB     bool
+	MonitorMode          bool
+	IPOverrideList       string
+	IPOverrideFile       string
+	AllowURL             string
+	AllowURLInterval     time.Duration
+	Rules                *RuleSet
+	AllowEUOnly          bool
+	ReadyDebounce        time.Duration
+	ReadyRecoverChecks   int
+	CompactResponses     bool
+	ExposeReason         bool
+	ExposeTrace          bool
 	Port                 uint
+	AdminPort            uint
+	GRPCAddr             string
+	ReusePort            bool
+	TLSCert              string
+	TLSKey               string
 	IpHeader             string
+	AuthQueryIP          bool
 	LogLevelFlag         string
+	StrictLogLevel       bool
+	Locale               string
 	MaxMindLicenseKey    string
 	MaxMindAccountId     string
 	MaxMindFetchInterval time.Duration
 	FetcherTimeout       time.Duration
+	FetcherStopTimeout   time.Duration
 	CachePurgePeriod     time.Duration
+	CachePurgeJitter     time.Duration
+	CacheMaxBytes        int
+	CacheEviction        string
+	CacheNewKeyRate      float64
+	CacheTTL             time.Duration
+	CacheTTLNotFound     time.Duration
+	CacheStaleGrace      time.Duration
+	CacheSnapshot        string
+	CachePurgeBatch      int
+	DrainPeriod          time.Duration
+	DBAgeInterval        time.Duration
+	IntegrityInterval    time.Duration
 	FetcherBaseBackoff   time.Duration
 	FetcherMaxRetries    int
+	BreakerThreshold     int
+	BreakerCooldown      time.Duration
+	FetchUnhealthyAfter  int
+	FetchSourceName      string
+	ExtractAnyMMDB       bool
+	ExpectedDBType       string
+	CountryFieldPath     []string
+	CountrySourceChain   []string
+	MultiCountryMode     string
+	NotReadyPolicy       string
+	AccessLogMode        string
+	AuditBroker          string
+	AuditTopic           string
+	AuditBuffer          int
+	AuditPolicy          string
+	Responder            string
+	AllowStatus          int
+	DenyStatus           int
+	StrictDBType         bool
+	LookupRateLimit      float64
+	BatchWorkers         int
+	MaxBatchSize         int
+	MaxRequestBody       int64
+	RequestTimeout       time.Duration
+	CacheNamespace       string
+	CacheNamespaceByHost bool
+	MetricsTopCountries  int
+	MetricsTopWindow     time.Duration
+	MetricsNamespace     string
+	SelfTestIPs          []net.IP
+	RequireSelfTest      bool
+	SelfTestTimeout      time.Duration
 	AllowedCodes         map[string]bool
+	AllowedASNs          map[uint]bool
 	ExcludeCIDR          []*net.IPNet
+	Geofence             *Geofence
 }
 
-var cfg *config
+// cfg holds the current configuration. Swapping the whole snapshot keeps
+// getters lock-free and race-free while a reload replaces it.
+var cfg atomic.Pointer[config]
+
+// LogLevelEnv names the environment variable that overrides -log-level, so
+// container platforms can bump verbosity without changing the arguments.
+const LogLevelEnv = "GEOIP_LOG_LEVEL"
+
+// logLevels are the values accepted by -log-level and LogLevelEnv.
+var logLevels = []string{"none", "error", "info", "debug"}
+
+// Values of -multi-country-mode.
+const (
+	MultiCountryAny = "any"
+	MultiCountryAll = "all"
+)
+
+// Country sources -country-source-chain can list, after the record fields
+// they read: country, registered_country and represented_country.
+const (
+	CountrySourceLocation    = "location"
+	CountrySourceRegistered  = "registered"
+	CountrySourceRepresented = "represented"
+)
+
+// Values of -not-ready-policy.
+const (
+	NotReadyDeny        = "deny"
+	NotReadyAllow       = "allow"
+	NotReadyUnavailable = "503"
+)
+
+// Values of -access-log-mode.
+const (
+	AccessLogAll   = "all"
+	AccessLogDeny  = "deny"
+	AccessLogError = "error"
+)
+
+// Values of -audit-policy, deciding what happens to audit events when the
+// publish buffer is full.
+const (
+	AuditPolicyDrop  = "drop"
+	AuditPolicyBlock = "block"
+)
+
+// defaultAuditBuffer is the number of audit events queued for publishing.
+const defaultAuditBuffer = 1024
+
+// Values of -cache-eviction, the strategy picking which verdicts leave a full
+// cache.
+const (
+	CacheEvictionLRU = "lru"
+	CacheEvictionLFU = "lfu"
+	CacheEvictionTTL = "ttl"
+)
+
+// Values of -responder, naming the gateway /auth verdicts are shaped for.
+const (
+	ResponderNginx   = "nginx"
+	ResponderTraefik = "traefik"
+	ResponderEnvoy   = "envoy"
+	ResponderCustom  = "custom"
+)
+
+// defaultExcludeCIDR is used when -exclude is not given at all. Passing
+// -exclude=none (or an empty value) clears it, so deployments that receive
+// real traffic from private ranges still get geo decisions for them.
+// The IPv6 defaults cover loopback, unique local (fc00::/7) and link-local
+// (fe80::/10) addresses.
+const defaultExcludeCIDR = "192.168.0.0/16,10.0.0.0/8,172.16.0.0/12,127.0.0.0/8,::1/128,fc00::/7,fe80::/10"
+
+// IsLoaded reports whether InitConfig has stored a configuration.
+func IsLoaded() bool {
+	return cfg.Load() != nil
+}
 
 func InitConfig() error {
-	if cfg != nil {
+	if cfg.Load() != nil {
 		return nil // Already initialized
 	}
 
 	port := flag.Uint("port", 8080, "Port to listen on")
-	excludeCIDR := flag.String("exclude", "192.168.0.0/16,10.0.0.0/8,172.16.0.0/12,127.0.0.0/8,::1/128", "Comma-separated CIDRs to exclude")
-	allowedCountryList := flag.String("allow", "US", "Comma-separated list of ISO country codes to allow")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set and is reloaded on SIGHUP or when it changes")
+	tlsKey := flag.String("tls-key", "", "TLS private key file matching -tls-cert")
+	reusePort := flag.Bool("reuse-port", false, "Bind listeners with SO_REUSEPORT so an upgraded process can bind the same ports while this one drains")
+	grpcAddr := flag.String("grpc-addr", "", "Address of the gRPC verdict service, e.g. :9090 (empty disables it; needs a build with -tags grpc)")
+	adminPort := flag.Uint("admin-port", 0, "Port for the admin listener (0 disables admin endpoints)")
+	excludeCIDR := flag.String("exclude", defaultExcludeCIDR, "Comma-separated CIDRs to exclude, or \"none\" to exclude nothing")
+	compactResponses := flag.Bool("compact-responses", false, "Send /auth verdicts with no body, only the status and X-Country (also per request via X-Compact-Response)")
+	exposeTrace := flag.Bool("expose-trace", false, "Send X-Decision-Trace on /auth verdicts, summarizing how they were reached (IP source, exclusion, country, reason, cache)")
+	exposeReason := flag.Bool("expose-reason", false, "Send X-Allow-Reason (lan, allowlist-ip, country, geofence, rule, monitor) on allowed /auth verdicts, and the country and reason in JSON denials")
+	enforce := flag.Bool("enforce", true, "Deny requests from disallowed countries; false only logs and counts would-be denials (monitor mode)")
+	ipOverrideList := flag.String("ip-override", "", "Comma-separated ip=allow|ip=deny entries applied before any geo lookup")
+	ipOverrideFile := flag.String("ip-override-file", "", "File of ip=allow|ip=deny entries, one per line, re-read on SIGHUP")
+	geofence := flag.String("geofence", "", "LAT,LON,RADIUS_KM circle outside of which located requests are denied (City DB only)")
+	rulesFile := flag.String("rules-file", "", "JSON file of ordered allow/deny rules evaluated first match wins; replaces -allow when set")
+	allowEUOnly := flag.Bool("allow-eu-only", false, "Allow only IPs whose record has the EU membership flag set; replaces -allow when set")
+	allowURL := flag.String("allow-url", "", "HTTP(S) URL polled for a newline-delimited country allow-list that replaces -allow once fetched")
+	allowURLInterval := flag.Duration("allow-url-interval", 5*time.Minute, "How often -allow-url is polled")
+	allowedCountryList := flag.String("allow", "US", "Comma-separated list of ISO country codes to allow; @EU, @EEA and @NATO expand to their members, ASnnnn entries allow an ASN and need -asn-db")
 	ipHeader := flag.String("ip-header", "X-Forwarded-For", "Header to extract real IP")
-	logLevelFlag := flag.String("log-level", "info", "Log level (none, error, info, debug)")
+	authQueryIP := flag.Bool("auth-query-ip", false, "Let /auth take the client IP from an ip query parameter when present, for integration tests and gateways that cannot set headers")
+	logLevelFlag := flag.String("log-level", "info", "Log level (none, error, info, debug); "+LogLevelEnv+" overrides it when set")
+	strictLogLevel := flag.Bool("strict-log-level", false, "Exit on an unknown -log-level instead of falling back to info")
+	locale := flag.String("locale", "en", "Default locale for country names when Accept-Language has no match")
 	dbPath := flag.String("db", "", "Path to MaxMind GeoIP2 DB")
+	asnDbPath := flag.String("asn-db", "", "Path to a MaxMind ASN DB that ASnnnn -allow entries are matched against")
+	enableFallbackDB := flag.Bool("enable-empty-fallback-db", false, "Until the real DB is ready, answer /auth from an embedded DB that holds no networks, so every IP resolves to no country (excluded CIDRs still pass)")
+	dbMmap := flag.Bool("db-mmap", false, "Keep in-memory databases in a mapped temporary file so the OS page cache manages residency instead of the heap")
+	dbFileLock := flag.Bool("db-file-lock", false, "Serialize DB file replaces and reads with an advisory lock on <db>.lock, for when another process also writes the DB path")
+	dbStorage := flag.String("db-storage", "", "Where fetched DBs are kept: memory or file (default: file when -db is set, otherwise memory)")
+	updateWebhook := flag.String("update-webhook", "", "URL POSTed a JSON event (source, database type, build epoch, size) after each successful DB update")
+	dbURL := flag.String("db-url", "", "URL to fetch the DB from instead of MaxMind (https:// or s3://bucket/key)")
+	dbURLAuth := flag.Bool("db-url-auth", false, "Send the MaxMind account id and license key to -db-url as Basic auth, e.g. for an authenticating mirror; they are only sent to MaxMind otherwise")
 	maxMindLicenseKey := flag.String("maxmind-license-key", "", "MaxMind license key for GeoIP2 DB updates")
 	maxMindAccountId := flag.String("maxmind-account-id", "", "MaxMind account id for GeoIP2 DB updates")
 	maxMindFetchInterval := flag.Duration("maxmind-fetch-interval", 24*time.Hour, "Interval for fetching MaxMind GeoIP2 DB updates")
+	cacheNamespace := flag.String("cache-namespace", "", "Namespace prefixed to verdict cache keys so policies never share entries")
+	cacheNamespaceByHost := flag.Bool("cache-namespace-by-host", false, "Use the request Host as the cache namespace (falls back to -cache-namespace when empty)")
+	metricsNamespace := flag.String("metrics-namespace", "geoip", "Prefix of every exported metric name")
+	metricsTopCountries := flag.Int("metrics-top-countries", 0, "Distinct country labels kept on request metrics; rarer countries are reported as OTHER and a country seen more often takes over the least frequent label (0 for no limit)")
+	metricsTopWindow := flag.Duration("metrics-top-countries-window", 0, "How often the -metrics-top-countries ranking starts over so it follows recent traffic (0 ranks over all time)")
+	selfTestIPs := flag.String("selftest-ips", "", "Comma-separated IPs looked up at startup to sanity-check the database, e.g. 8.8.8.8,1.1.1.1; the server only starts listening once it is done (empty disables)")
+	requireSelfTest := flag.Bool("require-selftest", false, "Abort startup when the startup self-test fails")
+	selfTestTimeout := flag.Duration("selftest-timeout", 30*time.Second, "How long the startup self-test waits for the database to become ready")
+	readyDebounce := flag.Duration("ready-debounce", 5*time.Second, "How long the DB must stay unready before /ready reports it")
+	readyRecoverChecks := flag.Int("ready-recover-checks", 2, "Consecutive ready checks needed before /ready reports ready again after being unready")
+	integrityInterval := flag.Duration("integrity-check-interval", 0, "How often a sample of lookups re-checks the loaded DB, marking it not ready and reloading it when they fail (0 disables it)")
+	dbAgeInterval := flag.Duration("db-age-interval", time.Minute, "How often the db_age_seconds gauge is refreshed from the serving database's build time (0 disables the gauge)")
+	drainPeriod := flag.Duration("drain-period", 0, "How long /auth answers 503 during shutdown before the listener closes (0 shuts down immediately)")
+	cacheNewKeyRate := flag.Float64("cache-new-key-rate", 0, "New distinct verdict cache keys allowed per second; verdicts beyond it are served uncached, bounding cache fills from spoofed IPs (0 disables)")
+	cacheEviction := flag.String("cache-eviction", CacheEvictionLRU, "Which verdicts leave a cache over -cache-max-bytes or a -cache-purge-batch tick: lru (least recently used), lfu (least frequently used) or ttl (purge all, leaving expiry to -cache-ttl)")
+	cacheMaxBytes := flag.Int("cache-max-bytes", 0, "Estimated verdict cache size in bytes that triggers a purge (0 for no limit)")
+	cacheTTL := flag.Duration("cache-ttl", 0, "How long a cached verdict is fresh (0 keeps it until the next purge)")
+	cacheTTLNotFound := flag.Duration("cache-ttl-notfound", 0, "How long a cached verdict for an IP without a country in the DB is fresh, usually shorter than -cache-ttl (0 uses -cache-ttl)")
+	cacheStaleGrace := flag.Duration("cache-stale-grace", 0, "How long past -cache-ttl a verdict is still served while it is refreshed in the background")
+	cacheSnapshot := flag.String("cache-snapshot", "", "File the verdict cache is saved to on shutdown and restored from on startup when the DB build is unchanged")
+	cachePurgeBatch := flag.Int("cache-purge-batch", 0, "Verdict cache entries evicted per purge tick, spreading a large purge over several ticks (0 purges everything at once)")
 	cachePurgePeriod := flag.Duration("purge-interval", 2*time.Minute, "Interval for clearing the cache")
+	cachePurgeJitter := flag.Duration("purge-jitter", 0, "Random delay of up to this much added to each cache purge, so replicas do not purge in lockstep (0 disables it)")
 	fetcherTimeout := flag.Duration("fetcher-timeout", 30*time.Second, "Timeout for remote fetcher operations")
+	fetcherStopTimeout := flag.Duration("fetcher-stop-timeout", 0, "How long shutdown waits for an in-flight fetch to finish or cancel (0 waits as long as it takes)")
 	fetcherMaxRetries := flag.Int("fetcher-max-retries", 3, "Maximum retries for remote fetcher operations")
+	batchWorkers := flag.Int("batch-workers", runtime.GOMAXPROCS(0), "Number of workers resolving IPs of a /lookup/batch request")
+	maxBatchSize := flag.Int("max-batch-size", 1000, "Maximum number of IPs accepted per /lookup/batch request (0 for no limit)")
+	maxRequestBody := flag.Int64("max-request-body", 1<<20, "Maximum request body size in bytes for POST endpoints (0 for no limit)")
+	requestTimeout := flag.Duration("request-timeout", 0, "Maximum time a request may take before it is answered with 503, except /metrics and /db/download (0 for no limit)")
+	extractAnyMMDB := flag.Bool("extract-any-mmdb", false, "Fall back to the only .mmdb file in a downloaded archive when the expected one is missing")
+	notReadyPolicy := flag.String("not-ready-policy", NotReadyUnavailable, "How /auth answers before the DB is ready: 503, deny (403) or allow (fail open)")
+	responder := flag.String("responder", ResponderCustom, "Gateway /auth verdicts are shaped for: nginx (403 denials without a body), traefik, envoy or custom (-allow-status and -deny-status)")
+	allowStatus := flag.Int("allow-status", http.StatusOK, "Status code of allowed /auth verdicts with -responder=custom, 2xx")
+	denyStatus := flag.Int("deny-status", http.StatusForbidden, "Status code of denied /auth verdicts with -responder=custom, 4xx or 5xx")
+	auditBroker := flag.String("audit-broker", "", "nats://[user:pass@]host:port of a NATS server /auth verdicts are published to for audit (empty disables)")
+	auditTopic := flag.String("audit-topic", "", "Subject audit events are published to; required with -audit-broker")
+	auditBuffer := flag.Int("audit-buffer", defaultAuditBuffer, "Audit events queued for publishing before -audit-policy applies")
+	auditPolicy := flag.String("audit-policy", AuditPolicyDrop, "What a full audit buffer does to new events: drop them, or block the request until there is room")
+	accessLogMode := flag.String("access-log-mode", AccessLogError, "Which /auth requests are logged at debug level: all, deny (denied and failed) or error (failed only)")
+	multiCountryMode := flag.String("multi-country-mode", MultiCountryAny, "How records listing several countries are judged: any (allowed if any country is allowed) or all")
+	countrySourceChain := flag.String("country-source-chain", CountrySourceLocation, "Comma-separated country sources tried in order until one has an ISO code: location, registered, represented")
+	countryFieldPath := flag.String("country-field-path", "", "Slash-separated path to the country code in custom mmdb schemas, e.g. geo/cc (empty uses the MaxMind country/iso_code layout)")
+	expectedDBType := flag.String("expected-db-type", "Country", "Substring the MaxMind database type must contain (empty disables the check)")
+	strictDBType := flag.Bool("strict-db-type", false, "Refuse to load a database whose type does not match -expected-db-type instead of warning")
+	lookupRateLimit := flag.Float64("lookup-rate-limit", 0, "Requests per second allowed across the /lookup endpoints (0 disables limiting)")
+	breakerThreshold := flag.Int("fetch-breaker-threshold", 5, "Consecutive failed scheduled fetches that open the fetch circuit breaker (0 disables it)")
+	breakerCooldown := flag.Duration("fetch-breaker-cooldown", time.Hour, "How long the fetch circuit breaker stays open before a trial fetch")
+	fetchUnhealthyAfter := flag.Int("fetch-unhealthy-after", 3, "Consecutive failed fetches after which /health/fetch reports the remote source unhealthy")
+	fetchSourceName := flag.String("fetch-source-name", "maxmind", "Endpoint label of the remote fetcher's metrics, to tell sources such as editions or mirrors apart")
 	fetcherBaseBackoff := flag.Duration("fetcher-base-backoff", 5*time.Second, "Base backoff duration for remote fetcher retries")
 
 	flag.Parse()
 
-	allowedMap := make(map[string]bool, 0)
-	for c := range strings.SplitSeq(*allowedCountryList, ",") {
-		allowedMap[strings.ToUpper(strings.TrimSpace(c))] = true
+	allowedMap := parseAllowedCodes(*allowedCountryList)
+	excludeSubnets := parseExcludeCIDR(*excludeCIDR)
+	fence, err := parseGeofence(*geofence)
+	if err != nil {
+		return err
 	}
-	excludeSubnets := make([]*net.IPNet, 0, 10)
-	for cidr := range strings.SplitSeq(*excludeCIDR, ",") {
-		_, ipnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
-		if err == nil {
-			excludeSubnets = append(excludeSubnets, ipnet)
-		}
+	rules, err := loadRules(*rulesFile)
+	if err != nil {
+		return err
 	}
 
-	cfg = &config{
+	c := &config{
 		DbPath:               *dbPath,
+		ASNDbPath:            *asnDbPath,
+		DbURL:                *dbURL,
+		DbURLAuth:            *dbURLAuth,
+		DbStorage:            *dbStorage,
+		DbMmap:               *dbMmap,
+		DbFileLock:           *dbFileLock,
+		UpdateWebhook:        *updateWebhook,
+		EnableFallbackDB:     *enableFallbackDB,
+		MonitorMode:          !*enforce,
+		IPOverrideList:       *ipOverrideList,
+		IPOverrideFile:       *ipOverrideFile,
+		AllowURL:             *allowURL,
+		AllowURLInterval:     *allowURLInterval,
+		Rules:                rules,
+		AllowEUOnly:          *allowEUOnly,
+		ReadyDebounce:        *readyDebounce,
+		ReadyRecoverChecks:   *readyRecoverChecks,
+		CompactResponses:     *compactResponses,
+		ExposeReason:         *exposeReason,
+		ExposeTrace:          *exposeTrace,
 		Port:                 *port,
+		AdminPort:            *adminPort,
+		GRPCAddr:             *grpcAddr,
+		ReusePort:            *reusePort,
+		TLSCert:              *tlsCert,
+		TLSKey:               *tlsKey,
 		ExcludeCIDR:          excludeSubnets,
 		AllowedCodes:         allowedMap,
+		AllowedASNs:          parseAllowedASNs(*allowedCountryList),
 		IpHeader:             *ipHeader,
-		LogLevelFlag:         *logLevelFlag,
+		AuthQueryIP:          *authQueryIP,
+		LogLevelFlag:         resolveLogLevel(*logLevelFlag),
+		StrictLogLevel:       *strictLogLevel,
+		Locale:               *locale,
 		CachePurgePeriod:     *cachePurgePeriod,
+		CachePurgeJitter:     *cachePurgeJitter,
+		CacheMaxBytes:        *cacheMaxBytes,
+		CacheEviction:        strings.ToLower(strings.TrimSpace(*cacheEviction)),
+		CacheNewKeyRate:      *cacheNewKeyRate,
+		CacheTTL:             *cacheTTL,
+		CacheTTLNotFound:     *cacheTTLNotFound,
+		CacheStaleGrace:      *cacheStaleGrace,
+		CacheSnapshot:        *cacheSnapshot,
+		CachePurgeBatch:      *cachePurgeBatch,
+		DrainPeriod:          *drainPeriod,
+		DBAgeInterval:        *dbAgeInterval,
+		IntegrityInterval:    *integrityInterval,
 		MaxMindLicenseKey:    *maxMindLicenseKey,
 		MaxMindAccountId:     *maxMindAccountId,
 		MaxMindFetchInterval: *maxMindFetchInterval,
 		FetcherTimeout:       *fetcherTimeout,
+		FetcherStopTimeout:   *fetcherStopTimeout,
 		FetcherMaxRetries:    *fetcherMaxRetries,
 		FetcherBaseBackoff:   *fetcherBaseBackoff,
+		BreakerThreshold:     *breakerThreshold,
+		BreakerCooldown:      *breakerCooldown,
+		FetchUnhealthyAfter:  *fetchUnhealthyAfter,
+		FetchSourceName:      strings.TrimSpace(*fetchSourceName),
+		ExtractAnyMMDB:       *extractAnyMMDB,
+		ExpectedDBType:       *expectedDBType,
+		CountryFieldPath:     parseFieldPath(*countryFieldPath),
+		CountrySourceChain:   splitList(strings.ToLower(*countrySourceChain)),
+		MultiCountryMode:     *multiCountryMode,
+		NotReadyPolicy:       *notReadyPolicy,
+		AccessLogMode:        strings.ToLower(strings.TrimSpace(*accessLogMode)),
+		AuditBroker:          *auditBroker,
+		AuditTopic:           *auditTopic,
+		AuditBuffer:          *auditBuffer,
+		AuditPolicy:          strings.ToLower(strings.TrimSpace(*auditPolicy)),
+		Responder:            strings.ToLower(strings.TrimSpace(*responder)),
+		AllowStatus:          *allowStatus,
+		DenyStatus:           *denyStatus,
+		StrictDBType:         *strictDBType,
+		LookupRateLimit:      *lookupRateLimit,
+		BatchWorkers:         *batchWorkers,
+		MaxBatchSize:         *maxBatchSize,
+		MaxRequestBody:       *maxRequestBody,
+		RequestTimeout:       *requestTimeout,
+		CacheNamespace:       *cacheNamespace,
+		CacheNamespaceByHost: *cacheNamespaceByHost,
+		MetricsTopCountries:  *metricsTopCountries,
+		MetricsTopWindow:     *metricsTopWindow,
+		MetricsNamespace:     *metricsNamespace,
+		SelfTestIPs:          parseIPList(*selfTestIPs),
+		RequireSelfTest:      *requireSelfTest,
+		SelfTestTimeout:      *selfTestTimeout,
+		Geofence:             fence,
 	}
 
-	log.Debug().Any("config", cfg).Msg("Configuration initialized")
-	return cfg.Validate()
+	// Nothing is stored unless it is valid, so getters and IsLoaded never
+	// see a rejected config.
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	overrides, err := loadIPOverrides(c.IPOverrideList, c.IPOverrideFile)
+	if err != nil {
+		return err
+	}
+	cfg.Store(c)
+	ipOverrides.Store(&overrides)
+	return nil
+}
+
+// splitList splits a list separated by commas and/or newlines, trimming each
+// token and dropping empty tokens and duplicates while keeping the order.
+func splitList(value string) []string {
+	tokens := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	seen := make(map[string]bool, len(tokens))
+	items := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if token == "" || seen[token] {
+			continue
+		}
+		seen[token] = true
+		items = append(items, token)
+	}
+	return items
+}
+
+// parseAllowedCodes builds the allow-list from the -allow value. Codes are
+// upper-cased, region macros are expanded to their members and an empty
+// token never becomes an entry. ASN entries are left to parseAllowedASNs. An
+// unknown macro is kept as is for Validate to reject.
+func parseAllowedCodes(value string) map[string]bool {
+	allowedMap := make(map[string]bool, 0)
+	for _, code := range splitList(strings.ToUpper(value)) {
+		if _, ok := parseASN(code); ok {
+			continue
+		}
+		if isRegionMacro(code) {
+			if members, err := expandRegion(code); err == nil {
+				for _, member := range members {
+					allowedMap[member] = true
+				}
+				continue
+			}
+		}
+		allowedMap[code] = true
+	}
+	return allowedMap
+}
+
+// parseAllowedASNs collects the ASnnnn entries of the -allow value.
+func parseAllowedASNs(value string) map[uint]bool {
+	asns := make(map[uint]bool)
+	for _, token := range splitList(strings.ToUpper(value)) {
+		if asn, ok := parseASN(token); ok {
+			asns[asn] = true
+		}
+	}
+	return asns
+}
+
+// parseASN parses an upper-case "AS15169" token. A bare "AS" is American
+// Samoa, not an ASN.
+func parseASN(token string) (uint, bool) {
+	digits, ok := strings.CutPrefix(token, "AS")
+	if !ok || digits == "" {
+		return 0, false
+	}
+	asn, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint(asn), true
+}
+
+// resolveLogLevel returns the level from LogLevelEnv when it names a known
+// level, and flagValue otherwise.
+func resolveLogLevel(flagValue string) string {
+	env := strings.ToLower(strings.TrimSpace(os.Getenv(LogLevelEnv)))
+	if env == "" {
+		return flagValue
+	}
+	if !slices.Contains(logLevels, env) {
+		log.Warn().Str("level", env).Msgf("Ignoring unknown %s", LogLevelEnv)
+		return flagValue
+	}
+	return env
+}
+
+// parseExcludeCIDR parses the -exclude value. Explicit CIDRs replace the
+// defaults entirely rather than adding to them, and "none" yields no excludes.
+func parseExcludeCIDR(value string) []*net.IPNet {
+	excludeSubnets := make([]*net.IPNet, 0, 10)
+	if strings.EqualFold(strings.TrimSpace(value), "none") {
+		return excludeSubnets
+	}
+	for _, cidr := range splitList(value) {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Warn().Str("cidr", cidr).Msg("Ignoring invalid exclude CIDR")
+			continue
+		}
+		excludeSubnets = append(excludeSubnets, ipnet)
+	}
+	return excludeSubnets
+}
+
+// parseIPList parses a comma-separated list of IPs, skipping invalid entries.
+func parseIPList(value string) []net.IP {
+	ips := make([]net.IP, 0)
+	for _, s := range splitList(value) {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// parseFieldPath splits a slash-separated record path. Empty segments are
+// kept so Validate can reject them.
+func parseFieldPath(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(strings.Trim(value, "/"), "/")
+}
+
+// isMetricName reports whether s is usable as a Prometheus name prefix.
+func isMetricName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 func (c *config) Validate() error {
-	if c.DbPath == "" && c.MaxMindLicenseKey == "" {
+	if c.DbPath == "" && c.MaxMindLicenseKey == "" && c.DbURL == "" {
 		return errors.New("both database path and Maxmind license key cannot be empty")
 	}
+	for code := range c.AllowedCodes {
+		if isRegionMacro(code) {
+			if _, err := expandRegion(code); err != nil {
+				return err
+			}
+		}
+	}
+	switch c.DbStorage {
+	case "", "memory", "file":
+	default:
+		return errors.New("invalid database storage, must be memory or file")
+	}
+	if c.UpdateWebhook != "" {
+		u, err := url.Parse(c.UpdateWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("update webhook must be an http or https URL")
+		}
+	}
+	if c.AllowURL != "" {
+		u, err := url.Parse(c.AllowURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("allow url must be an http or https URL")
+		}
+		if c.AllowURLInterval <= 0 {
+			return errors.New("allow url interval must be positive")
+		}
+	}
 	if c.Port <= 0 || c.Port > 65536 {
 		return errors.New("invalid port value, must be between 1 and 65536")
 	}
+	if c.AdminPort > 65536 {
+		return errors.New("invalid admin port value, must be between 1 and 65536")
+	}
+
+	if c.GRPCAddr != "" {
+		if _, _, err := net.SplitHostPort(c.GRPCAddr); err != nil {
+			return errors.New("gRPC address must be host:port, e.g. :9090")
+		}
+	}
 
 	if c.IpHeader == "" {
 		return errors.New("source IP header cannot be empty")
@@ -95,12 +579,141 @@ func (c *config) Validate() error {
 	if c.CachePurgePeriod <= 0 {
 		return errors.New("cache purge interval must be greater than zero")
 	}
+	if c.CachePurgeJitter < 0 {
+		return errors.New("cache purge jitter cannot be negative")
+	}
 
-	if c.MaxMindLicenseKey != "" {
-		if c.MaxMindAccountId == "" {
-			return errors.New("when maxmind license key provided, maxmind account id is required")
+	if c.CacheMaxBytes < 0 {
+		return errors.New("cache max bytes cannot be negative")
+	}
+	switch c.CacheEviction {
+	case "", CacheEvictionLRU, CacheEvictionLFU, CacheEvictionTTL:
+	default:
+		return errors.New("invalid cache eviction, must be lru, lfu or ttl")
+	}
+	if c.CacheNewKeyRate < 0 {
+		return errors.New("cache new key rate cannot be negative")
+	}
+	if c.CacheTTL < 0 || c.CacheStaleGrace < 0 {
+		return errors.New("cache ttl and stale grace cannot be negative")
+	}
+	if c.CacheTTLNotFound < 0 {
+		return errors.New("cache not-found ttl cannot be negative")
+	}
+	if c.CachePurgeBatch < 0 {
+		return errors.New("cache purge batch cannot be negative")
+	}
+	if c.DrainPeriod < 0 {
+		return errors.New("drain period cannot be negative")
+	}
+	if c.DBAgeInterval < 0 {
+		return errors.New("db age interval cannot be negative")
+	}
+	if c.IntegrityInterval < 0 {
+		return errors.New("integrity check interval cannot be negative")
+	}
+
+	if c.LookupRateLimit < 0 {
+		return errors.New("lookup rate limit cannot be negative")
+	}
+	if c.BatchWorkers < 0 {
+		return errors.New("batch workers cannot be negative")
+	}
+	if c.MaxBatchSize < 0 {
+		return errors.New("max batch size cannot be negative")
+	}
+	for _, source := range c.CountrySourceChain {
+		switch source {
+		case CountrySourceLocation, CountrySourceRegistered, CountrySourceRepresented:
+		default:
+			return errors.New("invalid country source chain, entries must be location, registered or represented")
+		}
+	}
+	if slices.Contains(c.CountryFieldPath, "") {
+		return errors.New("country field path must not contain empty segments")
+	}
+	switch c.MultiCountryMode {
+	case "", MultiCountryAny, MultiCountryAll:
+	default:
+		return errors.New("invalid multi-country mode, must be any or all")
+	}
+	switch c.NotReadyPolicy {
+	case "", NotReadyDeny, NotReadyAllow, NotReadyUnavailable:
+	default:
+		return errors.New("invalid not-ready policy, must be deny, allow or 503")
+	}
+	switch c.AccessLogMode {
+	case "", AccessLogAll, AccessLogDeny, AccessLogError:
+	default:
+		return errors.New("invalid access log mode, must be all, deny or error")
+	}
+	if c.AuditBroker != "" {
+		u, err := url.Parse(c.AuditBroker)
+		if err != nil || u.Scheme != "nats" || u.Host == "" {
+			return errors.New("audit broker must be a nats:// URL")
+		}
+		if c.AuditTopic == "" || strings.ContainsAny(c.AuditTopic, " \t\r\n") {
+			return errors.New("audit broker requires an audit topic without whitespace")
 		}
+	}
+	if c.AuditBuffer < 0 {
+		return errors.New("audit buffer cannot be negative")
+	}
+	switch c.AuditPolicy {
+	case "", AuditPolicyDrop, AuditPolicyBlock:
+	default:
+		return errors.New("invalid audit policy, must be drop or block")
+	}
+	switch c.Responder {
+	case "", ResponderNginx, ResponderTraefik, ResponderEnvoy, ResponderCustom:
+	default:
+		return errors.New("invalid responder, must be nginx, traefik, envoy or custom")
+	}
+	if c.AllowStatus != 0 && (c.AllowStatus < 200 || c.AllowStatus > 299) {
+		return errors.New("allow status must be a 2xx status code")
+	}
+	if c.DenyStatus != 0 && (c.DenyStatus < 400 || c.DenyStatus > 599) {
+		return errors.New("deny status must be a 4xx or 5xx status code")
+	}
+	if c.MaxRequestBody < 0 {
+		return errors.New("max request body cannot be negative")
+	}
+	if c.RequestTimeout < 0 {
+		return errors.New("request timeout cannot be negative")
+	}
+	if !isMetricName(c.MetricsNamespace) {
+		return errors.New("metrics namespace may only contain letters, digits and underscores and must not start with a digit")
+	}
+	if c.MetricsTopCountries < 0 {
+		return errors.New("metrics top countries cannot be negative")
+	}
+	if c.MetricsTopWindow < 0 {
+		return errors.New("metrics top countries window cannot be negative")
+	}
+	if c.BreakerThreshold < 0 {
+		return errors.New("fetch breaker threshold cannot be negative")
+	}
+	if c.BreakerCooldown < 0 {
+		return errors.New("fetch breaker cooldown cannot be negative")
+	}
+	if c.FetchUnhealthyAfter < 0 {
+		return errors.New("fetch unhealthy threshold cannot be negative")
+	}
+	if c.ReadyDebounce < 0 {
+		return errors.New("ready debounce cannot be negative")
+	}
+	if c.ReadyRecoverChecks < 0 {
+		return errors.New("ready recover checks cannot be negative")
+	}
+	if c.SelfTestTimeout < 0 {
+		return errors.New("self-test timeout cannot be negative")
+	}
 
+	if c.MaxMindLicenseKey != "" && c.MaxMindAccountId == "" {
+		return errors.New("when maxmind license key provided, maxmind account id is required")
+	}
+	// Both start the remote fetcher, which ticks every fetch interval.
+	if c.MaxMindLicenseKey != "" || c.DbURL != "" {
 		if c.MaxMindFetchInterval <= 0 {
 			return errors.New("maxmind fetch interval must be greater than zero")
 		}
@@ -108,96 +721,708 @@ func (c *config) Validate() error {
 			return errors.New("fetch timeout must be greater than zero")
 		}
 	}
+	if c.FetcherStopTimeout < 0 {
+		return errors.New("fetcher stop timeout cannot be negative")
+	}
 
-	return nil
+	return c.validateCombinations()
+}
+
+// validateCombinations checks the rules between flags: ones that exclude each
+// other and ones that only work together. Unlike the checks of single values
+// it does not stop at the first violation, so all of them are reported at once.
+func (c *config) validateCombinations() error {
+	var errs []error
+	if len(c.AllowedASNs) > 0 && c.ASNDbPath == "" {
+		errs = append(errs, errors.New("ASN allow-list entries require an ASN database"))
+	}
+	if c.AllowEUOnly && (c.Rules != nil || len(c.CountryFieldPath) > 0) {
+		errs = append(errs, errors.New("allow-eu-only cannot be combined with a rules file or a country field path"))
+	}
+	// A country field path only decodes the country, leaving the location,
+	// continent and subdivisions empty.
+	if len(c.CountryFieldPath) > 0 && c.Geofence != nil {
+		errs = append(errs, errors.New("geofence cannot be combined with a country field path"))
+	}
+	if len(c.CountryFieldPath) > 0 && c.Rules.usesRecord() {
+		errs = append(errs, errors.New("continent and subdivision rules cannot be combined with a country field path"))
+	}
+	if c.AllowURL != "" && (c.Rules != nil || c.AllowEUOnly) {
+		errs = append(errs, errors.New("allow url cannot be combined with a rules file or allow-eu-only"))
+	}
+	if c.DbStorage == "file" && c.DbPath == "" {
+		errs = append(errs, errors.New("file database storage requires a database path"))
+	}
+	if c.DbURLAuth && (c.DbURL == "" || c.MaxMindLicenseKey == "") {
+		errs = append(errs, errors.New("db url auth requires a db url and a maxmind license key"))
+	}
+	if c.DbFileLock && c.DbPath == "" {
+		errs = append(errs, errors.New("db file lock requires a database path"))
+	}
+	if c.AdminPort != 0 && c.AdminPort == c.Port {
+		errs = append(errs, errors.New("admin port must differ from the main port"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("TLS certificate and key must be given together"))
+	}
+	if c.CacheStaleGrace > 0 && c.CacheTTL == 0 {
+		errs = append(errs, errors.New("cache stale grace requires a cache ttl"))
+	}
+	if c.CacheTTL > 0 && c.CacheTTLNotFound > c.CacheTTL {
+		errs = append(errs, errors.New("cache not-found ttl cannot exceed the cache ttl"))
+	}
+	if c.AuditTopic != "" && c.AuditBroker == "" {
+		errs = append(errs, errors.New("audit topic requires an audit broker"))
+	}
+	return errors.Join(errs...)
+}
+
+func GetDbURL() string {
+	if c := cfg.Load(); c != nil {
+		return c.DbURL
+	}
+	return ""
+}
+
+// GetDbURLAuth reports whether the MaxMind credentials are sent to -db-url.
+func GetDbURLAuth() bool {
+	if c := cfg.Load(); c != nil {
+		return c.DbURLAuth
+	}
+	return false
+}
+
+func GetUpdateWebhook() string {
+	if c := cfg.Load(); c != nil {
+		return c.UpdateWebhook
+	}
+	return ""
+}
+
+func GetDbMmap() bool {
+	if c := cfg.Load(); c != nil {
+		return c.DbMmap
+	}
+	return false
+}
+
+func GetDbFileLock() bool {
+	if c := cfg.Load(); c != nil {
+		return c.DbFileLock
+	}
+	return false
+}
+
+func GetDbStorage() string {
+	if c := cfg.Load(); c != nil {
+		return c.DbStorage
+	}
+	return ""
+}
+
+// GetMonitorMode reports whether denials are only recorded, not enforced.
+func GetMonitorMode() bool {
+	if c := cfg.Load(); c != nil {
+		return c.MonitorMode
+	}
+	return false
+}
+
+// GetAuthQueryIP reports whether /auth honours an ip query parameter.
+func GetAuthQueryIP() bool {
+	if c := cfg.Load(); c != nil {
+		return c.AuthQueryIP
+	}
+	return false
+}
+
+// GetAllowEUOnly reports whether only IPs flagged as EU members are allowed.
+func GetAllowEUOnly() bool {
+	if c := cfg.Load(); c != nil {
+		return c.AllowEUOnly
+	}
+	return false
+}
+
+func GetCompactResponses() bool {
+	if c := cfg.Load(); c != nil {
+		return c.CompactResponses
+	}
+	return false
+}
+
+// GetExposeReason reports whether allowed verdicts carry X-Allow-Reason.
+func GetExposeReason() bool {
+	if c := cfg.Load(); c != nil {
+		return c.ExposeReason
+	}
+	return false
+}
+
+// GetExposeTrace reports whether /auth verdicts carry X-Decision-Trace.
+func GetExposeTrace() bool {
+	if c := cfg.Load(); c != nil {
+		return c.ExposeTrace
+	}
+	return false
+}
+
+func GetReadyDebounce() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.ReadyDebounce
+	}
+	return time.Duration(0)
+}
+
+func GetReadyRecoverChecks() int {
+	if c := cfg.Load(); c != nil {
+		return c.ReadyRecoverChecks
+	}
+	return 0
+}
+
+func GetEnableFallbackDB() bool {
+	if c := cfg.Load(); c != nil {
+		return c.EnableFallbackDB
+	}
+	return false
 }
 
 func GetDbPath() string {
-	if cfg != nil {
-		return cfg.DbPath
+	if c := cfg.Load(); c != nil {
+		return c.DbPath
 	}
 	return ""
 }
 
 func GetPort() uint {
-	if cfg != nil {
-		return cfg.Port
+	if c := cfg.Load(); c != nil {
+		return c.Port
 	}
 	return 0
 }
 
+func GetAdminPort() uint {
+	if c := cfg.Load(); c != nil {
+		return c.AdminPort
+	}
+	return 0
+}
+
+// GetReusePort reports whether listeners are bound with SO_REUSEPORT.
+func GetReusePort() bool {
+	if c := cfg.Load(); c != nil {
+		return c.ReusePort
+	}
+	return false
+}
+
+// GetGRPCAddr returns the gRPC verdict service address, or "" when disabled.
+func GetGRPCAddr() string {
+	if c := cfg.Load(); c != nil {
+		return c.GRPCAddr
+	}
+	return ""
+}
+
+func GetTLSCert() string {
+	if c := cfg.Load(); c != nil {
+		return c.TLSCert
+	}
+	return ""
+}
+
+func GetTLSKey() string {
+	if c := cfg.Load(); c != nil {
+		return c.TLSKey
+	}
+	return ""
+}
+
 func GetIpHeader() string {
-	if cfg != nil {
-		return cfg.IpHeader
+	if c := cfg.Load(); c != nil {
+		return c.IpHeader
 	}
 	return ""
 }
 
 func GetLogLevel() string {
-	if cfg != nil {
-		return cfg.LogLevelFlag
+	if c := cfg.Load(); c != nil {
+		return c.LogLevelFlag
+	}
+	return ""
+}
+
+// GetStrictLogLevel reports whether an unknown log level is fatal.
+func GetStrictLogLevel() bool {
+	if c := cfg.Load(); c != nil {
+		return c.StrictLogLevel
+	}
+	return false
+}
+
+func GetLocale() string {
+	if c := cfg.Load(); c != nil {
+		return c.Locale
 	}
 	return ""
 }
 
 func GetMaxMindLicenseKey() string {
-	if cfg != nil {
-		return cfg.MaxMindLicenseKey
+	if c := cfg.Load(); c != nil {
+		return c.MaxMindLicenseKey
 	}
 	return ""
 }
 
 func GetMaxMindAccountId() string {
-	if cfg != nil {
-		return cfg.MaxMindAccountId
+	if c := cfg.Load(); c != nil {
+		return c.MaxMindAccountId
 	}
 	return ""
 }
 
 func GetMaxMindFetchInterval() time.Duration {
-	if cfg != nil {
-		return cfg.MaxMindFetchInterval
+	if c := cfg.Load(); c != nil {
+		return c.MaxMindFetchInterval
 	}
 	return time.Duration(0)
 }
 
 func GetCachePurgePeriod() time.Duration {
-	if cfg != nil {
-		return cfg.CachePurgePeriod
+	if c := cfg.Load(); c != nil {
+		return c.CachePurgePeriod
+	}
+	return time.Duration(0)
+}
+
+func GetCachePurgeJitter() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.CachePurgeJitter
+	}
+	return time.Duration(0)
+}
+
+func GetCacheTTL() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.CacheTTL
+	}
+	return time.Duration(0)
+}
+
+// GetCacheTTLNotFound returns the TTL of verdicts for IPs the database has
+// no country for; 0 means they use GetCacheTTL.
+func GetCacheTTLNotFound() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.CacheTTLNotFound
+	}
+	return time.Duration(0)
+}
+
+func GetCacheStaleGrace() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.CacheStaleGrace
+	}
+	return time.Duration(0)
+}
+
+func GetCacheSnapshot() string {
+	if c := cfg.Load(); c != nil {
+		return c.CacheSnapshot
+	}
+	return ""
+}
+
+func GetCacheMaxBytes() int {
+	if c := cfg.Load(); c != nil {
+		return c.CacheMaxBytes
+	}
+	return 0
+}
+
+// GetCacheEviction returns the cache eviction strategy, CacheEvictionLRU
+// when unset.
+func GetCacheEviction() string {
+	if c := cfg.Load(); c != nil && c.CacheEviction != "" {
+		return c.CacheEviction
+	}
+	return CacheEvictionLRU
+}
+
+func GetCacheNewKeyRate() float64 {
+	if c := cfg.Load(); c != nil {
+		return c.CacheNewKeyRate
+	}
+	return 0
+}
+
+// GetCachePurgeBatch returns the entries evicted per purge tick, or 0 to
+// purge the whole cache at once.
+func GetCachePurgeBatch() int {
+	if c := cfg.Load(); c != nil {
+		return c.CachePurgeBatch
+	}
+	return 0
+}
+
+func GetDrainPeriod() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.DrainPeriod
 	}
 	return time.Duration(0)
 }
 
+// GetDBAgeInterval returns how often the database age gauge is refreshed; 0
+// disables it.
+func GetDBAgeInterval() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.DBAgeInterval
+	}
+	return 0
+}
+
+// GetIntegrityInterval returns how often the loaded DB is re-checked; 0
+// disables the check.
+func GetIntegrityInterval() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.IntegrityInterval
+	}
+	return 0
+}
+
 func GetFetcherTimeout() time.Duration {
-	if cfg != nil {
-		return cfg.FetcherTimeout
+	if c := cfg.Load(); c != nil {
+		return c.FetcherTimeout
+	}
+	return time.Duration(0)
+}
+
+func GetFetcherStopTimeout() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.FetcherStopTimeout
 	}
 	return time.Duration(0)
 }
 
 func GetFetcherMaxRetries() int {
-	if cfg != nil {
-		return cfg.FetcherMaxRetries
+	if c := cfg.Load(); c != nil {
+		return c.FetcherMaxRetries
+	}
+	return 0
+}
+
+func GetExtractAnyMMDB() bool {
+	if c := cfg.Load(); c != nil {
+		return c.ExtractAnyMMDB
+	}
+	return false
+}
+
+func GetExpectedDBType() string {
+	if c := cfg.Load(); c != nil {
+		return c.ExpectedDBType
+	}
+	return ""
+}
+
+// GetCountryFieldPath returns the record path of the country code, or nil to
+// use the typed MaxMind layout.
+func GetCountryFieldPath() []string {
+	if c := cfg.Load(); c != nil {
+		return c.CountryFieldPath
+	}
+	return nil
+}
+
+// GetCountrySourceChain returns the country sources tried in order for a
+// record's country, just the location country unless configured otherwise.
+func GetCountrySourceChain() []string {
+	if c := cfg.Load(); c != nil && len(c.CountrySourceChain) > 0 {
+		return c.CountrySourceChain
+	}
+	return []string{CountrySourceLocation}
+}
+
+// GetAccessLogMode returns which /auth requests are logged, AccessLogError
+// unless configured otherwise.
+func GetAccessLogMode() string {
+	if c := cfg.Load(); c != nil && c.AccessLogMode != "" {
+		return c.AccessLogMode
+	}
+	return AccessLogError
+}
+
+func GetAuditBroker() string {
+	if c := cfg.Load(); c != nil {
+		return c.AuditBroker
+	}
+	return ""
+}
+
+func GetAuditTopic() string {
+	if c := cfg.Load(); c != nil {
+		return c.AuditTopic
+	}
+	return ""
+}
+
+// GetAuditBuffer returns the audit queue length, defaultAuditBuffer when
+// unset.
+func GetAuditBuffer() int {
+	if c := cfg.Load(); c != nil && c.AuditBuffer != 0 {
+		return c.AuditBuffer
+	}
+	return defaultAuditBuffer
+}
+
+// GetAuditPolicy returns what a full audit queue does, AuditPolicyDrop when
+// unset.
+func GetAuditPolicy() string {
+	if c := cfg.Load(); c != nil && c.AuditPolicy != "" {
+		return c.AuditPolicy
+	}
+	return AuditPolicyDrop
+}
+
+// GetResponder returns the gateway /auth verdicts are shaped for,
+// ResponderCustom unless configured otherwise.
+func GetResponder() string {
+	if c := cfg.Load(); c != nil && c.Responder != "" {
+		return c.Responder
+	}
+	return ResponderCustom
+}
+
+// GetAllowStatus returns the status code of allowed verdicts for
+// ResponderCustom, 200 unless configured otherwise.
+func GetAllowStatus() int {
+	if c := cfg.Load(); c != nil && c.AllowStatus != 0 {
+		return c.AllowStatus
+	}
+	return http.StatusOK
+}
+
+// GetDenyStatus returns the status code of denied verdicts for
+// ResponderCustom, 403 unless configured otherwise.
+func GetDenyStatus() int {
+	if c := cfg.Load(); c != nil && c.DenyStatus != 0 {
+		return c.DenyStatus
+	}
+	return http.StatusForbidden
+}
+
+// GetNotReadyPolicy returns how /auth answers while the DB is not ready,
+// NotReadyUnavailable unless configured otherwise.
+func GetNotReadyPolicy() string {
+	if c := cfg.Load(); c != nil && c.NotReadyPolicy != "" {
+		return c.NotReadyPolicy
+	}
+	return NotReadyUnavailable
+}
+
+// GetMultiCountryMode returns how records listing several countries are
+// judged, MultiCountryAny or MultiCountryAll.
+func GetMultiCountryMode() string {
+	if c := cfg.Load(); c != nil && c.MultiCountryMode != "" {
+		return c.MultiCountryMode
+	}
+	return MultiCountryAny
+}
+
+func GetStrictDBType() bool {
+	if c := cfg.Load(); c != nil {
+		return c.StrictDBType
+	}
+	return false
+}
+
+func GetFetchBreakerThreshold() int {
+	if c := cfg.Load(); c != nil {
+		return c.BreakerThreshold
 	}
 	return 0
 }
+
+func GetFetchBreakerCooldown() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.BreakerCooldown
+	}
+	return time.Duration(0)
+}
+
+func GetFetchUnhealthyAfter() int {
+	if c := cfg.Load(); c != nil {
+		return c.FetchUnhealthyAfter
+	}
+	return 0
+}
+
+func GetFetchSourceName() string {
+	if c := cfg.Load(); c != nil {
+		return c.FetchSourceName
+	}
+	return ""
+}
+
 func GetFetcherBaseBackoff() time.Duration {
-	if cfg != nil {
-		return cfg.FetcherBaseBackoff
+	if c := cfg.Load(); c != nil {
+		return c.FetcherBaseBackoff
+	}
+	return time.Duration(0)
+}
+
+func GetLookupRateLimit() float64 {
+	if c := cfg.Load(); c != nil {
+		return c.LookupRateLimit
+	}
+	return 0
+}
+
+func GetBatchWorkers() int {
+	if c := cfg.Load(); c != nil {
+		return c.BatchWorkers
+	}
+	return 0
+}
+
+func GetMaxBatchSize() int {
+	if c := cfg.Load(); c != nil {
+		return c.MaxBatchSize
+	}
+	return 0
+}
+
+func GetMaxRequestBody() int64 {
+	if c := cfg.Load(); c != nil {
+		return c.MaxRequestBody
+	}
+	return 0
+}
+
+func GetRequestTimeout() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.RequestTimeout
+	}
+	return 0
+}
+
+func GetCacheNamespace() string {
+	if c := cfg.Load(); c != nil {
+		return c.CacheNamespace
+	}
+	return ""
+}
+
+func GetCacheNamespaceByHost() bool {
+	if c := cfg.Load(); c != nil {
+		return c.CacheNamespaceByHost
+	}
+	return false
+}
+
+func GetMetricsNamespace() string {
+	if c := cfg.Load(); c != nil {
+		return c.MetricsNamespace
+	}
+	return ""
+}
+
+func GetMetricsTopCountries() int {
+	if c := cfg.Load(); c != nil {
+		return c.MetricsTopCountries
+	}
+	return 0
+}
+
+func GetMetricsTopWindow() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.MetricsTopWindow
+	}
+	return 0
+}
+
+func GetSelfTestIPs() []net.IP {
+	if c := cfg.Load(); c != nil {
+		return c.SelfTestIPs
+	}
+	return nil
+}
+
+func GetRequireSelfTest() bool {
+	if c := cfg.Load(); c != nil {
+		return c.RequireSelfTest
+	}
+	return false
+}
+
+func GetSelfTestTimeout() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.SelfTestTimeout
 	}
 	return time.Duration(0)
 }
 
+func GetAllowURL() string {
+	if c := cfg.Load(); c != nil {
+		return c.AllowURL
+	}
+	return ""
+}
+
+func GetAllowURLInterval() time.Duration {
+	if c := cfg.Load(); c != nil {
+		return c.AllowURLInterval
+	}
+	return time.Duration(0)
+}
+
+// GetAllowedCodes returns the country allow-list: the one fetched from
+// -allow-url once there is one, otherwise -allow.
 func GetAllowedCodes() map[string]bool {
-	if cfg != nil {
-		return cfg.AllowedCodes
+	if codes := allowedFromURL.Load(); codes != nil {
+		return *codes
+	}
+	if c := cfg.Load(); c != nil {
+		return c.AllowedCodes
+	}
+	return nil
+}
+
+// GetAllowedASNs returns the autonomous system numbers -allow lets through
+// regardless of country.
+func GetAllowedASNs() map[uint]bool {
+	if c := cfg.Load(); c != nil {
+		return c.AllowedASNs
 	}
 	return nil
 }
 
+func GetASNDbPath() string {
+	if c := cfg.Load(); c != nil {
+		return c.ASNDbPath
+	}
+	return ""
+}
+
 func GetExcludeCIDR() []*net.IPNet {
-	if cfg != nil {
-		return cfg.ExcludeCIDR
+	if c := cfg.Load(); c != nil {
+		return c.ExcludeCIDR
+	}
+	return nil
+}
+
+// GetGeofence returns the configured geofence, or nil when it is disabled.
+func GetGeofence() *Geofence {
+	if c := cfg.Load(); c != nil {
+		return c.Geofence
+	}
+	return nil
+}
+
+// GetRules returns the ordered rule set, or nil when -rules-file is not set.
+func GetRules() *RuleSet {
+	if c := cfg.Load(); c != nil {
+		return c.Rules
 	}
 	return nil
 }
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -24,6 +26,38 @@ func TestValidate(t *testing.T) {
 				CachePurgePeriod: 10,
 			},
 		},
+		"db url only": {
+			config: &config{
+				DbURL:                "s3://bucket/GeoLite2-Country.mmdb",
+				Port:                 8080,
+				IpHeader:             "some-header",
+				CachePurgePeriod:     10,
+				MaxMindFetchInterval: time.Hour,
+				FetcherTimeout:       time.Minute,
+			},
+		},
+		"db url without fetch interval": {
+			config: &config{
+				DbURL:            "https://example.com/GeoLite2-Country.mmdb",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				FetcherTimeout:   time.Minute,
+			},
+			wantErr: "maxmind fetch interval must be greater than zero",
+		},
+		"db url auth without license key": {
+			config: &config{
+				DbURL:                "https://example.com/GeoLite2-Country.mmdb",
+				DbURLAuth:            true,
+				Port:                 8080,
+				IpHeader:             "some-header",
+				CachePurgePeriod:     10,
+				MaxMindFetchInterval: time.Hour,
+				FetcherTimeout:       time.Minute,
+			},
+			wantErr: "db url auth requires a db url and a maxmind license key",
+		},
 		"empty db path": {
 			config: &config{
 				Port:             8080,
@@ -32,6 +66,360 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: "both database path and Maxmind license key cannot be empty",
 		},
+		"invalid database storage": {
+			config: &config{
+				DbPath:           "test.db",
+				DbStorage:        "disk",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+			},
+			wantErr: "invalid database storage, must be memory or file",
+		},
+		"file database storage without path": {
+			config: &config{
+				DbURL:                "https://example.com/GeoLite2-Country.mmdb",
+				DbStorage:            "file",
+				Port:                 8080,
+				IpHeader:             "some-header",
+				CachePurgePeriod:     10,
+				MaxMindFetchInterval: time.Hour,
+				FetcherTimeout:       time.Minute,
+			},
+			wantErr: "file database storage requires a database path",
+		},
+		"invalid update webhook": {
+			config: &config{
+				DbPath:           "test.db",
+				UpdateWebhook:    "ftp://example.com/hook",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+			},
+			wantErr: "update webhook must be an http or https URL",
+		},
+		"TLS certificate without key": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				TLSCert:          "server.crt",
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+			},
+			wantErr: "TLS certificate and key must be given together",
+		},
+		"empty country field path segment": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CountryFieldPath: []string{"geo", "", "cc"},
+			},
+			wantErr: "country field path must not contain empty segments",
+		},
+		"negative cache purge jitter": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CachePurgeJitter: -1,
+			},
+			wantErr: "cache purge jitter cannot be negative",
+		},
+		"stale grace without ttl": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CacheStaleGrace:  time.Minute,
+			},
+			wantErr: "cache stale grace requires a cache ttl",
+		},
+		"unknown region macro": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AllowedCodes:     parseAllowedCodes("@EU,@MARS"),
+			},
+			wantErr: `unknown region macro "@MARS"`,
+		},
+		"negative request timeout": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				RequestTimeout:   -time.Second,
+			},
+			wantErr: "request timeout cannot be negative",
+		},
+		"negative metrics top countries window": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				MetricsTopWindow: -time.Minute,
+			},
+			wantErr: "metrics top countries window cannot be negative",
+		},
+		"invalid access log mode": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AccessLogMode:    "allowed",
+			},
+			wantErr: "invalid access log mode, must be all, deny or error",
+		},
+		"eu only with a country field path": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AllowEUOnly:      true,
+				CountryFieldPath: []string{"geo", "cc"},
+			},
+			wantErr: "allow-eu-only cannot be combined with a rules file or a country field path",
+		},
+		"geofence with a country field path": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				Geofence:         &Geofence{Lat: 52.5, Lon: 13.4, RadiusKm: 50},
+				CountryFieldPath: []string{"geo", "cc"},
+			},
+			wantErr: "geofence cannot be combined with a country field path",
+		},
+		"subdivision rules with a country field path": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				Rules: &RuleSet{
+					Rules:   []Rule{{Type: RuleTypeSubdivision, Match: "US-TX", Action: RuleActionDeny}},
+					Default: RuleActionAllow,
+				},
+				CountryFieldPath: []string{"geo", "cc"},
+			},
+			wantErr: "continent and subdivision rules cannot be combined with a country field path",
+		},
+		"country rules with a country field path": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				Rules: &RuleSet{
+					Rules:   []Rule{{Type: RuleTypeCountry, Match: "US", Action: RuleActionAllow}},
+					Default: RuleActionDeny,
+				},
+				CountryFieldPath: []string{"geo", "cc"},
+			},
+		},
+		"negative db age interval": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				DBAgeInterval:    -1,
+			},
+			wantErr: "db age interval cannot be negative",
+		},
+		"negative integrity check interval": {
+			config: &config{
+				DbPath:            "test.db",
+				Port:              8080,
+				IpHeader:          "some-header",
+				CachePurgePeriod:  10,
+				IntegrityInterval: -1,
+			},
+			wantErr: "integrity check interval cannot be negative",
+		},
+		"invalid responder": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				Responder:        "haproxy",
+			},
+			wantErr: "invalid responder, must be nginx, traefik, envoy or custom",
+		},
+		"non-2xx allow status": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AllowStatus:      302,
+			},
+			wantErr: "allow status must be a 2xx status code",
+		},
+		"non-error deny status": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				DenyStatus:       204,
+			},
+			wantErr: "deny status must be a 4xx or 5xx status code",
+		},
+		"negative cache new key rate": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CacheNewKeyRate:  -1,
+			},
+			wantErr: "cache new key rate cannot be negative",
+		},
+		"non-http allow url": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AllowURL:         "file:///etc/allow",
+				AllowURLInterval: time.Minute,
+			},
+			wantErr: "allow url must be an http or https URL",
+		},
+		"not-found ttl above cache ttl": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CacheTTL:         time.Minute,
+				CacheTTLNotFound: time.Hour,
+			},
+			wantErr: "cache not-found ttl cannot exceed the cache ttl",
+		},
+		"kafka audit broker": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AuditBroker:      "kafka://broker:9092",
+				AuditTopic:       "verdicts",
+			},
+			wantErr: "audit broker must be a nats:// URL",
+		},
+		"invalid cache eviction": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CacheEviction:    "fifo",
+			},
+			wantErr: "invalid cache eviction, must be lru, lfu or ttl",
+		},
+		"negative fetcher stop timeout": {
+			config: &config{
+				DbPath:             "test.db",
+				Port:               8080,
+				IpHeader:           "some-header",
+				CachePurgePeriod:   10,
+				FetcherStopTimeout: -1,
+			},
+			wantErr: "fetcher stop timeout cannot be negative",
+		},
+		"negative cache purge batch": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				CachePurgeBatch:  -1,
+			},
+			wantErr: "cache purge batch cannot be negative",
+		},
+		"negative fetch unhealthy threshold": {
+			config: &config{
+				DbPath:              "test.db",
+				Port:                8080,
+				IpHeader:            "some-header",
+				CachePurgePeriod:    10,
+				FetchUnhealthyAfter: -1,
+			},
+			wantErr: "fetch unhealthy threshold cannot be negative",
+		},
+		"invalid not-ready policy": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				NotReadyPolicy:   "maybe",
+			},
+			wantErr: "invalid not-ready policy, must be deny, allow or 503",
+		},
+		"invalid country source chain": {
+			config: &config{
+				DbPath:             "test.db",
+				Port:               8080,
+				IpHeader:           "some-header",
+				CachePurgePeriod:   10,
+				CountrySourceChain: []string{"location", "billing"},
+			},
+			wantErr: "invalid country source chain, entries must be location, registered or represented",
+		},
+		"ASN allow entries without ASN database": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				AllowedASNs:      map[uint]bool{15169: true},
+			},
+			wantErr: "ASN allow-list entries require an ASN database",
+		},
+		"invalid gRPC address": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				GRPCAddr:         "9090",
+			},
+			wantErr: "gRPC address must be host:port, e.g. :9090",
+		},
+		"invalid multi-country mode": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				MultiCountryMode: "most",
+			},
+			wantErr: "invalid multi-country mode, must be any or all",
+		},
+		"invalid metrics namespace": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+				MetricsNamespace: "geo-ip",
+			},
+			wantErr: "metrics namespace may only contain letters, digits and underscores and must not start with a digit",
+		},
 		"invalid port": {
 			config: &config{
 				DbPath:           "test.db",
@@ -49,6 +437,26 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: "invalid port value, must be between 1 and 65536",
 		},
+		"invalid admin port": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				AdminPort:        65537,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+			},
+			wantErr: "invalid admin port value, must be between 1 and 65536",
+		},
+		"admin port same as main port": {
+			config: &config{
+				DbPath:           "test.db",
+				Port:             8080,
+				AdminPort:        8080,
+				IpHeader:         "some-header",
+				CachePurgePeriod: 10,
+			},
+			wantErr: "admin port must differ from the main port",
+		},
 		"missing ip header": {
 			config: &config{
 				DbPath:           "test.db",
@@ -105,6 +513,47 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidate_ReportsEveryCombinationError(t *testing.T) {
+	c := &config{
+		DbPath:           "test.db",
+		Port:             8080,
+		AdminPort:        8080,
+		IpHeader:         "some-header",
+		CachePurgePeriod: 10,
+		AllowEUOnly:      true,
+		AllowURL:         "https://example.com/allow.txt",
+		AllowURLInterval: time.Minute,
+		TLSCert:          "cert.pem",
+		CacheStaleGrace:  time.Second,
+		AuditTopic:       "verdicts",
+	}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate() expected an error, got nil")
+	}
+	want := []string{
+		"allow url cannot be combined with a rules file or allow-eu-only",
+		"admin port must differ from the main port",
+		"TLS certificate and key must be given together",
+		"cache stale grace requires a cache ttl",
+		"audit topic requires an audit broker",
+	}
+	for _, msg := range want {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Validate() error [%v] is missing [%s]", err, msg)
+		}
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != len(want) {
+		t.Errorf("Expected %d joined errors, got %v", len(want), err)
+	}
+
+	// A single-value check still fails first, before the combinations.
+	c.Port = 0
+	if err := c.Validate(); err == nil || err.Error() != "invalid port value, must be between 1 and 65536" {
+		t.Errorf("Expected only the port error, got %v", err)
+	}
+}
+
 func TestInitConfig(t *testing.T) {
 	// Helper to reset flags between tests
 	resetFlags := func() {
@@ -114,6 +563,7 @@ func TestInitConfig(t *testing.T) {
 	tests := map[string]struct {
 		name      string
 		args      []string
+		env       map[string]string
 		wantErr   bool
 		wantCheck func(*config) error
 	}{
@@ -179,6 +629,69 @@ func TestInitConfig(t *testing.T) {
 				return nil
 			},
 		},
+		"empty fallback db": {
+			args:    []string{"cmd", "-db=test.db", "-enable-empty-fallback-db"},
+			wantErr: false,
+			wantCheck: func(cfg *config) error {
+				if !cfg.EnableFallbackDB {
+					return errors.New("unexpected EnableFallbackDB, expected [true]")
+				}
+				return nil
+			},
+		},
+		"default excludes": {
+			args:    []string{"cmd", "-db=test.db"},
+			wantErr: false,
+			wantCheck: func(cfg *config) error {
+				if len(cfg.ExcludeCIDR) != 7 {
+					return fmt.Errorf("expected the 7 default excludes, got %v", cfg.ExcludeCIDR)
+				}
+				if !cfg.ExcludeCIDR[0].Contains(net.ParseIP("192.168.1.1")) {
+					return fmt.Errorf("expected 192.168.0.0/16 first, got %s", cfg.ExcludeCIDR[0])
+				}
+				return nil
+			},
+		},
+		"exclude none": {
+			args:    []string{"cmd", "-db=test.db", "-exclude=none"},
+			wantErr: false,
+			wantCheck: func(cfg *config) error {
+				if len(cfg.ExcludeCIDR) != 0 {
+					return fmt.Errorf("expected no excludes, got %v", cfg.ExcludeCIDR)
+				}
+				return nil
+			},
+		},
+		"explicit excludes override defaults": {
+			args:    []string{"cmd", "-db=test.db", "-exclude=100.64.0.0/10"},
+			wantErr: false,
+			wantCheck: func(cfg *config) error {
+				if len(cfg.ExcludeCIDR) != 1 || cfg.ExcludeCIDR[0].String() != "100.64.0.0/10" {
+					return fmt.Errorf("expected only 100.64.0.0/10, got %v", cfg.ExcludeCIDR)
+				}
+				return nil
+			},
+		},
+		"log level from env overrides the flag": {
+			args: []string{"cmd", "-db=test.db", "-log-level=error"},
+			env:  map[string]string{LogLevelEnv: "DEBUG"},
+			wantCheck: func(cfg *config) error {
+				if cfg.LogLevelFlag != "debug" {
+					return fmt.Errorf("expected the env log level debug, got %q", cfg.LogLevelFlag)
+				}
+				return nil
+			},
+		},
+		"unknown log level in env keeps the flag": {
+			args: []string{"cmd", "-db=test.db", "-log-level=error"},
+			env:  map[string]string{LogLevelEnv: "verbose"},
+			wantCheck: func(cfg *config) error {
+				if cfg.LogLevelFlag != "error" {
+					return fmt.Errorf("expected the flag log level error, got %q", cfg.LogLevelFlag)
+				}
+				return nil
+			},
+		},
 		"duplicate init": {
 			args: []string{
 				"cmd",
@@ -211,19 +724,25 @@ func TestInitConfig(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			resetFlags()
 			os.Args = tc.args
-			cfg = nil // Reset global config before each test
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			cfg.Store(nil) // Reset global config before each test
 			err := InitConfig()
 			if tc.wantErr {
 				if err == nil {
-					t.Errorf("InitConfig() expected error, got nil, config: %+v", cfg)
+					t.Errorf("InitConfig() expected error, got nil, config: %+v", cfg.Load())
+				}
+				if IsLoaded() {
+					t.Errorf("InitConfig() stored an invalid config: %+v", cfg.Load())
 				}
 			} else {
 				if err != nil {
-					t.Errorf("InitConfig() unexpected error: %v, config: %+v", err, cfg)
+					t.Errorf("InitConfig() unexpected error: %v, config: %+v", err, cfg.Load())
 				}
 				if tc.wantCheck != nil {
-					if checkErr := tc.wantCheck(cfg); checkErr != nil {
-						t.Errorf("Config check failed: %v config: %+v", checkErr, cfg)
+					if checkErr := tc.wantCheck(cfg.Load()); checkErr != nil {
+						t.Errorf("Config check failed: %v config: %+v", checkErr, cfg.Load())
 					}
 				}
 			}
@@ -233,11 +752,14 @@ func TestInitConfig(t *testing.T) {
 
 func TestGetStringGetters(t *testing.T) {
 	// Save original cfg and restore after test
-	origCfg := cfg
-	defer func() { cfg = origCfg }()
+	origCfg := cfg.Load()
+	defer func() { cfg.Store(origCfg) }()
 
 	t.Run("cfg is nil", func(t *testing.T) {
-		cfg = nil
+		cfg.Store(nil)
+		if IsLoaded() {
+			t.Error("IsLoaded() with nil cfg = true, want false")
+		}
 		dbPath := GetDbPath()
 		if dbPath != "" {
 			t.Errorf("GetDbPath() with nil cfg = %q, want empty string", dbPath)
@@ -246,6 +768,10 @@ func TestGetStringGetters(t *testing.T) {
 		if port != 0 {
 			t.Errorf("GetPort() with nil cfg = %d, want 0", port)
 		}
+		adminPort := GetAdminPort()
+		if adminPort != 0 {
+			t.Errorf("GetAdminPort() with nil cfg = %d, want 0", adminPort)
+		}
 		ipHeader := GetIpHeader()
 		if ipHeader != "" {
 			t.Errorf("GetIpHeader() with nil cfg = %q, want empty string", ipHeader)
@@ -281,9 +807,10 @@ func TestGetStringGetters(t *testing.T) {
 	})
 
 	t.Run("cfg is set", func(t *testing.T) {
-		cfg = &config{
+		cfg.Store(&config{
 			DbPath:               "test.db",
 			Port:                 8080,
+			AdminPort:            9090,
 			IpHeader:             "X-Forwarded-For",
 			LogLevelFlag:         "info",
 			MaxMindLicenseKey:    "test-key",
@@ -295,7 +822,7 @@ func TestGetStringGetters(t *testing.T) {
 				IP:   net.ParseIP("1.2.3.4"),
 				Mask: net.CIDRMask(32, 32),
 			}},
-		}
+		})
 		dbPath := GetDbPath()
 		if dbPath != "test.db" {
 			t.Errorf("GetDbPath() = %q, want %q", dbPath, "test.db")
@@ -304,6 +831,10 @@ func TestGetStringGetters(t *testing.T) {
 		if port != 8080 {
 			t.Errorf("GetPort() = %d, want %d", port, 8080)
 		}
+		adminPort := GetAdminPort()
+		if adminPort != 9090 {
+			t.Errorf("GetAdminPort() = %d, want %d", adminPort, 9090)
+		}
 		ipHeader := GetIpHeader()
 		if ipHeader != "X-Forwarded-For" {
 			t.Errorf("GetIpHeader() = %q, want %q", ipHeader, "X-Forwarded-For")
@@ -338,3 +869,145 @@ func TestGetStringGetters(t *testing.T) {
 		}
 	})
 }
+
+func TestGetters_ConcurrentSwap(t *testing.T) {
+	origCfg := cfg.Load()
+	defer func() { cfg.Store(origCfg) }()
+
+	snapshots := []*config{
+		{DbPath: "a.db", Port: 8080, AllowedCodes: map[string]bool{"US": true}},
+		{DbPath: "b.db", Port: 9090, AllowedCodes: map[string]bool{"DE": true}},
+	}
+	cfg.Store(snapshots[0])
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if path := GetDbPath(); path != "a.db" && path != "b.db" {
+					t.Errorf("GetDbPath() = %q, want a.db or b.db", path)
+					return
+				}
+				if port := GetPort(); port != 8080 && port != 9090 {
+					t.Errorf("GetPort() = %d, want 8080 or 9090", port)
+					return
+				}
+				_ = GetAllowedCodes()["US"]
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		cfg.Store(snapshots[i%2])
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := map[string][]string{
+		"":                 nil,
+		"country/iso_code": {"country", "iso_code"},
+		"/geo/cc/":         {"geo", "cc"},
+		"geo//cc":          {"geo", "", "cc"},
+	}
+	for value, want := range tests {
+		if got := parseFieldPath(value); !slices.Equal(got, want) {
+			t.Errorf("parseFieldPath(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestParseAllowedASNs(t *testing.T) {
+	got := parseAllowedASNs("US,AS15169,\nas13335,AS,ASX,AS99999999999")
+	want := map[uint]bool{15169: true, 13335: true}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for asn := range want {
+		if !got[asn] {
+			t.Errorf("Expected AS%d to be allowed, got %v", asn, got)
+		}
+	}
+}
+
+func TestParseAllowedCodes(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		want  []string
+	}{
+		"trailing comma":     {value: "US,DE,", want: []string{"US", "DE"}},
+		"leading comma":      {value: ",US", want: []string{"US"}},
+		"mixed separators":   {value: "US,\nDE\r\nfr , \n,IT", want: []string{"US", "DE", "FR", "IT"}},
+		"duplicate codes":    {value: "US,us, US\nUS", want: []string{"US"}},
+		"only separators":    {value: ", ,\n\n", want: []string{}},
+		"multi-line listing": {value: "US\nDE\n", want: []string{"US", "DE"}},
+		"ASN entries":        {value: "US,AS15169,as13335,AS", want: []string{"US", "AS"}},
+		"region macro":       {value: "@eu", want: regionMacros["EU"]},
+		"macro and extra":    {value: "@EU,US", want: append([]string{"US"}, regionMacros["EU"]...)},
+		"overlapping macros": {value: "@EU,@EEA,DE", want: regionMacros["EEA"]},
+		"unknown macro":      {value: "@XX,US", want: []string{"@XX", "US"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseAllowedCodes(tc.value)
+			if got[""] {
+				t.Fatal("empty token must not become an allow-list entry")
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Expected %d codes, got %v", len(tc.want), got)
+			}
+			for _, code := range tc.want {
+				if !got[code] {
+					t.Errorf("Expected %s to be allowed, got %v", code, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseExcludeCIDR_IPv6Defaults(t *testing.T) {
+	excludes := parseExcludeCIDR(defaultExcludeCIDR)
+	contains := func(ip string) bool {
+		for _, subnet := range excludes {
+			if subnet.Contains(net.ParseIP(ip)) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ip := range []string{"::1", "fd12:3456:789a::1", "fc00::1", "fe80::1", "febf:ffff::1", "10.1.2.3"} {
+		if !contains(ip) {
+			t.Errorf("Expected %s to be excluded by default", ip)
+		}
+	}
+	for _, ip := range []string{"2001:db8::1", "fec0::1", "fbff::1", "2606:4700::1111", "8.8.8.8"} {
+		if contains(ip) {
+			t.Errorf("Expected %s not to be excluded by default", ip)
+		}
+	}
+
+	// Invalid and IPv6 entries with host bits are handled alongside valid ones.
+	got := parseExcludeCIDR("fd00::1/8,fe80::/129,not-a-cidr,2001:db8::/32")
+	if len(got) != 2 || got[0].String() != "fd00::/8" || got[1].String() != "2001:db8::/32" {
+		t.Errorf("Expected fd00::/8 and 2001:db8::/32, got %v", got)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList("10.0.0.0/8,\n192.168.0.0/16,,10.0.0.0/8\r\n")
+	want := []string{"10.0.0.0/8", "192.168.0.0/16"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if excludes := parseExcludeCIDR("10.0.0.0/8\n192.168.0.0/16,"); len(excludes) != 2 {
+		t.Errorf("Expected 2 excludes from a multi-line value, got %d", len(excludes))
+	}
+}
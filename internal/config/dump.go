@@ -0,0 +1,105 @@
+package config
+
+import (
+	"net/url"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+// redacted replaces secrets in the startup configuration dump.
+const redacted = "[REDACTED]"
+
+// LogEffective logs the resolved configuration as a single debug event.
+// Secrets are redacted, so it is safe to call once the logger is set up.
+func LogEffective() {
+	c := cfg.Load()
+	if c == nil {
+		return
+	}
+	event := log.Debug()
+	if !event.Enabled() {
+		return
+	}
+
+	allow := make([]string, 0, len(c.AllowedCodes))
+	for code := range c.AllowedCodes {
+		allow = append(allow, code)
+	}
+	slices.Sort(allow)
+	exclude := make([]string, 0, len(c.ExcludeCIDR))
+	for _, subnet := range c.ExcludeCIDR {
+		exclude = append(exclude, subnet.String())
+	}
+
+	event.
+		Uint("port", c.Port).
+		Uint("admin_port", c.AdminPort).
+		Strs("allow", allow).
+		Str("allow_url", redactURL(c.AllowURL)).
+		Dur("allow_url_interval", c.AllowURLInterval).
+		Strs("exclude", exclude).
+		Bool("rules", c.Rules != nil).
+		Bool("allow_eu_only", c.AllowEUOnly).
+		Str("ip_header", c.IpHeader).
+		Bool("auth_query_ip", c.AuthQueryIP).
+		Str("source", sourceType(c)).
+		Str("db_path", c.DbPath).
+		Bool("db_file_lock", c.DbFileLock).
+		Str("asn_db_path", c.ASNDbPath).
+		Str("db_url", redactURL(c.DbURL)).
+		Bool("db_url_auth", c.DbURLAuth).
+		Str("update_webhook", redactURL(c.UpdateWebhook)).
+		Str("maxmind_license_key", redactSecret(c.MaxMindLicenseKey)).
+		Str("maxmind_account_id", redactSecret(c.MaxMindAccountId)).
+		Dur("fetch_interval", c.MaxMindFetchInterval).
+		Dur("fetcher_timeout", c.FetcherTimeout).
+		Dur("fetcher_stop_timeout", c.FetcherStopTimeout).
+		Str("fetch_source_name", c.FetchSourceName).
+		Dur("purge_interval", c.CachePurgePeriod).
+		Dur("purge_jitter", c.CachePurgeJitter).
+		Dur("cache_ttl", c.CacheTTL).
+		Dur("cache_ttl_notfound", c.CacheTTLNotFound).
+		Dur("cache_stale_grace", c.CacheStaleGrace).
+		Str("cache_eviction", c.CacheEviction).
+		Float64("cache_new_key_rate", c.CacheNewKeyRate).
+		Str("cache_snapshot", c.CacheSnapshot).
+		Dur("request_timeout", c.RequestTimeout).
+		Dur("db_age_interval", c.DBAgeInterval).
+		Dur("integrity_check_interval", c.IntegrityInterval).
+		Bool("expose_trace", c.ExposeTrace).
+		Str("access_log_mode", c.AccessLogMode).
+		Str("audit_broker", redactURL(c.AuditBroker)).
+		Str("audit_topic", c.AuditTopic).
+		Int("audit_buffer", c.AuditBuffer).
+		Str("audit_policy", c.AuditPolicy).
+		Str("responder", c.Responder).
+		Int("allow_status", c.AllowStatus).
+		Int("deny_status", c.DenyStatus).
+		Bool("monitor_mode", c.MonitorMode).
+		Msg("Effective configuration")
+}
+
+// sourceType names the database source main builds from c.
+func sourceType(c *config) string {
+	if c.MaxMindLicenseKey != "" || c.DbURL != "" {
+		return "remote"
+	}
+	return "disk"
+}
+
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURL hides the password in a URL's user info, if any.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redactSecret(raw)
+	}
+	return u.Redacted()
+}
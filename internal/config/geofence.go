@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Geofence is a circle on the earth's surface: requests located farther than
+// RadiusKm from (Lat, Lon) are denied.
+type Geofence struct {
+	Lat      float64
+	Lon      float64
+	RadiusKm float64
+}
+
+// parseGeofence parses the -geofence value "LAT,LON,RADIUS_KM". An empty value
+// disables geofencing and yields nil.
+func parseGeofence(value string) (*Geofence, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid geofence %q, expected LAT,LON,RADIUS_KM", value)
+	}
+	var nums [3]float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geofence %q: %w", value, err)
+		}
+		nums[i] = n
+	}
+	g := &Geofence{Lat: nums[0], Lon: nums[1], RadiusKm: nums[2]}
+	if g.Lat < -90 || g.Lat > 90 {
+		return nil, fmt.Errorf("invalid geofence latitude %v, must be between -90 and 90", g.Lat)
+	}
+	if g.Lon < -180 || g.Lon > 180 {
+		return nil, fmt.Errorf("invalid geofence longitude %v, must be between -180 and 180", g.Lon)
+	}
+	if g.RadiusKm <= 0 {
+		return nil, fmt.Errorf("invalid geofence radius %v, must be greater than zero", g.RadiusKm)
+	}
+	return g, nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseGeofence(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected *Geofence
+		wantErr  bool
+	}{
+		{name: "empty disables", value: "", expected: nil},
+		{name: "valid", value: "52.52, 13.405, 100", expected: &Geofence{Lat: 52.52, Lon: 13.405, RadiusKm: 100}},
+		{name: "missing radius", value: "52.52,13.405", wantErr: true},
+		{name: "not a number", value: "north,13.405,100", wantErr: true},
+		{name: "latitude out of range", value: "91,13.405,100", wantErr: true},
+		{name: "longitude out of range", value: "52.52,181,100", wantErr: true},
+		{name: "zero radius", value: "52.52,13.405,0", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseGeofence(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tc.wantErr, err)
+			}
+			if tc.expected == nil {
+				if got != nil {
+					t.Errorf("Expected no geofence, got %+v", got)
+				}
+				return
+			}
+			if got == nil || *got != *tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
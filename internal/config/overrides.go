@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ipOverrides maps a canonical IP string to its forced verdict. It is swapped
+// as a whole by ReloadIPOverrides, so lookups on the /auth hot path never lock.
+var ipOverrides atomic.Pointer[map[string]bool]
+
+// parseIPOverrides parses "ip=allow" / "ip=deny" entries separated by commas
+// or newlines. Lines starting with '#' are ignored.
+func parseIPOverrides(value string) (map[string]bool, error) {
+	overrides := make(map[string]bool)
+	for _, entry := range splitList(value) {
+		if strings.HasPrefix(entry, "#") {
+			continue
+		}
+		addr, verdict, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ip override %q, expected ip=allow or ip=deny", entry)
+		}
+		ip := net.ParseIP(strings.TrimSpace(addr))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip in override %q", entry)
+		}
+		switch strings.ToLower(strings.TrimSpace(verdict)) {
+		case "allow":
+			overrides[ip.String()] = true
+		case "deny":
+			overrides[ip.String()] = false
+		default:
+			return nil, fmt.Errorf("invalid verdict in override %q, expected allow or deny", entry)
+		}
+	}
+	return overrides, nil
+}
+
+// loadIPOverrides combines the -ip-override entries with the contents of
+// -ip-override-file. File entries win over flag entries for the same IP.
+func loadIPOverrides(list, file string) (map[string]bool, error) {
+	overrides, err := parseIPOverrides(list)
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		return overrides, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ip override file: %w", err)
+	}
+	fromFile, err := parseIPOverrides(string(data))
+	if err != nil {
+		return nil, err
+	}
+	for ip, allowed := range fromFile {
+		overrides[ip] = allowed
+	}
+	return overrides, nil
+}
+
+// ReloadIPOverrides re-reads the IP overrides, e.g. on SIGHUP. The current
+// overrides are kept when the new ones fail to parse.
+func ReloadIPOverrides() error {
+	c := cfg.Load()
+	if c == nil {
+		return nil
+	}
+	overrides, err := loadIPOverrides(c.IPOverrideList, c.IPOverrideFile)
+	if err != nil {
+		return err
+	}
+	ipOverrides.Store(&overrides)
+	return nil
+}
+
+// GetIPOverride returns the forced verdict for ip, if there is one.
+func GetIPOverride(ip net.IP) (allowed bool, found bool) {
+	overrides := ipOverrides.Load()
+	if overrides == nil || len(*overrides) == 0 {
+		return false, false
+	}
+	allowed, found = (*overrides)[ip.String()]
+	return allowed, found
+}
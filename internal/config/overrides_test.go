@@ -0,0 +1,73 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIPOverrides(t *testing.T) {
+	overrides, err := parseIPOverrides("203.0.113.5=deny, 198.51.100.7=ALLOW\n2001:db8::1=deny,")
+	if err != nil {
+		t.Fatalf("parseIPOverrides failed: %v", err)
+	}
+	want := map[string]bool{"203.0.113.5": false, "198.51.100.7": true, "2001:db8::1": false}
+	if len(overrides) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, overrides)
+	}
+	for ip, allowed := range want {
+		if got, ok := overrides[ip]; !ok || got != allowed {
+			t.Errorf("Expected %s=%v, got %v (found %v)", ip, allowed, got, ok)
+		}
+	}
+
+	for _, bad := range []string{"203.0.113.5", "nope=allow", "203.0.113.5=maybe"} {
+		if _, err := parseIPOverrides(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestReloadIPOverrides(t *testing.T) {
+	origCfg := cfg.Load()
+	defer func() {
+		cfg.Store(origCfg)
+		ipOverrides.Store(nil)
+	}()
+
+	file := filepath.Join(t.TempDir(), "overrides")
+	if err := os.WriteFile(file, []byte("# partners\n198.51.100.7=allow\n"), 0o644); err != nil {
+		t.Fatalf("failed to write override file: %v", err)
+	}
+	cfg.Store(&config{IPOverrideList: "203.0.113.5=deny", IPOverrideFile: file})
+	if err := ReloadIPOverrides(); err != nil {
+		t.Fatalf("ReloadIPOverrides failed: %v", err)
+	}
+	if allowed, ok := GetIPOverride(net.ParseIP("198.51.100.7")); !ok || !allowed {
+		t.Errorf("Expected 198.51.100.7 to be force-allowed, got %v %v", allowed, ok)
+	}
+	if allowed, ok := GetIPOverride(net.ParseIP("203.0.113.5")); !ok || allowed {
+		t.Errorf("Expected 203.0.113.5 to be force-denied, got %v %v", allowed, ok)
+	}
+
+	// A reload picks up file changes, and a broken file keeps the old set.
+	if err := os.WriteFile(file, []byte("198.51.100.7=deny\n"), 0o644); err != nil {
+		t.Fatalf("failed to rewrite override file: %v", err)
+	}
+	if err := ReloadIPOverrides(); err != nil {
+		t.Fatalf("ReloadIPOverrides failed: %v", err)
+	}
+	if allowed, ok := GetIPOverride(net.ParseIP("198.51.100.7")); !ok || allowed {
+		t.Errorf("Expected 198.51.100.7 to be force-denied after reload, got %v %v", allowed, ok)
+	}
+	if err := os.WriteFile(file, []byte("garbage\n"), 0o644); err != nil {
+		t.Fatalf("failed to rewrite override file: %v", err)
+	}
+	if err := ReloadIPOverrides(); err == nil {
+		t.Error("Expected a broken override file to fail the reload")
+	}
+	if _, ok := GetIPOverride(net.ParseIP("198.51.100.7")); !ok {
+		t.Error("Expected the previous overrides to survive a failed reload")
+	}
+}
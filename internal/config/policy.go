@@ -0,0 +1,65 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// PolicyFingerprint returns a digest of the settings a cached verdict
+// depends on: the allow-list, rules, geofence, IP overrides, enforcement and
+// how the country is read from a record. Two configs with the same
+// fingerprint decide every IP alike for the same database. It is empty
+// before InitConfig.
+func PolicyFingerprint() string {
+	c := cfg.Load()
+	if c == nil {
+		return ""
+	}
+	var overrides map[string]bool
+	if o := ipOverrides.Load(); o != nil {
+		overrides = *o
+	}
+	excluded := make([]string, 0, len(c.ExcludeCIDR))
+	for _, network := range c.ExcludeCIDR {
+		excluded = append(excluded, network.String())
+	}
+	// The list fetched from AllowURL is left out: it is not loaded yet when a
+	// snapshot is restored, and its first fetch purges the cache anyway.
+	policy := struct {
+		AllowedCodes       map[string]bool
+		AllowedASNs        map[uint]bool
+		AllowURL           string
+		AllowEUOnly        bool
+		Rules              *RuleSet
+		Geofence           *Geofence
+		IPOverrides        map[string]bool
+		MonitorMode        bool
+		ExcludeCIDR        []string
+		CountryFieldPath   []string
+		CountrySourceChain []string
+		MultiCountryMode   string
+		Locale             string
+	}{
+		AllowedCodes:       c.AllowedCodes,
+		AllowedASNs:        c.AllowedASNs,
+		AllowURL:           c.AllowURL,
+		AllowEUOnly:        c.AllowEUOnly,
+		Rules:              c.Rules,
+		Geofence:           c.Geofence,
+		IPOverrides:        overrides,
+		MonitorMode:        c.MonitorMode,
+		ExcludeCIDR:        excluded,
+		CountryFieldPath:   c.CountryFieldPath,
+		CountrySourceChain: c.CountrySourceChain,
+		MultiCountryMode:   c.MultiCountryMode,
+		Locale:             c.Locale,
+	}
+	// Maps marshal with sorted keys, so equal policies encode alike.
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
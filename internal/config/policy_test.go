@@ -0,0 +1,53 @@
+package config
+
+import "testing"
+
+func TestPolicyFingerprint(t *testing.T) {
+	origCfg := cfg.Load()
+	defer cfg.Store(origCfg)
+
+	cfg.Store(nil)
+	if got := PolicyFingerprint(); got != "" {
+		t.Errorf("Expected no fingerprint before InitConfig, got %q", got)
+	}
+
+	base := func() *config {
+		return &config{
+			AllowedCodes: map[string]bool{"US": true, "DE": true},
+			IpHeader:     "some-header",
+			Port:         8080,
+		}
+	}
+	cfg.Store(base())
+	want := PolicyFingerprint()
+	if want == "" {
+		t.Fatal("Expected a fingerprint once a config is stored")
+	}
+
+	// Settings that do not decide verdicts keep the fingerprint.
+	same := base()
+	same.Port = 9090
+	same.CacheTTL = 1
+	cfg.Store(same)
+	if got := PolicyFingerprint(); got != want {
+		t.Errorf("Expected an unchanged policy to keep its fingerprint, got %q and %q", want, got)
+	}
+
+	changes := map[string]func(c *config){
+		"allow-list": func(c *config) { c.AllowedCodes = map[string]bool{"US": true} },
+		"rules":      func(c *config) { c.Rules = &RuleSet{Default: RuleActionAllow} },
+		"geofence":   func(c *config) { c.Geofence = &Geofence{Lat: 1, Lon: 2, RadiusKm: 3} },
+		"enforce":    func(c *config) { c.MonitorMode = true },
+		"eu only":    func(c *config) { c.AllowEUOnly = true },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			c := base()
+			change(c)
+			cfg.Store(c)
+			if got := PolicyFingerprint(); got == want {
+				t.Errorf("Expected a changed %s to change the fingerprint", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regionMacros lists the member ISO codes of the groups an "@NAME" token in
+// -allow or a country rule stands for.
+var regionMacros = map[string][]string{
+	"EU": {
+		"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+		"IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+	},
+	"EEA": {
+		"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+		"IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+		"IS", "LI", "NO",
+	},
+	"NATO": {
+		"AL", "BE", "BG", "CA", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GB", "GR", "HR",
+		"HU", "IS", "IT", "LT", "LU", "LV", "ME", "MK", "NL", "NO", "PL", "PT", "RO", "SE",
+		"SI", "SK", "TR", "US",
+	},
+}
+
+// isRegionMacro reports whether an upper-case token is an "@NAME" macro,
+// known or not.
+func isRegionMacro(token string) bool {
+	return strings.HasPrefix(token, "@")
+}
+
+// expandRegion returns the member codes of an upper-case "@NAME" token.
+func expandRegion(token string) ([]string, error) {
+	codes, ok := regionMacros[strings.TrimPrefix(token, "@")]
+	if !ok {
+		return nil, fmt.Errorf("unknown region macro %q", token)
+	}
+	return codes, nil
+}
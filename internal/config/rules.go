@@ -0,0 +1,207 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Rule types and actions of a rules file.
+const (
+	RuleTypeIP          = "ip"
+	RuleTypeCountry     = "country"
+	RuleTypeContinent   = "continent"
+	RuleTypeSubdivision = "subdivision"
+
+	RuleActionAllow = "allow"
+	RuleActionDeny  = "deny"
+)
+
+type (
+	// Rule matches one attribute of a request. IP rules match an address or
+	// CIDR; the other types match an upper-case code, subdivisions in
+	// ISO 3166-2 form such as "US-CA".
+	Rule struct {
+		Type   string `json:"type"`
+		Match  string `json:"match"`
+		Action string `json:"action"`
+
+		network *net.IPNet
+		// countries holds the members of a country rule matching a region
+		// macro such as "@EU".
+		countries map[string]bool
+	}
+
+	// RuleSet is an ordered list of rules where the first match wins, and
+	// Default applies when no rule matches.
+	RuleSet struct {
+		Rules   []Rule `json:"rules"`
+		Default string `json:"default"`
+	}
+
+	// RuleSubject is what rules are evaluated against.
+	RuleSubject struct {
+		IP           net.IP
+		Country      string
+		Continent    string
+		Subdivisions []string
+	}
+)
+
+// parseRules parses and validates a JSON rules document. A missing default
+// action denies.
+func parseRules(data []byte) (*RuleSet, error) {
+	var rs RuleSet
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rs); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	rs.Default = strings.ToLower(strings.TrimSpace(rs.Default))
+	if rs.Default == "" {
+		rs.Default = RuleActionDeny
+	}
+	if rs.Default != RuleActionAllow && rs.Default != RuleActionDeny {
+		return nil, fmt.Errorf("invalid default action %q, expected allow or deny", rs.Default)
+	}
+	for i := range rs.Rules {
+		if err := rs.Rules[i].normalize(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return &rs, nil
+}
+
+func (r *Rule) normalize() error {
+	r.Type = strings.ToLower(strings.TrimSpace(r.Type))
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	r.Match = strings.TrimSpace(r.Match)
+	if r.Action != RuleActionAllow && r.Action != RuleActionDeny {
+		return fmt.Errorf("invalid action %q, expected allow or deny", r.Action)
+	}
+	if r.Match == "" {
+		return fmt.Errorf("empty match for %s rule", r.Type)
+	}
+	switch r.Type {
+	case RuleTypeIP:
+		network, err := parseNetwork(r.Match)
+		if err != nil {
+			return err
+		}
+		r.network = network
+	case RuleTypeCountry:
+		r.Match = strings.ToUpper(r.Match)
+		if isRegionMacro(r.Match) {
+			members, err := expandRegion(r.Match)
+			if err != nil {
+				return err
+			}
+			r.countries = make(map[string]bool, len(members))
+			for _, member := range members {
+				r.countries[member] = true
+			}
+		}
+	case RuleTypeContinent, RuleTypeSubdivision:
+		r.Match = strings.ToUpper(r.Match)
+	default:
+		return fmt.Errorf("invalid type %q, expected ip, country, continent or subdivision", r.Type)
+	}
+	return nil
+}
+
+// parseNetwork parses a CIDR, or a single IP as a host network.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %q", s)
+	}
+	bits := 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// loadRules reads the rules file; an empty path disables rules.
+func loadRules(path string) (*RuleSet, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	return parseRules(data)
+}
+
+func (r *Rule) matches(s RuleSubject) bool {
+	switch r.Type {
+	case RuleTypeIP:
+		return s.IP != nil && r.network.Contains(s.IP)
+	case RuleTypeCountry:
+		if r.countries != nil {
+			return r.countries[s.Country]
+		}
+		return s.Country == r.Match
+	case RuleTypeContinent:
+		return s.Continent == r.Match
+	case RuleTypeSubdivision:
+		for _, sub := range s.Subdivisions {
+			if sub == r.Match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usesRecord reports whether any rule matches on the continent or
+// subdivisions of the record; a nil set has no rules.
+func (rs *RuleSet) usesRecord() bool {
+	if rs == nil {
+		return false
+	}
+	for _, r := range rs.Rules {
+		if r.Type == RuleTypeContinent || r.Type == RuleTypeSubdivision {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate returns the action of the first matching rule and its index, or
+// the default action and -1 when no rule matches.
+func (rs *RuleSet) Evaluate(s RuleSubject) (allowed bool, index int) {
+	for i := range rs.Rules {
+		if rs.Rules[i].matches(s) {
+			return rs.Rules[i].Action == RuleActionAllow, i
+		}
+	}
+	return rs.Default == RuleActionAllow, -1
+}
+
+// EvaluateCountry evaluates the rules for a country alone, as Evaluate would
+// for any request from it. ok is false when an ip, continent or subdivision
+// rule comes before the deciding one, since whether it matches depends on
+// the request.
+func (rs *RuleSet) EvaluateCountry(country string) (allowed bool, index int, ok bool) {
+	for i := range rs.Rules {
+		if rs.Rules[i].Type != RuleTypeCountry {
+			return false, -1, false
+		}
+		if rs.Rules[i].matches(RuleSubject{Country: country}) {
+			return rs.Rules[i].Action == RuleActionAllow, i, true
+		}
+	}
+	return rs.Default == RuleActionAllow, -1, true
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	rs, err := parseRules([]byte(`{
+		"rules": [
+			{"type": "IP", "match": "203.0.113.5", "action": "Allow"},
+			{"type": "subdivision", "match": "us-tx", "action": "deny"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("parseRules failed: %v", err)
+	}
+	if rs.Default != RuleActionDeny {
+		t.Errorf("Expected a missing default to deny, got %q", rs.Default)
+	}
+	if rs.Rules[0].Type != RuleTypeIP || rs.Rules[0].Action != RuleActionAllow {
+		t.Errorf("Expected type and action to be lower-cased, got %+v", rs.Rules[0])
+	}
+	if rs.Rules[1].Match != "US-TX" {
+		t.Errorf("Expected codes to be upper-cased, got %q", rs.Rules[1].Match)
+	}
+
+	for name, bad := range map[string]string{
+		"invalid JSON":    `{"rules": [`,
+		"unknown field":   `{"rules": [], "fallback": "allow"}`,
+		"invalid default": `{"default": "maybe"}`,
+		"invalid type":    `{"rules": [{"type": "city", "match": "Austin", "action": "deny"}]}`,
+		"invalid action":  `{"rules": [{"type": "country", "match": "US", "action": "block"}]}`,
+		"empty match":     `{"rules": [{"type": "country", "match": "", "action": "deny"}]}`,
+		"invalid ip":      `{"rules": [{"type": "ip", "match": "nope", "action": "deny"}]}`,
+		"invalid CIDR":    `{"rules": [{"type": "ip", "match": "10.0.0.0/99", "action": "deny"}]}`,
+		"unknown macro":   `{"rules": [{"type": "country", "match": "@MARS", "action": "deny"}]}`,
+	} {
+		if _, err := parseRules([]byte(bad)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestRuleSet_Evaluate(t *testing.T) {
+	texan := RuleSubject{
+		IP:           net.ParseIP("198.51.100.7"),
+		Country:      "US",
+		Continent:    "NA",
+		Subdivisions: []string{"US-TX"},
+	}
+	californian := RuleSubject{
+		IP:           net.ParseIP("192.0.2.1"),
+		Country:      "US",
+		Continent:    "NA",
+		Subdivisions: []string{"US-CA"},
+	}
+	german := RuleSubject{IP: net.ParseIP("2001:db8::1"), Country: "DE", Continent: "EU"}
+
+	tests := []struct {
+		name    string
+		rules   string
+		subject RuleSubject
+		allowed bool
+		index   int
+	}{
+		{
+			name:    "subdivision deny before country allow",
+			rules:   `{"rules": [{"type": "subdivision", "match": "US-TX", "action": "deny"}, {"type": "country", "match": "US", "action": "allow"}]}`,
+			subject: texan,
+			allowed: false,
+			index:   0,
+		},
+		{
+			name:    "country allow before subdivision deny",
+			rules:   `{"rules": [{"type": "country", "match": "US", "action": "allow"}, {"type": "subdivision", "match": "US-TX", "action": "deny"}]}`,
+			subject: texan,
+			allowed: true,
+			index:   0,
+		},
+		{
+			name:    "unmatched subdivision falls through to country",
+			rules:   `{"rules": [{"type": "subdivision", "match": "US-TX", "action": "deny"}, {"type": "country", "match": "US", "action": "allow"}]}`,
+			subject: californian,
+			allowed: true,
+			index:   1,
+		},
+		{
+			name:    "ip allow before subdivision deny",
+			rules:   `{"rules": [{"type": "ip", "match": "198.51.100.0/24", "action": "allow"}, {"type": "subdivision", "match": "US-TX", "action": "deny"}]}`,
+			subject: texan,
+			allowed: true,
+			index:   0,
+		},
+		{
+			name:    "continent match",
+			rules:   `{"rules": [{"type": "continent", "match": "eu", "action": "allow"}]}`,
+			subject: german,
+			allowed: true,
+			index:   0,
+		},
+		{
+			name:    "region macro deny",
+			rules:   `{"default": "allow", "rules": [{"type": "country", "match": "@eu", "action": "deny"}]}`,
+			subject: german,
+			allowed: false,
+			index:   0,
+		},
+		{
+			name:    "region macro skips non-members",
+			rules:   `{"rules": [{"type": "country", "match": "@EU", "action": "allow"}]}`,
+			subject: texan,
+			allowed: false,
+			index:   -1,
+		},
+		{
+			name:    "default deny fallthrough",
+			rules:   `{"rules": [{"type": "country", "match": "US", "action": "allow"}]}`,
+			subject: german,
+			allowed: false,
+			index:   -1,
+		},
+		{
+			name:    "default allow fallthrough",
+			rules:   `{"default": "allow", "rules": [{"type": "continent", "match": "NA", "action": "deny"}]}`,
+			subject: german,
+			allowed: true,
+			index:   -1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := parseRules([]byte(tc.rules))
+			if err != nil {
+				t.Fatalf("parseRules failed: %v", err)
+			}
+			allowed, index := rs.Evaluate(tc.subject)
+			if allowed != tc.allowed || index != tc.index {
+				t.Errorf("Expected allowed=%v index=%d, got allowed=%v index=%d", tc.allowed, tc.index, allowed, index)
+			}
+		})
+	}
+}
+
+func TestRuleSet_EvaluateCountry(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		country string
+		allowed bool
+		index   int
+		ok      bool
+	}{
+		{
+			name:    "country rule decides",
+			rules:   `{"rules": [{"type": "country", "match": "DE", "action": "deny"}, {"type": "country", "match": "@EU", "action": "allow"}]}`,
+			country: "FR",
+			allowed: true,
+			index:   1,
+			ok:      true,
+		},
+		{
+			name:    "default applies",
+			rules:   `{"default": "allow", "rules": [{"type": "country", "match": "DE", "action": "deny"}]}`,
+			country: "US",
+			allowed: true,
+			index:   -1,
+			ok:      true,
+		},
+		{
+			name:    "country rule before a subdivision rule",
+			rules:   `{"rules": [{"type": "country", "match": "US", "action": "allow"}, {"type": "subdivision", "match": "US-TX", "action": "deny"}]}`,
+			country: "US",
+			allowed: true,
+			index:   0,
+			ok:      true,
+		},
+		{
+			name:    "subdivision rule first",
+			rules:   `{"rules": [{"type": "subdivision", "match": "US-TX", "action": "deny"}, {"type": "country", "match": "US", "action": "allow"}]}`,
+			country: "US",
+			index:   -1,
+		},
+		{
+			name:    "ip rule first",
+			rules:   `{"rules": [{"type": "ip", "match": "192.0.2.0/24", "action": "allow"}]}`,
+			country: "US",
+			index:   -1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := parseRules([]byte(tc.rules))
+			if err != nil {
+				t.Fatalf("parseRules failed: %v", err)
+			}
+			allowed, index, ok := rs.EvaluateCountry(tc.country)
+			if allowed != tc.allowed || index != tc.index || ok != tc.ok {
+				t.Errorf("Expected allowed=%v index=%d ok=%v, got allowed=%v index=%d ok=%v", tc.allowed, tc.index, tc.ok, allowed, index, ok)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	if rs, err := loadRules(""); rs != nil || err != nil {
+		t.Errorf("Expected no rules without a file, got %+v %v", rs, err)
+	}
+	if _, err := loadRules(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected a missing rules file to fail")
+	}
+
+	file := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(file, []byte(`{"rules": [{"type": "country", "match": "US", "action": "allow"}]}`), 0o644); err != nil {
+		t.Fatalf("failed to write rules file: %v", err)
+	}
+	rs, err := loadRules(file)
+	if err != nil {
+		t.Fatalf("loadRules failed: %v", err)
+	}
+	if len(rs.Rules) != 1 {
+		t.Errorf("Expected 1 rule, got %+v", rs.Rules)
+	}
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Breaker states, also the values reported by the breaker state gauge.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// fetchBreaker stops scheduled fetches after threshold consecutive failures.
+// Once cooldown has passed it lets a single trial fetch through (half-open);
+// success closes it again, failure re-opens it for another cooldown.
+type fetchBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+// newFetchBreaker returns nil, a breaker that never opens, when threshold
+// is not positive.
+func newFetchBreaker(threshold int, cooldown time.Duration) *fetchBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &fetchBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow reports whether a fetch may be attempted now.
+func (b *fetchBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == breakerOpen {
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of an attempted fetch.
+func (b *fetchBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *fetchBreaker) setState(state int) {
+	if b.state != state {
+		log.Info().Int("from", b.state).Int("to", state).Int("failures", b.failures).Msg("fetch breaker state changed")
+	}
+	b.state = state
+	metrics.FetchBreakerState.Set(float64(state))
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestFetchBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := newFetchBreaker(3, time.Minute)
+	b.now = clock.Now
+	fail := errors.New("outage")
+
+	for i := range 2 {
+		b.record(fail)
+		if !b.allow() {
+			t.Fatalf("breaker opened after %d failures, threshold is 3", i+1)
+		}
+	}
+	b.record(fail)
+	if b.allow() {
+		t.Fatal("expected the breaker to open after 3 consecutive failures")
+	}
+	if got := testutil.ToFloat64(metrics.FetchBreakerState); got != breakerOpen {
+		t.Errorf("expected breaker state gauge %d, got %v", breakerOpen, got)
+	}
+
+	clock.Advance(30 * time.Second)
+	if b.allow() {
+		t.Fatal("expected the breaker to stay open during the cooldown")
+	}
+
+	// After the cooldown a single trial is let through; failing it re-opens.
+	clock.Advance(30 * time.Second)
+	if !b.allow() {
+		t.Fatal("expected the breaker to half-open after the cooldown")
+	}
+	if got := testutil.ToFloat64(metrics.FetchBreakerState); got != breakerHalfOpen {
+		t.Errorf("expected breaker state gauge %d, got %v", breakerHalfOpen, got)
+	}
+	b.record(fail)
+	if b.allow() {
+		t.Fatal("expected a failed trial to re-open the breaker")
+	}
+
+	clock.Advance(time.Minute)
+	if !b.allow() {
+		t.Fatal("expected the breaker to half-open after another cooldown")
+	}
+	b.record(nil)
+	if got := testutil.ToFloat64(metrics.FetchBreakerState); got != breakerClosed {
+		t.Errorf("expected breaker state gauge %d, got %v", breakerClosed, got)
+	}
+
+	// A closed breaker needs the full threshold again to re-open.
+	b.record(fail)
+	if !b.allow() {
+		t.Error("expected a single failure not to re-open a closed breaker")
+	}
+}
+
+func TestFetchBreaker_Disabled(t *testing.T) {
+	b := newFetchBreaker(0, time.Minute)
+	for range 10 {
+		b.record(errors.New("outage"))
+	}
+	if !b.allow() {
+		t.Error("expected a disabled breaker to always allow fetches")
+	}
+}
+
+func TestRemoteFetcher_scheduledFetch_SkipsWhenOpen(t *testing.T) {
+	server := newTestServer(testResponse{statusCode: http.StatusInternalServerError, body: []byte("error")})
+	defer server.close()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.maxRetries = 1
+	rf.breaker = newFetchBreaker(1, time.Hour)
+	rf.breaker.now = clock.Now
+
+	attempts := func() float64 {
+		return testutil.ToFloat64(metrics.FetchAttemptsTotal.WithLabelValues("maxmind"))
+	}
+
+	before := attempts()
+	rf.scheduledFetch()
+	if got := attempts(); got != before+1 {
+		t.Fatalf("expected one fetch attempt, got %v", got-before)
+	}
+
+	before = attempts()
+	rf.scheduledFetch()
+	if got := attempts(); got != before {
+		t.Errorf("expected the open breaker to skip the fetch, got %v attempts", got-before)
+	}
+
+	clock.Advance(time.Hour)
+	rf.scheduledFetch()
+	if got := attempts(); got != before+1 {
+		t.Errorf("expected a trial fetch after the cooldown, got %v attempts", got-before)
+	}
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// BytesSource serves a database the caller already holds in memory, for
+// embedders that build or fetch the database themselves. It is ready as soon
+// as it is created.
+type BytesSource struct {
+	mutex  sync.RWMutex
+	data   []byte
+	reader ReaderInterface
+	info   DBInfo
+}
+
+// NewBytesSource parses b as a MaxMind database. b must not be modified
+// afterwards, since the reader is backed by it.
+func NewBytesSource(b []byte) (GeoIPSource, error) {
+	reader, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database from bytes")
+	}
+	return &BytesSource{
+		data:   b,
+		reader: reader,
+		info:   readerInfo(reader),
+	}, nil
+}
+
+func (s *BytesSource) Start() error {
+	return nil
+}
+
+func (s *BytesSource) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reader == nil {
+		return nil
+	}
+	err := s.reader.Close()
+	s.reader = nil
+	return err
+}
+
+// Reload re-parses the bytes, reopening the source after a Stop.
+func (s *BytesSource) Reload() error {
+	reader, err := maxminddb.FromBytes(s.data)
+	if err != nil {
+		return errors.Wrap(err, "failed to open database from bytes")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reader != nil {
+		_ = s.reader.Close()
+	}
+	s.reader = reader
+	s.info = readerInfo(reader)
+	return nil
+}
+
+func (s *BytesSource) IsReady() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.reader != nil
+}
+
+func (s *BytesSource) GetReader() ReaderInterface {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.reader
+}
+
+func (s *BytesSource) Info() DBInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.info
+}
+
+func (s *BytesSource) Snapshot() (io.ReadCloser, int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.reader == nil {
+		return nil, 0, ErrNoDatabase
+	}
+	return io.NopCloser(bytes.NewReader(s.data)), int64(len(s.data)), nil
+}
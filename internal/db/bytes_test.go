@@ -0,0 +1,61 @@
+package db
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestBytesSource(t *testing.T) {
+	data := GenerateValidMockMMDB()
+	source, err := NewBytesSource(data)
+	if err != nil {
+		t.Fatalf("NewBytesSource failed: %v", err)
+	}
+	if err := source.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if !source.IsReady() {
+		t.Fatal("Expected the source to be ready once created")
+	}
+	if info := source.Info(); info.DatabaseType == "" {
+		t.Errorf("Expected database metadata, got %+v", info)
+	}
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := source.GetReader().Lookup(net.ParseIP("8.8.8.8"), &record); err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	snapshot, size, err := source.(Snapshotter).Snapshot()
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("Snapshot = %d, %v; want %d bytes", size, err, len(data))
+	}
+	got, _ := io.ReadAll(snapshot)
+	snapshot.Close()
+	if len(got) != len(data) {
+		t.Errorf("Expected the snapshot to return the database bytes, got %d bytes", len(got))
+	}
+
+	// Stop closes the reader and Reload re-parses the bytes.
+	if err := source.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if source.IsReady() {
+		t.Error("Expected the source not to be ready after Stop")
+	}
+	if err := source.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !source.IsReady() {
+		t.Error("Expected the source to be ready after Reload")
+	}
+
+	if _, err := NewBytesSource([]byte("not a database")); err == nil {
+		t.Error("Expected invalid bytes to be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// DBInfo describes the database currently installed in a source.
+type DBInfo struct {
+	DatabaseType string `json:"database_type"`
+	BuildEpoch   uint   `json:"build_epoch"`
+}
+
+// readerInfo extracts the metadata of a MaxMind reader. Readers that are not
+// backed by a real database (e.g. test doubles) yield an empty DBInfo.
+func readerInfo(reader ReaderInterface) DBInfo {
+	reader = unwrapReader(reader)
+	if m, ok := reader.(*mmapReader); ok {
+		reader = m.Reader
+	}
+	mr, ok := reader.(*maxminddb.Reader)
+	if !ok {
+		return DBInfo{}
+	}
+	return DBInfo{
+		DatabaseType: mr.Metadata.DatabaseType,
+		BuildEpoch:   mr.Metadata.BuildEpoch,
+	}
+}
+
+// checkDatabaseType verifies the database type contains the expected edition
+// substring. A mismatch is only logged unless strict is set, in which case it
+// is returned as an error so the reader is not installed.
+func checkDatabaseType(info DBInfo, expected string, strict bool) error {
+	if expected == "" || info.DatabaseType == "" {
+		return nil
+	}
+	if strings.Contains(info.DatabaseType, expected) {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("unexpected database type %q, expected it to contain %q", info.DatabaseType, expected)
+	}
+	log.Warn().
+		Str("database_type", info.DatabaseType).
+		Str("expected", expected).
+		Msg("database type does not match the expected edition")
+	return nil
+}
+
+// recordFileSize reports the size of the database installed at path.
+func recordFileSize(source, path string) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("failed to stat installed database")
+		return
+	}
+	metrics.DBFileSize.WithLabelValues(source).Set(float64(stat.Size()))
+}
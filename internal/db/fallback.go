@@ -0,0 +1,97 @@
+package db
+
+import (
+	_ "embed"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate go run gen_fallback.go
+
+// fallbackDB is an empty database: it resolves every IP to no country, so
+// /auth keeps answering (denying non-excluded IPs) until the primary is ready.
+//
+//go:embed fallback.mmdb
+var fallbackDB []byte
+
+type (
+	// FallbackSource serves lookups from the embedded fallback database
+	// whenever the primary source is not ready.
+	FallbackSource struct {
+		GeoIPSource
+		fallback *fallbackReader
+		info     DBInfo
+	}
+
+	// fallbackReader marks readers handed out by the fallback database.
+	fallbackReader struct {
+		ReaderInterface
+	}
+)
+
+// WithFallback wraps primary so that it always reports ready, answering from
+// the embedded fallback database until primary is ready.
+func WithFallback(primary GeoIPSource) (*FallbackSource, error) {
+	reader, err := maxminddb.FromBytes(fallbackDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open embedded fallback database")
+	}
+	return &FallbackSource{
+		GeoIPSource: primary,
+		fallback:    &fallbackReader{ReaderInterface: reader},
+		info:        readerInfo(reader),
+	}, nil
+}
+
+// IsFallback reports whether reader comes from the embedded fallback database.
+func IsFallback(reader ReaderInterface) bool {
+	_, ok := reader.(*fallbackReader)
+	return ok
+}
+
+func (f *FallbackSource) Stop() error {
+	err := f.GeoIPSource.Stop()
+	if closeErr := f.fallback.Close(); closeErr != nil {
+		log.Error().Err(closeErr).Msg("failed to close fallback reader")
+	}
+	return err
+}
+
+// SetInterval forwards to the primary source when it supports it.
+func (f *FallbackSource) SetInterval(interval time.Duration) error {
+	setter, ok := f.GeoIPSource.(IntervalSetter)
+	if !ok {
+		return errors.New("primary source has no fetch interval")
+	}
+	return setter.SetInterval(interval)
+}
+
+// FetchHealthy forwards to the primary source; a primary that does not fetch
+// is always healthy.
+func (f *FallbackSource) FetchHealthy() bool {
+	reporter, ok := f.GeoIPSource.(FetchHealthReporter)
+	return !ok || reporter.FetchHealthy()
+}
+
+func (f *FallbackSource) IsReady() bool {
+	return true
+}
+
+func (f *FallbackSource) GetReader() ReaderInterface {
+	if f.GeoIPSource.IsReady() {
+		if reader := f.GeoIPSource.GetReader(); reader != nil {
+			return reader
+		}
+	}
+	return f.fallback
+}
+
+func (f *FallbackSource) Info() DBInfo {
+	if f.GeoIPSource.IsReady() {
+		return f.GeoIPSource.Info()
+	}
+	return f.info
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestFallbackSource_ServesUntilPrimaryReady(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "geoip-db-*.mmdb")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(GenerateValidMockMMDB()); err != nil {
+		t.Fatalf("failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	primary := NewDiskLoader(tmpFile.Name())
+	source, err := WithFallback(primary)
+	if err != nil {
+		t.Fatalf("WithFallback failed: %v", err)
+	}
+	defer source.Stop()
+
+	if !source.IsReady() {
+		t.Fatal("expected the fallback to make the source ready before the primary")
+	}
+	reader := source.GetReader()
+	if !IsFallback(reader) {
+		t.Fatal("expected the fallback reader while the primary is not ready")
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := reader.Lookup(net.ParseIP("1.2.3.4"), &record); err != nil {
+		t.Fatalf("fallback lookup failed: %v", err)
+	}
+	if record.Country.ISOCode != "" {
+		t.Errorf("expected the empty fallback to resolve no country, got %q", record.Country.ISOCode)
+	}
+	if got := source.Info().DatabaseType; got != "GeoIP-Fallback-Country" {
+		t.Errorf("expected the fallback database type, got %q", got)
+	}
+
+	if err := primary.Reload(); err != nil {
+		t.Fatalf("primary reload failed: %v", err)
+	}
+	if IsFallback(source.GetReader()) {
+		t.Error("expected the primary reader once it is ready")
+	}
+	if got := source.Info().DatabaseType; got != "GeoLite2-Country" {
+		t.Errorf("expected the primary database type, got %q", got)
+	}
+}
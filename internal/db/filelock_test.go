@@ -0,0 +1,73 @@
+//go:build linux || darwin
+
+package db
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/utils"
+)
+
+func TestDiskLoader_Start_RemovesOrphanedFiles(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(dbPath, GenerateValidMockMMDB(), 0o644); err != nil {
+		t.Fatalf("failed to write db: %v", err)
+	}
+	stale := []string{dbPath + ".tmp", dbPath + ".42.tmp", dbPath + ".backup"}
+	for _, path := range stale {
+		if err := os.WriteFile(path, []byte("stale"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	loader := NewDiskLoader(dbPath)
+	loader.FileLock = true
+	if err := loader.Start(); err != nil {
+		t.Fatalf("failed to start loader: %v", err)
+	}
+	defer loader.Stop()
+	for _, path := range stale {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+}
+
+func TestRemoteFetcher_Start_SweepWaitsForFileLock(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	inFlight := dbPath + ".42.tmp"
+	if err := os.WriteFile(inFlight, []byte("replace in flight"), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", inFlight, err)
+	}
+	// Another writer is mid-replace and holds the lock.
+	unlock, err := utils.LockFile(dbPath, true)
+	if err != nil {
+		t.Fatalf("LockFile failed: %v", err)
+	}
+
+	server := newTestServer(testResponse{statusCode: http.StatusInternalServerError})
+	defer server.close()
+	rf := newTestRemoteFetcher(server.client, false, dbPath)
+	rf.URL = server.server.URL
+	rf.BaseBackoff = time.Hour
+	rf.fileLock = true
+	started := make(chan error, 1)
+	go func() { started <- rf.Start() }()
+	defer rf.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(inFlight); err != nil {
+		t.Fatalf("Expected the sweep to wait for the lock, but %s is gone: %v", inFlight, err)
+	}
+	unlock()
+	if err := <-started; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err := os.Stat(inFlight); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed once the lock was free, got %v", inFlight, err)
+	}
+}
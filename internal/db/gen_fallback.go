@@ -0,0 +1,34 @@
+//go:build ignore
+
+// gen_fallback writes fallback.mmdb, the database embedded for
+// -enable-empty-fallback-db. It holds no networks, so every public IP
+// resolves to no country and is denied until a real database is loaded.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+)
+
+func main() {
+	writer, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: "GeoIP-Fallback-Country",
+		Description:  map[string]string{"en": "Empty fallback database used until a real one is loaded"},
+		IPVersion:    6,
+		RecordSize:   24,
+	})
+	if err != nil {
+		log.Fatalf("failed to create mmdbwriter: %v", err)
+	}
+
+	f, err := os.Create("fallback.mmdb")
+	if err != nil {
+		log.Fatalf("failed to create fallback.mmdb: %v", err)
+	}
+	defer f.Close()
+	if _, err := writer.WriteTo(f); err != nil {
+		log.Fatalf("failed to write fallback.mmdb: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// integritySampleSize is the number of random IPv4 addresses looked up per
+// integrity check, on top of integrityCheckIPs.
+const integritySampleSize = 16
+
+// integrityCheckIPs are looked up by every integrity check, so both the IPv4
+// and the IPv6 parts of the search tree are covered.
+var integrityCheckIPs = []net.IP{
+	net.ParseIP("1.1.1.1"),
+	net.ParseIP("8.8.8.8"),
+	net.ParseIP("2001:4860:4860::8888"),
+}
+
+// IntegritySource re-checks the primary source's reader on a schedule with a
+// sample of lookups, catching a database that breaks after it was loaded.
+// Once a check fails it reports not ready and reloads the primary; it is
+// ready again when the reloaded database passes.
+type IntegritySource struct {
+	GeoIPSource
+	interval time.Duration
+	failed   atomic.Bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// WithIntegrityCheck wraps primary so that its reader is checked every
+// interval while it runs.
+func WithIntegrityCheck(primary GeoIPSource, interval time.Duration) *IntegritySource {
+	return &IntegritySource{GeoIPSource: primary, interval: interval}
+}
+
+func (s *IntegritySource) Start() error {
+	if err := s.GeoIPSource.Start(); err != nil {
+		return err
+	}
+	s.done = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.check()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *IntegritySource) Stop() error {
+	if s.done != nil {
+		close(s.done)
+		s.wg.Wait()
+	}
+	return s.GeoIPSource.Stop()
+}
+
+func (s *IntegritySource) IsReady() bool {
+	return !s.failed.Load() && s.GeoIPSource.IsReady()
+}
+
+// check runs one integrity check, reloading the primary when it fails.
+func (s *IntegritySource) check() {
+	if !s.GeoIPSource.IsReady() {
+		return
+	}
+	err := sampleLookups(s.GeoIPSource.GetReader())
+	if err == nil {
+		if s.failed.Swap(false) {
+			log.Info().Msg("Database integrity check passed again, marking ready")
+		}
+		return
+	}
+	s.failed.Store(true)
+	log.Error().Err(err).Msg("Database integrity check failed, marking not ready and reloading")
+	if err := s.GeoIPSource.Reload(); err != nil {
+		log.Error().Err(err).Msg("Failed to reload database after integrity check failure")
+		return
+	}
+	if err := sampleLookups(s.GeoIPSource.GetReader()); err != nil {
+		log.Error().Err(err).Msg("Reloaded database failed the integrity check")
+		return
+	}
+	s.failed.Store(false)
+	log.Info().Msg("Reloaded database passed the integrity check, marking ready")
+}
+
+// sampleLookups looks up integrityCheckIPs and a random sample of IPv4
+// addresses, returning the first error.
+func sampleLookups(reader ReaderInterface) error {
+	if reader == nil {
+		return errors.New("no database reader")
+	}
+	ips := append([]net.IP(nil), integrityCheckIPs...)
+	for range integritySampleSize {
+		ips = append(ips, net.IPv4(byte(rand.N(256)), byte(rand.N(256)), byte(rand.N(256)), byte(rand.N(256))))
+	}
+	for _, ip := range ips {
+		var record any
+		if err := reader.Lookup(ip, &record); err != nil {
+			return fmt.Errorf("lookup of %s failed: %w", ip, err)
+		}
+	}
+	return nil
+}
+
+// SetInterval forwards to the primary source when it supports it.
+func (s *IntegritySource) SetInterval(interval time.Duration) error {
+	setter, ok := s.GeoIPSource.(IntervalSetter)
+	if !ok {
+		return errors.New("primary source has no fetch interval")
+	}
+	return setter.SetInterval(interval)
+}
+
+// FetchHealthy forwards to the primary source; a primary that does not fetch
+// is always healthy.
+func (s *IntegritySource) FetchHealthy() bool {
+	reporter, ok := s.GeoIPSource.(FetchHealthReporter)
+	return !ok || reporter.FetchHealthy()
+}
+
+// Snapshot forwards to the primary source when it supports it.
+func (s *IntegritySource) Snapshot() (io.ReadCloser, int64, error) {
+	snapshotter, ok := s.GeoIPSource.(Snapshotter)
+	if !ok {
+		return nil, 0, errors.New("primary source cannot be downloaded")
+	}
+	return snapshotter.Snapshot()
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakySource serves a reader whose lookups fail while broken is set, and
+// counts its reloads.
+type flakySource struct {
+	GeoIPSource
+	broken  atomic.Bool
+	reloads atomic.Int32
+}
+
+func (f *flakySource) Start() error  { return nil }
+func (f *flakySource) Stop() error   { return nil }
+func (f *flakySource) IsReady() bool { return true }
+
+func (f *flakySource) Reload() error {
+	f.reloads.Add(1)
+	return nil
+}
+
+func (f *flakySource) GetReader() ReaderInterface {
+	return mockGeoIPReader{
+		lookup: func(ip net.IP, record any) error {
+			if f.broken.Load() {
+				return errors.New("unexpected end of database")
+			}
+			return nil
+		},
+		close: func() error { return nil },
+	}
+}
+
+func TestIntegritySource_Check(t *testing.T) {
+	primary := &flakySource{}
+	source := WithIntegrityCheck(primary, time.Hour)
+
+	source.check()
+	if !source.IsReady() || primary.reloads.Load() != 0 {
+		t.Fatalf("Expected a healthy reader to stay ready without reloads, got ready=%v reloads=%d", source.IsReady(), primary.reloads.Load())
+	}
+
+	// The reload does not fix the reader, so the source stays unready.
+	primary.broken.Store(true)
+	source.check()
+	if source.IsReady() {
+		t.Error("Expected a failing reader to mark the source not ready")
+	}
+	if got := primary.reloads.Load(); got != 1 {
+		t.Errorf("Expected one reload attempt, got %d", got)
+	}
+
+	primary.broken.Store(false)
+	source.check()
+	if !source.IsReady() {
+		t.Error("Expected the source to be ready again once lookups pass")
+	}
+}
+
+func TestIntegritySource_Start(t *testing.T) {
+	primary := &flakySource{}
+	primary.broken.Store(true)
+	source := WithIntegrityCheck(primary, 5*time.Millisecond)
+	if err := source.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer source.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for primary.reloads.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the periodic check to trigger a reload")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if source.IsReady() {
+		t.Error("Expected the failing reader to mark the source not ready")
+	}
+}
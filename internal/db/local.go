@@ -6,13 +6,24 @@ import (
 	"sync"
 
 	"github.com/oschwald/maxminddb-golang"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rdwr-valentineg/GeoIP/internal/utils"
+	"github.com/rs/zerolog/log"
 )
 
 type DiskLoader struct {
 	DBPath string
+	// ExpectedDBType is a substring the database type should contain;
+	// StrictDBType rejects mismatching databases instead of warning.
+	ExpectedDBType string
+	StrictDBType   bool
+	// FileLock holds a shared lock on DBPath while opening it, so a
+	// replace by another process is never observed half done.
+	FileLock bool
 
 	mutex  sync.RWMutex
-	reader *maxminddb.Reader
+	reader ReaderInterface
+	info   DBInfo
 	ready  bool
 }
 
@@ -23,9 +34,40 @@ func NewDiskLoader(dbPath string) *DiskLoader {
 }
 
 func (d *DiskLoader) Start() error {
+	// The file belongs to an external writer, whose in-flight replace only
+	// the lock tells apart from a crashed one.
+	if d.FileLock {
+		sweepOrphanedFiles(d.DBPath, true)
+	}
 	return d.Reload()
 }
 
+// sweepOrphanedFiles removes the temp and backup files a crash during a
+// database replace left next to dbPath. With fileLock it holds the exclusive
+// lock meanwhile, so a replace another process has in flight is not taken
+// for a crashed one. Failures are only logged, since the leftovers do not
+// stop the database from loading.
+func sweepOrphanedFiles(dbPath string, fileLock bool) {
+	if dbPath == "" {
+		return
+	}
+	if fileLock {
+		unlock, err := utils.LockFile(dbPath, true)
+		if err != nil {
+			log.Warn().Err(err).Str("db_path", dbPath).Msg("Failed to lock the database, orphaned files not cleaned up")
+			return
+		}
+		defer unlock()
+	}
+	removed, err := utils.RemoveOrphanedFiles(dbPath)
+	for _, path := range removed {
+		log.Info().Str("path", path).Msg("Removed orphaned database file")
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("db_path", dbPath).Msg("Failed to clean up orphaned database files")
+	}
+}
+
 func (d *DiskLoader) Stop() error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -36,6 +78,13 @@ func (d *DiskLoader) Stop() error {
 }
 
 func (d *DiskLoader) Reload() error {
+	if d.FileLock {
+		unlock, err := utils.LockFile(d.DBPath, false)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
 	f, err := os.Open(d.DBPath)
 	if err != nil {
 		return fmt.Errorf("failed to open db path: %w", err)
@@ -46,14 +95,23 @@ func (d *DiskLoader) Reload() error {
 	if err != nil {
 		return err
 	}
+	info := readerInfo(reader)
+	if err := checkDatabaseType(info, d.ExpectedDBType, d.StrictDBType); err != nil {
+		reader.Close()
+		return err
+	}
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	if d.reader != nil {
 		_ = d.reader.Close()
 	}
-	d.reader = reader
+	d.reader = newCountedReader(reader, d.GetReader)
+	d.info = info
 	d.ready = true
+	metrics.DBLastReloadTimestamp.WithLabelValues(sourceDisk).SetToCurrentTime()
+	metrics.DBReaderSwapsTotal.WithLabelValues(sourceDisk).Inc()
+	recordFileSize(sourceDisk, d.DBPath)
 	return nil
 }
 
@@ -68,3 +126,9 @@ func (d *DiskLoader) IsReady() bool {
 	defer d.mutex.RUnlock()
 	return d.ready
 }
+
+func (d *DiskLoader) Info() DBInfo {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.info
+}
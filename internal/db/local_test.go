@@ -1,8 +1,16 @@
 package db
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
 )
 
 func TestDiskLoader_LoadsAndReloads(t *testing.T) {
@@ -58,3 +66,162 @@ func TestReloadWithInvalidPath(t *testing.T) {
 		t.Fatalf("loader should not be ready after reload with invalid path, got: %v", ready)
 	}
 }
+
+func TestDiskLoader_SetsLastReloadTimestamp(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "geoip-db-*.mmdb")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(GenerateValidMockMMDB()); err != nil {
+		t.Fatalf("failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	gauge := metrics.DBLastReloadTimestamp.WithLabelValues(sourceDisk)
+	gauge.Set(0)
+	before := float64(time.Now().Unix())
+
+	loader := NewDiskLoader(tmpFile.Name())
+	if err := loader.Reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	defer loader.Stop()
+
+	if got := testutil.ToFloat64(gauge); got < before {
+		t.Errorf("expected disk reload timestamp >= %v, got %v", before, got)
+	}
+}
+
+func TestDiskLoader_CountsReaderSwaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, GenerateValidMockMMDB(), 0o644); err != nil {
+		t.Fatalf("failed to write db: %v", err)
+	}
+	swaps := metrics.DBReaderSwapsTotal.WithLabelValues(sourceDisk)
+	before := testutil.ToFloat64(swaps)
+
+	loader := NewDiskLoader(path)
+	defer loader.Stop()
+	for range 2 {
+		if err := loader.Reload(); err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	}
+	if err := os.WriteFile(path, []byte("not an mmdb"), 0o644); err != nil {
+		t.Fatalf("failed to corrupt db: %v", err)
+	}
+	if err := loader.Reload(); err == nil {
+		t.Fatal("expected reloading a corrupt db to fail")
+	}
+	if got := testutil.ToFloat64(swaps) - before; got != 2 {
+		t.Errorf("expected 2 reader swaps, got %v", got)
+	}
+}
+
+func TestDiskLoader_DatabaseTypeMismatch(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "geoip-db-*.mmdb")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(GenerateValidMockMMDB()); err != nil {
+		t.Fatalf("failed to write to temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	// The mock database is a GeoLite2-Country edition.
+	loader := NewDiskLoader(tmpFile.Name())
+	loader.ExpectedDBType = "City"
+	if err := loader.Reload(); err != nil {
+		t.Fatalf("non-strict mismatch should only warn, got: %v", err)
+	}
+	if got := loader.Info().DatabaseType; got != "GeoLite2-Country" {
+		t.Errorf("expected database type GeoLite2-Country, got %q", got)
+	}
+	loader.Stop()
+
+	strict := NewDiskLoader(tmpFile.Name())
+	strict.ExpectedDBType = "City"
+	strict.StrictDBType = true
+	if err := strict.Reload(); err == nil {
+		t.Fatal("expected strict mismatch to be rejected")
+	}
+	if strict.IsReady() {
+		t.Error("loader should not be ready after a rejected database")
+	}
+	if got := strict.Info(); got != (DBInfo{}) {
+		t.Errorf("expected empty info after a rejected database, got %+v", got)
+	}
+}
+
+func TestDiskLoader_SetsFileSize(t *testing.T) {
+	data := GenerateValidMockMMDB()
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write db: %v", err)
+	}
+
+	loader := NewDiskLoader(path)
+	if err := loader.Reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	defer loader.Stop()
+
+	if got := testutil.ToFloat64(metrics.DBFileSize.WithLabelValues(sourceDisk)); got != float64(len(data)) {
+		t.Errorf("expected disk file size %d, got %v", len(data), got)
+	}
+}
+
+func TestDiskLoader_Snapshot(t *testing.T) {
+	data := GenerateValidMockMMDB()
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write db: %v", err)
+	}
+
+	loader := NewDiskLoader(path)
+	if _, _, err := loader.Snapshot(); !errors.Is(err, ErrNoDatabase) {
+		t.Fatalf("expected ErrNoDatabase before loading, got %v", err)
+	}
+	if err := loader.Reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	defer loader.Stop()
+
+	body, size, err := loader.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	defer body.Close()
+	got, _ := io.ReadAll(body)
+	if size != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Errorf("expected the installed database (%d bytes), got %d bytes (size %d)", len(data), len(got), size)
+	}
+}
+
+func TestDiskLoader_Start_KeepsFilesWithoutLock(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(dbPath, GenerateValidMockMMDB(), 0o644); err != nil {
+		t.Fatalf("failed to write db: %v", err)
+	}
+	stale := []string{dbPath + ".tmp", dbPath + ".42.tmp", dbPath + ".backup"}
+	for _, path := range stale {
+		if err := os.WriteFile(path, []byte("stale"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	// Without the lock the files may belong to the external writer's
+	// in-flight replace, so they are left alone.
+	loader := NewDiskLoader(dbPath)
+	if err := loader.Start(); err != nil {
+		t.Fatalf("failed to start loader: %v", err)
+	}
+	loader.Stop()
+	for _, path := range stale {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept without -db-file-lock, got %v", path, err)
+		}
+	}
+}
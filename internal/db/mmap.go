@@ -0,0 +1,64 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// mmapReader is an in-memory database kept in a temporary file and mapped
+// by maxminddb, so the OS page cache rather than the heap decides how much
+// of it is resident. The file is removed when the reader is closed. It is
+// created next to DBPath as "<db>.*.tmp" when there is one, so the startup
+// sweep also removes a file left behind by a crash.
+type mmapReader struct {
+	*maxminddb.Reader
+	path string
+}
+
+func (m *mmapReader) Close() error {
+	err := m.Reader.Close()
+	if rmErr := os.Remove(m.path); rmErr != nil && err == nil {
+		err = errors.Wrap(rmErr, "failed to remove mapped database file")
+	}
+	return err
+}
+
+// createMmapReader writes data to a temporary file and maps it.
+func (r *RemoteFetcher) createMmapReader(data []byte) (ReaderInterface, error) {
+	dir, pattern := "", "geoip-*.mmdb"
+	if r.DBPath != "" {
+		dir, pattern = filepath.Dir(r.DBPath), filepath.Base(r.DBPath)+".*.tmp"
+	}
+	out, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		metrics.FetchErrorsTotal.WithLabelValues("file_creation").Inc()
+		return nil, errors.Wrap(err, "failed to create mapped database file")
+	}
+	path := out.Name()
+	_, err = out.Write(data)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		metrics.FetchErrorsTotal.WithLabelValues("file_write").Inc()
+		return nil, errors.Wrap(err, "failed to write mapped database file")
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, openError(err, "failed to map maxmind database")
+	}
+
+	log.Debug().
+		Str("endpoint", r.endpoint()).
+		Str("path", path).
+		Msg("Database mapped from temporary file")
+	return &mmapReader{Reader: reader, path: path}, nil
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestRemoteFetcher_fetch_Mmap(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.mmap = true
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	reader, ok := unwrapReader(rf.GetReader()).(*mmapReader)
+	if !ok {
+		t.Fatalf("expected a mapped reader, got %T", rf.GetReader())
+	}
+	if rf.data != nil {
+		t.Error("expected the downloaded database not to be kept on the heap")
+	}
+	if got := rf.Info().DatabaseType; got != "GeoLite2-Country" {
+		t.Errorf("expected database type GeoLite2-Country, got %q", got)
+	}
+
+	// Lookups through the mapping match the same database read from memory.
+	plain, err := maxminddb.FromBytes(mustMockValidMMDB(t))
+	if err != nil {
+		t.Fatalf("failed to open database from memory: %v", err)
+	}
+	defer plain.Close()
+	for _, ip := range []string{"1.2.3.4", "2.3.4.5", "8.8.8.8"} {
+		var got, want any
+		if err := reader.Lookup(net.ParseIP(ip), &got); err != nil {
+			t.Fatalf("lookup of %s failed: %v", ip, err)
+		}
+		plain.Lookup(net.ParseIP(ip), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v for %s, got %v", want, ip, got)
+		}
+	}
+
+	body, size, err := rf.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if want := mustMockValidMMDB(t); size != int64(len(want)) || !bytes.Equal(got, want) {
+		t.Errorf("expected the mapped database (%d bytes), got %d bytes (size %d)", len(want), len(got), size)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if _, err := os.Stat(reader.path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed on close, got %v", reader.path, err)
+	}
+}
+
+func TestRemoteFetcher_Stop_RemovesMappedFile(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	rf := newTestRemoteFetcher(server.client, true, dbPath)
+	rf.URL = server.server.URL
+	rf.mmap = true
+	if err := rf.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !rf.IsReady() {
+		if time.Now().After(deadline) {
+			rf.Stop()
+			t.Fatal("fetcher did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reader, ok := unwrapReader(rf.GetReader()).(*mmapReader)
+	if !ok {
+		rf.Stop()
+		t.Fatalf("expected a mapped reader, got %T", rf.GetReader())
+	}
+	// The mapped file sits where the startup sweep looks for leftovers.
+	name := filepath.Base(reader.path)
+	if filepath.Dir(reader.path) != filepath.Dir(dbPath) ||
+		!strings.HasPrefix(name, "GeoLite2-Country.mmdb.") || !strings.HasSuffix(name, ".tmp") {
+		t.Errorf("expected the mapped file next to %s as <db>.*.tmp, got %s", dbPath, reader.path)
+	}
+
+	if err := rf.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if _, err := os.Stat(reader.path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed on Stop, got %v", reader.path, err)
+	}
+	if rf.IsReady() {
+		t.Error("expected the fetcher not to be ready after Stop")
+	}
+}
+
+// newLargeMMDB builds a database of n /24 networks, big enough for the heap
+// cost of keeping it in memory to stand out.
+func newLargeMMDB(b *testing.B, n int) []byte {
+	b.Helper()
+	writer, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-Country"})
+	if err != nil {
+		b.Fatalf("failed to create mmdbwriter: %v", err)
+	}
+	for i := range n {
+		network := &net.IPNet{
+			IP:   net.IPv4(byte(1+i>>16), byte(i>>8), byte(i), 0).To4(),
+			Mask: net.CIDRMask(24, 32),
+		}
+		// A distinct record per network keeps the writer from merging them.
+		record := mmdbtype.Map{"country": mmdbtype.Map{
+			"iso_code":   mmdbtype.String("US"),
+			"geoname_id": mmdbtype.Uint32(i),
+		}}
+		if err := writer.Insert(network, record); err != nil {
+			b.Fatalf("failed to insert %s: %v", network, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := writer.WriteTo(&buf); err != nil {
+		b.Fatalf("failed to write mmdb: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkCreateReader_Residency reports the heap each open reader keeps
+// alive (heap-B/reader) in plain in-memory and -db-mmap mode. The heap is
+// what counts towards RSS unconditionally; mapped pages live in the page
+// cache and are reclaimed under memory pressure. With 50k networks (~1 MB):
+//
+//	memory  ~1 MB heap per reader
+//	mmap    ~330 B heap per reader
+func BenchmarkCreateReader_Residency(b *testing.B) {
+	data := newLargeMMDB(b, 50_000)
+	for _, mode := range []struct {
+		name string
+		mmap bool
+	}{{"memory", false}, {"mmap", true}} {
+		b.Run(mode.name, func(b *testing.B) {
+			rf := &RemoteFetcher{inMemory: true, mmap: mode.mmap}
+			var readers []ReaderInterface
+			defer func() {
+				for _, reader := range readers {
+					reader.Close()
+				}
+			}()
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			for b.Loop() {
+				// Each fetch downloads into a fresh buffer.
+				reader, err := rf.createReader(bytes.Clone(data), int64(len(data)))
+				if err != nil {
+					b.Fatalf("createReader failed: %v", err)
+				}
+				readers = append(readers, reader)
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.HeapInuse-before.HeapInuse)/float64(len(readers)), "heap-B/reader")
+		})
+	}
+}
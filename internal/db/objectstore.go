@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// ObjectStore fetches a single object from an object storage bucket.
+	ObjectStore interface {
+		GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	}
+
+	// unavailableStore is used when an object store could not be set up, so
+	// the failure surfaces on every fetch instead of at construction.
+	unavailableStore struct {
+		err error
+	}
+)
+
+func (s unavailableStore) GetObject(context.Context, string, string) (io.ReadCloser, error) {
+	return nil, s.err
+}
+
+// parseS3URL splits an s3://bucket/key URL. ok is false for other schemes.
+func parseS3URL(raw string) (bucket, key string, ok bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" {
+		return "", "", false
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), true
+}
+
+func (r *RemoteFetcher) downloadObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	if bucket == "" || key == "" {
+		metrics.FetchErrorsTotal.WithLabelValues("http_request_creation").Inc()
+		return nil, errors.Errorf("invalid s3 url %q, expected s3://bucket/key", r.URL)
+	}
+	if r.store == nil {
+		return nil, errors.New("no object store configured")
+	}
+	body, err := r.store.GetObject(ctx, bucket, key)
+	if err != nil {
+		metrics.FetchErrorsTotal.WithLabelValues("http_request_execution").Inc()
+		return nil, errors.Wrapf(err, "failed to fetch s3://%s/%s", bucket, key)
+	}
+	log.Debug().
+		Str("endpoint", "s3").
+		Str("bucket", bucket).
+		Str("key", key).
+		Msg("database object fetch completed successfully")
+	return body, nil
+}
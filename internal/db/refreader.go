@@ -0,0 +1,110 @@
+package db
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// errReaderClosed is returned by lookups on a reader that was swapped out
+// when its source has no reader to forward them to.
+var errReaderClosed = errors.New("database reader closed")
+
+// countedReader defers closing a swapped-out reader until the lookups in
+// flight on it complete, so a swap never unmaps a database under a concurrent
+// Lookup. A caller may still hold the reader when it is swapped out, so
+// lookups starting after Close are forwarded to the source's current reader
+// rather than failing the request.
+type countedReader struct {
+	ReaderInterface
+	// current returns the source's serving reader; nil, or no serving
+	// reader, fails late lookups with errReaderClosed.
+	current  func() ReaderInterface
+	active   atomic.Int64
+	retired  atomic.Bool
+	once     sync.Once
+	closeErr error
+}
+
+func newCountedReader(reader ReaderInterface, current func() ReaderInterface) *countedReader {
+	return &countedReader{ReaderInterface: reader, current: current}
+}
+
+// unwrapReader returns the reader a countedReader guards, or reader itself.
+func unwrapReader(reader ReaderInterface) ReaderInterface {
+	if c, ok := reader.(*countedReader); ok {
+		return c.ReaderInterface
+	}
+	return reader
+}
+
+// acquire registers an in-flight lookup. It fails once the reader is
+// retired; the counter is raised first so Close cannot miss the lookup.
+func (c *countedReader) acquire() bool {
+	c.active.Add(1)
+	if c.retired.Load() {
+		c.release()
+		return false
+	}
+	return true
+}
+
+// release ends an in-flight lookup, closing a retired reader after the last.
+func (c *countedReader) release() {
+	if c.active.Add(-1) == 0 && c.retired.Load() {
+		if err := c.closeNow(); err != nil {
+			log.Error().Err(err).Msg("failed to close previous reader")
+		}
+	}
+}
+
+// successor returns the reader that replaced c, or nil when there is none.
+func (c *countedReader) successor() ReaderInterface {
+	if c.current == nil {
+		return nil
+	}
+	if next := c.current(); next != nil && next != ReaderInterface(c) {
+		return next
+	}
+	return nil
+}
+
+func (c *countedReader) closeNow() error {
+	c.once.Do(func() { c.closeErr = c.ReaderInterface.Close() })
+	return c.closeErr
+}
+
+func (c *countedReader) Lookup(ip net.IP, result interface{}) error {
+	if !c.acquire() {
+		if next := c.successor(); next != nil {
+			return next.Lookup(ip, result)
+		}
+		return errReaderClosed
+	}
+	defer c.release()
+	return c.ReaderInterface.Lookup(ip, result)
+}
+
+func (c *countedReader) LookupNetwork(ip net.IP, result interface{}) (*net.IPNet, bool, error) {
+	if !c.acquire() {
+		if next := c.successor(); next != nil {
+			return next.LookupNetwork(ip, result)
+		}
+		return nil, false, errReaderClosed
+	}
+	defer c.release()
+	return c.ReaderInterface.LookupNetwork(ip, result)
+}
+
+// Close retires the reader. It is closed right away when no lookup is in
+// flight, and by the last in-flight lookup otherwise.
+func (c *countedReader) Close() error {
+	c.retired.Store(true)
+	if c.active.Load() == 0 {
+		return c.closeNow()
+	}
+	return nil
+}
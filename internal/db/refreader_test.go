@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// trackedReader fails lookups made after it was closed, standing in for an
+// unmapped database.
+type trackedReader struct {
+	closed atomic.Bool
+}
+
+func (t *trackedReader) Lookup(ip net.IP, result any) error {
+	if t.closed.Load() {
+		return fmt.Errorf("lookup on closed reader")
+	}
+	return nil
+}
+
+func (t *trackedReader) LookupNetwork(ip net.IP, result any) (*net.IPNet, bool, error) {
+	return nil, true, t.Lookup(ip, result)
+}
+
+func (t *trackedReader) Close() error {
+	t.closed.Store(true)
+	return nil
+}
+
+func TestCountedReader_ClosesAfterInFlightLookups(t *testing.T) {
+	inner := &trackedReader{}
+	reader := newCountedReader(inner, nil)
+	if !reader.acquire() {
+		t.Fatal("expected a live reader to accept lookups")
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if inner.closed.Load() {
+		t.Fatal("reader closed while a lookup was in flight")
+	}
+	var record any
+	if err := reader.Lookup(net.ParseIP("8.8.8.8"), &record); !errors.Is(err, errReaderClosed) {
+		t.Errorf("expected lookups after Close to fail with errReaderClosed, got %v", err)
+	}
+	reader.release()
+	if !inner.closed.Load() {
+		t.Error("expected the last in-flight lookup to close the reader")
+	}
+}
+
+func TestRemoteFetcher_updateReaderState_ConcurrentLookups(t *testing.T) {
+	rf := &RemoteFetcher{}
+	var readers []*trackedReader
+	swap := func() {
+		reader := &trackedReader{}
+		readers = append(readers, reader)
+		if err := rf.updateReaderState(reader, nil); err != nil {
+			t.Fatalf("updateReaderState failed: %v", err)
+		}
+	}
+	swap()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip := net.ParseIP("1.2.3.4")
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Hold the reader across a possible swap, like a handler
+				// that fetched it before the update landed.
+				reader := rf.GetReader()
+				runtime.Gosched()
+				var record any
+				if err := reader.Lookup(ip, &record); err != nil {
+					t.Errorf("lookup raced a reader swap: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for range 200 {
+		swap()
+	}
+	close(done)
+	wg.Wait()
+
+	for i, reader := range readers[:len(readers)-1] {
+		if !reader.closed.Load() {
+			t.Errorf("expected swapped-out reader %d to be closed", i)
+		}
+	}
+	if readers[len(readers)-1].closed.Load() {
+		t.Error("expected the serving reader to stay open")
+	}
+}
+
+func TestCountedReader_ForwardsLookupsAfterSwap(t *testing.T) {
+	rf := &RemoteFetcher{}
+	if err := rf.updateReaderState(&trackedReader{}, nil); err != nil {
+		t.Fatalf("updateReaderState failed: %v", err)
+	}
+	held := rf.GetReader()
+	replacement := &trackedReader{}
+	if err := rf.updateReaderState(replacement, nil); err != nil {
+		t.Fatalf("updateReaderState failed: %v", err)
+	}
+
+	var record any
+	if err := held.Lookup(net.ParseIP("8.8.8.8"), &record); err != nil {
+		t.Errorf("expected a lookup on the swapped-out reader to be served, got %v", err)
+	}
+	if _, _, err := held.LookupNetwork(net.ParseIP("8.8.8.8"), &record); err != nil {
+		t.Errorf("expected a network lookup on the swapped-out reader to be served, got %v", err)
+	}
+
+	// With no serving reader left there is nothing to forward to.
+	rf.mutex.Lock()
+	rf.reader = nil
+	rf.mutex.Unlock()
+	if err := held.Lookup(net.ParseIP("8.8.8.8"), &record); !errors.Is(err, errReaderClosed) {
+		t.Errorf("expected errReaderClosed without a serving reader, got %v", err)
+	}
+}
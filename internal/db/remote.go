@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +24,9 @@ import (
 
 type (
 	RemoteFetcher struct {
+		// Name labels this source's fetch metrics and logs, e.g. the
+		// edition or mirror it downloads; empty means "maxmind".
+		Name        string
 		BasicAuth   string
 		DBPath      string // optional
 		Interval    time.Duration
@@ -32,10 +36,42 @@ type (
 		timeout     time.Duration
 		mutex       sync.RWMutex
 		reader      ReaderInterface
+		data        []byte // backs reader in memory mode
+		info        DBInfo
 		ready       bool
 		done        chan struct{}
+		// intervalCh hands interval changes to the running fetch loop.
+		intervalCh chan time.Duration
+		// ctx is cancelled by Stop to abort an in-flight download, and wg
+		// lets Stop wait for the fetch goroutine to exit.
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+		// stopTimeout bounds how long Stop waits for the fetch goroutine;
+		// 0 waits until it exits.
+		stopTimeout time.Duration
 		inMemory    bool
-		maxRetries  int
+		mmap        bool
+		// fileLock holds an exclusive lock on DBPath while replacing it.
+		fileLock   bool
+		maxRetries int
+		// extractAnyMMDB falls back to the archive's only .mmdb member when
+		// the expected one is missing.
+		extractAnyMMDB bool
+		expectedDBType string
+		strictDBType   bool
+		// store serves s3:// URLs.
+		store ObjectStore
+		// breaker skips scheduled fetches during an extended outage; nil
+		// disables it.
+		breaker *fetchBreaker
+		// failedFetches counts consecutive failed fetches; fetching is
+		// unhealthy once it reaches unhealthyAfter.
+		failedFetches  int
+		unhealthyAfter int
+		// notifier posts to the update webhook after each swap; nil
+		// disables it.
+		notifier *updateNotifier
 	}
 
 	HTTPClient interface {
@@ -47,30 +83,87 @@ type (
 		Rename(oldpath, newpath string) error
 	}
 	Config struct {
-		AccountID   string
-		LicenseKey  string
-		DBPath      string
-		Interval    time.Duration
-		Timeout     time.Duration
+		// Name tells this source apart in fetch metrics and logs when
+		// several are configured; empty means "maxmind".
+		Name       string
+		AccountID  string
+		LicenseKey string
+		DBPath     string
+		Interval   time.Duration
+		Timeout    time.Duration
+		// StopTimeout bounds how long Stop waits for an in-flight fetch;
+		// 0 waits until it returns.
+		StopTimeout time.Duration
 		MaxRetries  int
 		BaseBackoff time.Duration
+		// URL replaces the MaxMind download URL. An s3://bucket/key URL
+		// fetches the object from S3 using the standard AWS credential chain.
+		URL string
+		// URLAuth sends AccountID and LicenseKey to URL as well; without it
+		// they only go to the MaxMind download URL.
+		URLAuth bool
+		// ExtractAnyMMDB accepts a single differently named .mmdb member.
+		ExtractAnyMMDB bool
+		// ExpectedDBType is a substring the downloaded database type should
+		// contain; StrictDBType rejects mismatches instead of warning.
+		ExpectedDBType string
+		StrictDBType   bool
+		// BreakerThreshold consecutive failed scheduled fetches open the
+		// circuit breaker for BreakerCooldown; 0 disables the breaker.
+		BreakerThreshold int
+		BreakerCooldown  time.Duration
+		// UnhealthyAfter consecutive failed fetches mark fetching unhealthy;
+		// values below 1 mean the first failure does.
+		UnhealthyAfter int
+		// Storage is StorageMemory or StorageFile. Empty keeps the database
+		// on disk when DBPath is set and in memory otherwise.
+		Storage string
+		// Mmap maps in-memory databases from a temporary file so the page
+		// cache manages their residency.
+		Mmap bool
+		// FileLock serializes replaces of DBPath with other processes
+		// through an advisory lock.
+		FileLock bool
+		// UpdateWebhook is POSTed a JSON event after every successful
+		// update; empty disables it.
+		UpdateWebhook string
 	}
 )
 
+// Storage modes for downloaded databases.
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+)
+
 const (
-	maxDBSize      = 500 * 1024 * 1024 // 500MB limit
-	maxmindBaseURL = "https://download.maxmind.com/geoip/databases/GeoLite2-Country/download?suffix=tar.gz"
+	defaultEndpoint = "maxmind"
+	maxDBSize       = 500 * 1024 * 1024 // 500MB limit
+	maxmindBaseURL  = "https://download.maxmind.com/geoip/databases/GeoLite2-Country/download?suffix=tar.gz"
 )
 
 func NewRemoteFetcher(cfg Config) *RemoteFetcher {
-	auth := fmt.Sprintf("%s:%s", cfg.AccountID, cfg.LicenseKey)
-	b64Auth := base64.StdEncoding.EncodeToString([]byte(auth))
 	dbPath := cfg.DBPath
+	url := cfg.URL
+	if url == "" {
+		url = maxmindBaseURL
+	}
+	// The license key must not leak to a mirror or third-party host.
+	var basicAuth string
+	if url == maxmindBaseURL || cfg.URLAuth {
+		auth := fmt.Sprintf("%s:%s", cfg.AccountID, cfg.LicenseKey)
+		basicAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+	}
+	var store ObjectStore
+	if _, _, ok := parseS3URL(url); ok {
+		store = newS3Store()
+	}
 	return &RemoteFetcher{
-		BasicAuth:   "Basic " + b64Auth,
+		Name:        cfg.Name,
+		BasicAuth:   basicAuth,
 		DBPath:      dbPath,
 		Interval:    cfg.Interval,
-		URL:         maxmindBaseURL, // Use configurable URL
+		URL:         url,
 		BaseBackoff: time.Second,
 		Client: &http.Client{
 			Timeout: 30 * time.Second,
@@ -80,22 +173,108 @@ func NewRemoteFetcher(cfg Config) *RemoteFetcher {
 				IdleConnTimeout:     30 * time.Second,
 			},
 		},
-		inMemory:   dbPath == "",
-		timeout:    cfg.Timeout,
-		maxRetries: cfg.MaxRetries,
+		inMemory:       inMemoryStorage(cfg.Storage, dbPath),
+		mmap:           cfg.Mmap,
+		fileLock:       cfg.FileLock,
+		timeout:        cfg.Timeout,
+		stopTimeout:    cfg.StopTimeout,
+		maxRetries:     cfg.MaxRetries,
+		extractAnyMMDB: cfg.ExtractAnyMMDB,
+		expectedDBType: cfg.ExpectedDBType,
+		strictDBType:   cfg.StrictDBType,
+		store:          store,
+		breaker:        newFetchBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		unhealthyAfter: max(cfg.UnhealthyAfter, 1),
+		notifier:       newUpdateNotifier(cfg.UpdateWebhook),
+	}
+}
+
+// endpoint returns the endpoint label of this source's fetch metrics.
+func (r *RemoteFetcher) endpoint() string {
+	if r.Name == "" {
+		return defaultEndpoint
+	}
+	return r.Name
+}
+
+// inMemoryStorage reports whether downloaded databases stay in memory. Without
+// an explicit storage mode the database is persisted only when a path is set.
+func inMemoryStorage(storage, dbPath string) bool {
+	switch storage {
+	case StorageMemory:
+		return true
+	case StorageFile:
+		return false
+	default:
+		return dbPath == ""
 	}
 }
 
 func (r *RemoteFetcher) Start() error {
+	sweepOrphanedFiles(r.DBPath, r.fileLock)
 	r.done = make(chan struct{})
-	go r.periodicFetch()
+	r.mutex.Lock()
+	r.intervalCh = make(chan time.Duration, 1)
+	r.mutex.Unlock()
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.recordFetchHealth()
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.periodicFetch()
+	}()
 	return nil
 }
 
+// Stop signals the fetch goroutine to exit, cancels any in-flight download
+// and waits, at most stopTimeout when it is set, for the goroutine to return.
+// The serving reader is then closed and a temporary database file left by
+// the interrupted fetch removed.
 func (r *RemoteFetcher) Stop() error {
-	if r.done != nil {
+	if r.done == nil {
+		return nil
+	}
+	select {
+	case <-r.done: // already stopped
+	default:
 		close(r.done)
 	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(exited)
+	}()
+	var timeout <-chan time.Time
+	if r.stopTimeout > 0 {
+		timer := time.NewTimer(r.stopTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-exited:
+	case <-timeout:
+		return errors.New("timed out waiting for the in-flight fetch to stop")
+	}
+
+	// Close the serving reader, which also removes a mapped database's
+	// file; lookups still in flight finish first.
+	r.mutex.Lock()
+	reader := r.reader
+	r.reader, r.ready = nil, false
+	r.mutex.Unlock()
+	if reader != nil {
+		if err := reader.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close reader")
+		}
+	}
+
+	if !r.inMemory && r.DBPath != "" {
+		return utils.RemoveTempFile(r.DBPath)
+	}
 	return nil
 }
 
@@ -111,33 +290,109 @@ func (r *RemoteFetcher) GetReader() ReaderInterface {
 	return r.reader
 }
 
+func (r *RemoteFetcher) Info() DBInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.info
+}
+
+// SetInterval changes the fetch interval. A running fetch loop resets its
+// ticker so the next fetch happens one new interval from now.
+func (r *RemoteFetcher) SetInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("fetch interval must be positive")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Interval = interval
+	if r.intervalCh == nil {
+		return nil
+	}
+	// Replace any change the loop has not picked up yet.
+	select {
+	case <-r.intervalCh:
+	default:
+	}
+	r.intervalCh <- interval
+	return nil
+}
+
+// FetchHealthy reports whether fewer than the configured number of
+// consecutive fetches have failed. It is true before the first fetch.
+func (r *RemoteFetcher) FetchHealthy() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.failedFetches < max(r.unhealthyAfter, 1)
+}
+
+// recordFetch updates the fetch health with the outcome of a fetch.
+func (r *RemoteFetcher) recordFetch(err error) {
+	r.mutex.Lock()
+	if err == nil {
+		r.failedFetches = 0
+	} else {
+		r.failedFetches++
+	}
+	r.mutex.Unlock()
+	r.recordFetchHealth()
+}
+
+// recordFetchHealth publishes FetchHealthy on the fetch health gauge.
+func (r *RemoteFetcher) recordFetchHealth() {
+	if r.FetchHealthy() {
+		metrics.FetchHealthy.Set(1)
+	} else {
+		metrics.FetchHealthy.Set(0)
+	}
+}
+
 func (r *RemoteFetcher) Reload() error {
 	return r.fetchWithRetry()
 }
 
 func (r *RemoteFetcher) periodicFetch() {
+	done := r.done
+	r.mutex.RLock()
+	intervalCh := r.intervalCh
 	ticker := time.NewTicker(r.Interval)
+	r.mutex.RUnlock()
 	defer ticker.Stop()
 
-	if err := r.fetchWithRetry(); err != nil {
-		log.Info().Err(err).Msg("fetch error!")
-	}
+	r.scheduledFetch()
 	for {
 		select {
 		case <-ticker.C:
-			if err := r.fetchWithRetry(); err != nil {
-				log.Info().Err(err).Msg("fetch error!")
-			}
-		case <-r.done:
+			r.scheduledFetch()
+		case interval := <-intervalCh:
+			ticker.Reset(interval)
+			log.Info().Dur("interval", interval).Msg("fetch interval changed")
+		case <-done:
 			return
 		}
 	}
 }
 
+// scheduledFetch runs a fetch with retries unless the circuit breaker is open.
+func (r *RemoteFetcher) scheduledFetch() {
+	if !r.breaker.allow() {
+		log.Warn().Str("endpoint", r.endpoint()).Msg("fetch breaker open, skipping scheduled fetch")
+		return
+	}
+	err := r.fetchWithRetry()
+	if err != nil {
+		log.Info().Err(err).Msg("fetch error!")
+	}
+	r.breaker.record(err)
+}
+
 func (r *RemoteFetcher) fetch() error {
 	// Track fetch attempt
-	metrics.FetchAttemptsTotal.WithLabelValues("maxmind").Inc()
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	metrics.FetchAttemptsTotal.WithLabelValues(r.endpoint()).Inc()
+	parent := r.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, r.timeout)
 	defer cancel()
 
 	// Download and extract database
@@ -165,7 +420,11 @@ func (r *RemoteFetcher) fetch() error {
 	}
 
 	// Update the fetcher state
-	if err := r.updateReaderState(reader); err != nil {
+	var snapshot []byte
+	if r.inMemory && !r.mmap {
+		snapshot = data
+	}
+	if err := r.updateReaderState(reader, snapshot); err != nil {
 		metrics.FetchErrorsTotal.WithLabelValues("reader_state_update").Inc()
 		log.Error().Err(err).Msg("Failed to update reader state")
 		return err
@@ -173,43 +432,168 @@ func (r *RemoteFetcher) fetch() error {
 	log.Debug().
 		Int64("size_bytes", size).
 		Msg("Database fetch completed successfully")
+	r.notifyUpdate(parent, size)
 	return nil
 }
 
+// notifyUpdate delivers the update webhook in the background. Stop cancels
+// pending retries and waits for the delivery to finish.
+func (r *RemoteFetcher) notifyUpdate(ctx context.Context, size int64) {
+	if r.notifier == nil {
+		return
+	}
+	info := r.Info()
+	event := updateEvent{
+		Source:       sourceRemote,
+		DatabaseType: info.DatabaseType,
+		BuildEpoch:   info.BuildEpoch,
+		SizeBytes:    size,
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := r.notifier.deliver(ctx, event); err != nil {
+			log.Error().Err(err).Msg("failed to deliver update webhook")
+		}
+	}()
+}
+
 func (r *RemoteFetcher) downloadAndExtractDB(ctx context.Context) ([]byte, int64, error) {
-	resp, err := r.downloadArchive(ctx)
+	source, err := r.openSource(ctx)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer resp.Body.Close()
+	defer source.Close()
+	body := &countingReader{r: source}
+	defer func() {
+		// Drain what extraction left unread so the count covers the whole
+		// response and the connection can be reused.
+		io.Copy(io.Discard, io.LimitReader(body, maxDBSize))
+		metrics.FetchBytesTotal.Add(float64(body.n))
+	}()
+
+	// Objects staged in S3 may be a bare database rather than an archive.
+	if strings.HasSuffix(r.URL, ".mmdb") {
+		data, err := io.ReadAll(io.LimitReader(body, maxDBSize+1))
+		if err != nil {
+			metrics.FetchErrorsTotal.WithLabelValues("http_body_read").Inc()
+			return nil, 0, errors.Wrap(err, "failed to read mmdb data")
+		}
+		return data, int64(len(data)), nil
+	}
 
-	gzr, err := gzip.NewReader(resp.Body)
+	gzr, err := gzip.NewReader(body)
 	if err != nil {
-		metrics.FetchErrorsTotal.WithLabelValues("gzip_decompression").Inc()
-		return nil, 0, errors.Wrap(err, "failed to create gzip reader")
+		return nil, 0, decodeError(body, nil, errors.Wrap(err, "failed to create gzip reader"))
 	}
 	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
-	data, size, err := utils.ExtractFileFromTar(tr, "GeoLite2-Country.mmdb")
+	stream := &countingReader{r: gzr}
+	tr := tar.NewReader(stream)
+	data, size, err := utils.ExtractMMDBFromTar(tr, "GeoLite2-Country.mmdb", r.extractAnyMMDB, maxDBSize)
 	if err != nil {
-		metrics.FetchErrorsTotal.WithLabelValues("tar_extraction").Inc()
-		return nil, 0, errors.Wrap(err, "failed to extract GeoLite2-Country.mmdb from tar")
+		var notFound *utils.MemberNotFoundError
+		if errors.As(err, &notFound) {
+			log.Warn().
+				Str("target", notFound.Target).
+				Strs("mmdb_members", notFound.MMDBMembers).
+				Msg("expected mmdb member missing from archive")
+		}
+		return nil, 0, decodeError(body, stream, errors.Wrap(err, "failed to extract GeoLite2-Country.mmdb from tar"))
 	}
 
 	// Buffer the data in memory before closing resp.Body
 	buf := new(bytes.Buffer)
 	if _, err := io.Copy(buf, data); err != nil {
-		return nil, 0, errors.Wrap(err, "failed to buffer mmdb data")
+		return nil, 0, decodeError(body, stream, errors.Wrap(err, "failed to buffer mmdb data"))
 	}
 
 	log.Debug().
-		Str("endpoint", "maxmind").
+		Str("endpoint", r.endpoint()).
 		Int64("size_bytes", size).
 		Msg("Database extraction completed successfully")
 	return buf.Bytes(), int64(buf.Len()), nil
 }
 
+// countingReader counts the bytes read through it and remembers the first
+// read error other than io.EOF.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// corruptArchiveError marks a download that arrived in full but does not
+// decode. Retrying the same URL will not fix it, as the source itself serves
+// bad bytes.
+type corruptArchiveError struct {
+	err error
+}
+
+func (e *corruptArchiveError) Error() string { return e.err.Error() }
+
+func (e *corruptArchiveError) Unwrap() error { return e.err }
+
+// isCorruptArchive reports whether err is a corruptArchiveError.
+func isCorruptArchive(err error) bool {
+	var corrupt *corruptArchiveError
+	return errors.As(err, &corrupt)
+}
+
+// decodeError counts a failed archive decode by where it failed. A failed
+// read of the response body is a network error and is returned as is. Any
+// other failure is corruption: in the gzip stream when stream, the
+// decompressed data, saw an error or does not exist yet, and in the tar
+// archive otherwise.
+func decodeError(body, stream *countingReader, err error) error {
+	label := "tar_extraction"
+	switch {
+	case body.err != nil:
+		metrics.FetchErrorsTotal.WithLabelValues("http_body_read").Inc()
+		return err
+	case stream == nil || stream.err != nil:
+		label = "gzip_decompression"
+	}
+	metrics.FetchErrorsTotal.WithLabelValues(label).Inc()
+	return &corruptArchiveError{err: err}
+}
+
+// openError counts a failure to open a fetched database. A database that
+// does not parse is corrupt; anything else, such as a failed mapping, stays a
+// maxmind_reader_creation error.
+func openError(err error, msg string) error {
+	err = errors.Wrap(err, msg)
+	var invalid maxminddb.InvalidDatabaseError
+	if errors.As(err, &invalid) {
+		metrics.FetchErrorsTotal.WithLabelValues("mmdb_parse").Inc()
+		return &corruptArchiveError{err: err}
+	}
+	metrics.FetchErrorsTotal.WithLabelValues("maxmind_reader_creation").Inc()
+	return err
+}
+
+// openSource returns the body of the configured URL, from S3 for s3:// URLs
+// and over HTTP otherwise.
+func (r *RemoteFetcher) openSource(ctx context.Context) (io.ReadCloser, error) {
+	if bucket, key, ok := parseS3URL(r.URL); ok {
+		return r.downloadObject(ctx, bucket, key)
+	}
+	resp, err := r.downloadArchive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 func (r *RemoteFetcher) downloadArchive(ctx context.Context) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", r.URL, nil)
 	if err != nil {
@@ -217,8 +601,9 @@ func (r *RemoteFetcher) downloadArchive(ctx context.Context) (*http.Response, er
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 
-	// Add Basic Auth header
-	req.Header.Add("Authorization", r.BasicAuth)
+	if r.BasicAuth != "" {
+		req.Header.Add("Authorization", r.BasicAuth)
+	}
 	resp, err := r.Client.Do(req)
 	if err != nil {
 		metrics.FetchErrorsTotal.WithLabelValues("http_request_execution").Inc()
@@ -231,7 +616,7 @@ func (r *RemoteFetcher) downloadArchive(ctx context.Context) (*http.Response, er
 		return nil, fmt.Errorf("bad response: %s", resp.Status)
 	}
 	log.Debug().
-		Str("endpoint", "maxmind").
+		Str("endpoint", r.endpoint()).
 		Int64("size_bytes", resp.ContentLength).
 		Msg("database fetch completed successfully")
 	return resp, nil
@@ -239,6 +624,9 @@ func (r *RemoteFetcher) downloadArchive(ctx context.Context) (*http.Response, er
 
 func (r *RemoteFetcher) createReader(data []byte, size int64) (ReaderInterface, error) {
 	if r.inMemory {
+		if r.mmap {
+			return r.createMmapReader(data)
+		}
 		return r.createInMemoryReader(data)
 	}
 	return r.createFileReader(data, size)
@@ -247,12 +635,11 @@ func (r *RemoteFetcher) createReader(data []byte, size int64) (ReaderInterface,
 func (r *RemoteFetcher) createInMemoryReader(data []byte) (ReaderInterface, error) {
 	reader, err := maxminddb.FromBytes(data)
 	if err != nil {
-		metrics.FetchErrorsTotal.WithLabelValues("maxmind_reader_creation").Inc()
-		return nil, errors.Wrap(err, "failed to create maxmind reader from bytes")
+		return nil, openError(err, "failed to create maxmind reader from bytes")
 	}
 
 	log.Debug().
-		Str("endpoint", "maxmind").
+		Str("endpoint", r.endpoint()).
 		Msg("Database in memory reader created successfully")
 	return reader, nil
 }
@@ -267,6 +654,7 @@ func (r *RemoteFetcher) createFileReader(data []byte, size int64) (ReaderInterfa
 	defer out.Close()
 
 	if _, err := io.CopyN(out, bytes.NewReader(data), size); err != nil {
+		os.Remove(tmpPath)
 		metrics.FetchErrorsTotal.WithLabelValues("file_write").Inc()
 		return nil, errors.Wrap(err, "failed to copy data to temporary file")
 	}
@@ -274,67 +662,91 @@ func (r *RemoteFetcher) createFileReader(data []byte, size int64) (ReaderInterfa
 	// Create reader from temporary file
 	reader, err := maxminddb.Open(tmpPath)
 	if err != nil {
-		metrics.FetchErrorsTotal.WithLabelValues("maxmind_reader_creation").Inc()
-		return nil, errors.Wrap(err, "failed to open maxmind reader from file")
+		os.Remove(tmpPath)
+		return nil, openError(err, "failed to open maxmind reader from file")
 	}
 
 	// Atomically replace the database file
-	if err := utils.AtomicReplaceFile(tmpPath, r.DBPath); err != nil {
+	replace := utils.AtomicReplaceFile
+	if r.fileLock {
+		replace = utils.AtomicReplaceFileLocked
+	}
+	if err := replace(tmpPath, r.DBPath); err != nil {
 		reader.Close()
 		os.Remove(tmpPath)
 		metrics.FetchErrorsTotal.WithLabelValues("file_rename").Inc()
 		return nil, err
 	}
+	recordFileSize(sourceRemote, r.DBPath)
 
 	log.Debug().
-		Str("endpoint", "maxmind").
+		Str("endpoint", r.endpoint()).
 		Int64("size_bytes", size).
 		Msg("Database file reader created successfully")
 	return reader, nil
 }
 
-func (r *RemoteFetcher) updateReaderState(reader ReaderInterface) error {
+// updateReaderState validates and installs reader. data is the database the
+// reader was opened from in memory mode, and nil in file and mmap mode.
+func (r *RemoteFetcher) updateReaderState(reader ReaderInterface, data []byte) error {
+	// Validate the new reader before touching the current one, so a failed
+	// swap leaves the previous database serving and readiness unchanged.
+	var testResult any
+	if err := reader.Lookup(net.ParseIP("8.8.8.8"), &testResult); err != nil {
+		reader.Close()
+		return errors.Wrap(err, "database validation failed")
+	}
+	info := readerInfo(reader)
+	if err := checkDatabaseType(info, r.expectedDBType, r.strictDBType); err != nil {
+		reader.Close()
+		return errors.Wrap(err, "database validation failed")
+	}
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	// Close previous reader
+	// Retire the previous reader; it closes once in-flight lookups finish.
 	if r.reader != nil {
 		if err := r.reader.Close(); err != nil {
 			log.Error().Err(err).Msg("failed to close previous reader")
 		}
 	}
 
-	// Validate new reader
-	var testResult any
-	if err := reader.Lookup(net.ParseIP("8.8.8.8"), &testResult); err != nil {
-		reader.Close()
-		return errors.Wrap(err, "database validation failed")
-	}
-
 	// Update state
-	r.reader = reader
+	r.reader = newCountedReader(reader, r.GetReader)
+	r.data = data
+	r.info = info
 	r.ready = true
 
 	// Track successful fetch
 	metrics.FetchSuccessTotal.Inc()
+	metrics.DBLastReloadTimestamp.WithLabelValues(sourceRemote).SetToCurrentTime()
+	metrics.DBReaderSwapsTotal.WithLabelValues(sourceRemote).Inc()
 
 	log.Debug().
-		Str("endpoint", "maxmind").
+		Str("endpoint", r.endpoint()).
 		Msg("database update completed successfully")
 
 	return nil
 }
 
-func (r *RemoteFetcher) fetchWithRetry() error {
-	var err error
+func (r *RemoteFetcher) fetchWithRetry() (err error) {
+	defer func() { r.recordFetch(err) }()
 	for i := range r.maxRetries {
 		if err = r.fetch(); err != nil {
 			log.Error().
 				Err(err).
 				Int("retry", i+1).
-				Str("endpoint", "maxmind").
+				Str("endpoint", r.endpoint()).
 				Msg("database fetch failed")
-			time.Sleep(r.BaseBackoff * time.Duration(i+1))
+			if isCorruptArchive(err) {
+				return errors.Wrap(err, "corrupt download, not retrying")
+			}
+			select {
+			case <-time.After(r.BaseBackoff * time.Duration(i+1)):
+			case <-r.done:
+				return errors.Wrap(err, "fetcher stopped")
+			}
 			continue
 		}
 		return nil
@@ -6,14 +6,17 @@ import (
 	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -57,6 +60,9 @@ type (
 func (m mockGeoIPReader) Lookup(ip net.IP, record any) error {
 	return m.lookup(ip, record)
 }
+func (m mockGeoIPReader) LookupNetwork(ip net.IP, record any) (*net.IPNet, bool, error) {
+	return nil, true, m.lookup(ip, record)
+}
 func (m mockGeoIPReader) Close() error {
 	return m.close()
 }
@@ -151,6 +157,50 @@ func TestNewRemoteFetcher(t *testing.T) {
 	}
 }
 
+func TestNewRemoteFetcher_CredentialsOnlyForMaxMind(t *testing.T) {
+	var gotAuth atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		urlAuth  bool
+		wantAuth bool
+	}{
+		{name: "MaxMind default", wantAuth: true},
+		{name: "Custom URL", url: server.URL},
+		{name: "Custom URL with explicit auth", url: server.URL, urlAuth: true, wantAuth: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rf := NewRemoteFetcher(Config{
+				AccountID:  "test-account",
+				LicenseKey: "test-license",
+				URL:        tc.url,
+				URLAuth:    tc.urlAuth,
+			})
+			if (rf.BasicAuth != "") != tc.wantAuth {
+				t.Fatalf("Expected credentials set %v, got %q", tc.wantAuth, rf.BasicAuth)
+			}
+			if tc.url == "" {
+				return
+			}
+			resp, err := rf.downloadArchive(context.Background())
+			if err != nil {
+				t.Fatalf("downloadArchive failed: %v", err)
+			}
+			resp.Body.Close()
+			if auth := gotAuth.Load().(string); (auth != "") != tc.wantAuth {
+				t.Errorf("Expected Authorization sent %v, got %q", tc.wantAuth, auth)
+			}
+		})
+	}
+}
+
 func TestNewRemoteFetcher_InMemory(t *testing.T) {
 	cfg := Config{
 		AccountID:  "test-account",
@@ -165,6 +215,29 @@ func TestNewRemoteFetcher_InMemory(t *testing.T) {
 	}
 }
 
+func TestNewRemoteFetcher_Storage(t *testing.T) {
+	tests := []struct {
+		name     string
+		storage  string
+		dbPath   string
+		inMemory bool
+	}{
+		{name: "default without path", storage: "", dbPath: "", inMemory: true},
+		{name: "default with path", storage: "", dbPath: "/tmp/test.mmdb", inMemory: false},
+		{name: "memory without path", storage: StorageMemory, dbPath: "", inMemory: true},
+		{name: "memory with path", storage: StorageMemory, dbPath: "/tmp/test.mmdb", inMemory: true},
+		{name: "file with path", storage: StorageFile, dbPath: "/tmp/test.mmdb", inMemory: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rf := NewRemoteFetcher(Config{DBPath: tc.dbPath, Storage: tc.storage})
+			if rf.inMemory != tc.inMemory {
+				t.Errorf("expected inMemory=%v, got %v", tc.inMemory, rf.inMemory)
+			}
+		})
+	}
+}
+
 func TestRemoteFetcher_Start(t *testing.T) {
 	cfg := Config{
 		AccountID:  "test-account",
@@ -185,6 +258,164 @@ func TestRemoteFetcher_Start(t *testing.T) {
 	}
 }
 
+// waitForGoroutines polls until the goroutine count drops to at most want,
+// giving exiting goroutines a moment to be descheduled.
+func waitForGoroutines(want int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRemoteFetcher_StartStop_NoGoroutineLeak(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *testServer
+	}{
+		{
+			name: "successful fetch",
+			server: newTestServer(testResponse{
+				statusCode: http.StatusOK,
+				body:       newValidMMDBArchive(t),
+			}),
+		}, {
+			name: "stopped during retry backoff",
+			server: newTestServer(testResponse{
+				statusCode: http.StatusInternalServerError,
+				body:       []byte("error"),
+			}),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer tc.server.close()
+			before := runtime.NumGoroutine()
+
+			for range 5 {
+				rf := newTestRemoteFetcher(tc.server.client, true, "")
+				rf.URL = tc.server.server.URL
+				rf.Interval = 5 * time.Millisecond
+				rf.BaseBackoff = time.Hour // Stop must not wait out the backoff
+
+				if err := rf.Start(); err != nil {
+					t.Fatalf("Start failed: %v", err)
+				}
+				time.Sleep(20 * time.Millisecond)
+
+				stopped := make(chan struct{})
+				go func() {
+					rf.Stop()
+					close(stopped)
+				}()
+				select {
+				case <-stopped:
+				case <-time.After(2 * time.Second):
+					t.Fatal("Stop did not return")
+				}
+				if err := rf.Stop(); err != nil {
+					t.Fatalf("second Stop failed: %v", err)
+				}
+			}
+
+			tc.server.client.CloseIdleConnections()
+			if after := waitForGoroutines(before, 2*time.Second); after > before {
+				buf := make([]byte, 1<<16)
+				n := runtime.Stack(buf, true)
+				t.Errorf("goroutines leaked: before=%d after=%d\n%s", before, after, buf[:n])
+			}
+		})
+	}
+}
+
+// blockingClient never answers a request until release is closed, ignoring
+// cancellation like a stuck transport would.
+type blockingClient struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (c *blockingClient) Do(req *http.Request) (*http.Response, error) {
+	c.once.Do(func() { close(c.started) })
+	<-c.release
+	return nil, fmt.Errorf("released")
+}
+
+func TestRemoteFetcher_Stop_RemovesTempFile(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	tmpPath := dbPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte("partial"), 0o644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	rf := newTestRemoteFetcher(server.Client(), false, dbPath)
+	rf.URL = server.URL
+	rf.stopTimeout = 2 * time.Second
+	if err := rf.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-started
+
+	if err := rf.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", tmpPath, err)
+	}
+}
+
+func TestRemoteFetcher_Stop_Timeout(t *testing.T) {
+	client := &blockingClient{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(client.release)
+
+	rf := newTestRemoteFetcher(client, true, "")
+	rf.stopTimeout = 20 * time.Millisecond
+	if err := rf.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-client.started
+
+	if err := rf.Stop(); err == nil {
+		t.Error("Expected Stop to time out while the fetch is stuck")
+	}
+}
+
+func TestRemoteFetcher_Start_RemovesOrphanedFiles(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	stale := []string{dbPath + ".tmp", dbPath + ".42.tmp"}
+	for _, path := range stale {
+		if err := os.WriteFile(path, []byte("stale"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	server := newTestServer(testResponse{statusCode: http.StatusInternalServerError})
+	defer server.close()
+	rf := newTestRemoteFetcher(server.client, false, dbPath)
+	rf.URL = server.server.URL
+	rf.BaseBackoff = time.Hour
+	if err := rf.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer rf.Stop()
+	for _, path := range stale {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+}
+
 func TestRemoteFetcher_LoadsToMemory(t *testing.T) {
 	archive := newValidMMDBArchive(t)
 	server := newTestServer(testResponse{
@@ -407,6 +638,280 @@ func TestRemoteFetcher_fetch_InMemory_Success(t *testing.T) {
 	}
 }
 
+func TestRemoteFetcher_fetch_SetsLastReloadTimestamp(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+
+	gauge := metrics.DBLastReloadTimestamp.WithLabelValues(sourceRemote)
+	gauge.Set(0)
+	diskBefore := testutil.ToFloat64(metrics.DBLastReloadTimestamp.WithLabelValues(sourceDisk))
+	before := float64(time.Now().Unix())
+
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if got := testutil.ToFloat64(gauge); got < before {
+		t.Errorf("expected remote reload timestamp >= %v, got %v", before, got)
+	}
+	if got := testutil.ToFloat64(metrics.DBLastReloadTimestamp.WithLabelValues(sourceDisk)); got != diskBefore {
+		t.Errorf("remote fetch should not touch the disk series, got %v", got)
+	}
+}
+
+func TestRemoteFetcher_fetch_CountsReaderSwaps(t *testing.T) {
+	server := newTestServer(
+		testResponse{statusCode: http.StatusOK, body: newValidMMDBArchive(t)},
+		testResponse{statusCode: http.StatusOK, body: newValidMMDBArchive(t)},
+		testResponse{statusCode: http.StatusInternalServerError, body: []byte("error")},
+	)
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	swaps := metrics.DBReaderSwapsTotal.WithLabelValues(sourceRemote)
+	before := testutil.ToFloat64(swaps)
+
+	for range 2 {
+		if err := rf.fetch(); err != nil {
+			t.Fatalf("fetch failed: %v", err)
+		}
+	}
+	if err := rf.fetch(); err == nil {
+		t.Fatal("expected the failed fetch to return an error")
+	}
+	if got := testutil.ToFloat64(swaps) - before; got != 2 {
+		t.Errorf("expected 2 reader swaps, got %v", got)
+	}
+}
+
+func TestRemoteFetcher_fetch_StrictDBType(t *testing.T) {
+	server := newTestServer(
+		testResponse{statusCode: http.StatusOK, body: newValidMMDBArchive(t)},
+		testResponse{statusCode: http.StatusOK, body: newValidMMDBArchive(t)},
+	)
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.expectedDBType = "ASN"
+	rf.strictDBType = true
+
+	if err := rf.fetch(); err == nil {
+		t.Fatal("expected a mismatching database type to be rejected")
+	}
+	if rf.IsReady() {
+		t.Error("fetcher should not be ready after a rejected database")
+	}
+
+	rf.expectedDBType = "Country"
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if got := rf.Info().DatabaseType; got != "GeoLite2-Country" {
+		t.Errorf("expected database type GeoLite2-Country, got %q", got)
+	}
+}
+
+type stubObjectStore struct {
+	objects map[string][]byte
+	err     error
+}
+
+func (s *stubObjectStore) GetObject(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	data, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s/%s", bucket, key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestParseS3URL(t *testing.T) {
+	bucket, key, ok := parseS3URL("s3://geo-bucket/dbs/GeoLite2-Country.mmdb")
+	if !ok || bucket != "geo-bucket" || key != "dbs/GeoLite2-Country.mmdb" {
+		t.Errorf("unexpected parse result: %q %q %v", bucket, key, ok)
+	}
+	if _, _, ok := parseS3URL(maxmindBaseURL); ok {
+		t.Error("expected an https URL not to be treated as s3")
+	}
+}
+
+func TestRemoteFetcher_fetch_S3(t *testing.T) {
+	store := &stubObjectStore{objects: map[string][]byte{
+		"geo/GeoLite2-Country.mmdb":   mustMockValidMMDB(t),
+		"geo/GeoLite2-Country.tar.gz": newValidMMDBArchive(t),
+	}}
+
+	for _, url := range []string{"s3://geo/GeoLite2-Country.mmdb", "s3://geo/GeoLite2-Country.tar.gz"} {
+		t.Run(url, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+			rf := newTestRemoteFetcher(nil, false, dbPath)
+			rf.URL = url
+			rf.store = store
+
+			if err := rf.fetch(); err != nil {
+				t.Fatalf("fetch failed: %v", err)
+			}
+			defer rf.GetReader().Close()
+			if !rf.IsReady() {
+				t.Error("fetcher should be ready after an s3 fetch")
+			}
+			if _, err := os.Stat(dbPath); err != nil {
+				t.Errorf("expected the database to be installed at %s: %v", dbPath, err)
+			}
+		})
+	}
+
+	t.Run("missing object", func(t *testing.T) {
+		rf := newTestRemoteFetcher(nil, true, "")
+		rf.URL = "s3://geo/missing.mmdb"
+		rf.store = store
+		if err := rf.fetch(); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+			t.Errorf("expected a NoSuchKey error, got %v", err)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		rf := newTestRemoteFetcher(nil, true, "")
+		rf.URL = "s3://geo"
+		rf.store = store
+		if err := rf.fetch(); err == nil {
+			t.Error("expected an s3 URL without a key to fail")
+		}
+	})
+}
+
+func TestNewRemoteFetcher_S3URL(t *testing.T) {
+	rf := NewRemoteFetcher(Config{URL: "s3://geo/GeoLite2-Country.mmdb"})
+	if rf.URL != "s3://geo/GeoLite2-Country.mmdb" {
+		t.Errorf("expected the s3 URL to be kept, got %q", rf.URL)
+	}
+	if rf.store == nil {
+		t.Error("expected an object store for an s3 URL")
+	}
+	if rf := NewRemoteFetcher(Config{}); rf.URL != maxmindBaseURL || rf.store != nil {
+		t.Errorf("expected the MaxMind URL without a store, got %q", rf.URL)
+	}
+}
+
+func TestRemoteFetcher_fetch_SetsFileSize(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	rf := newTestRemoteFetcher(server.client, false, dbPath)
+	rf.URL = server.server.URL
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	defer rf.GetReader().Close()
+
+	want := int64(len(mustMockValidMMDB(t)))
+	if got := testutil.ToFloat64(metrics.DBFileSize.WithLabelValues(sourceRemote)); got != float64(want) {
+		t.Errorf("expected remote file size %d, got %v", want, got)
+	}
+}
+
+func TestRemoteFetcher_fetch_CountsBytes(t *testing.T) {
+	archive := newValidMMDBArchive(t)
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       archive,
+	})
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	before := testutil.ToFloat64(metrics.FetchBytesTotal)
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	defer rf.GetReader().Close()
+
+	if got := testutil.ToFloat64(metrics.FetchBytesTotal) - before; got != float64(len(archive)) {
+		t.Errorf("expected %d bytes counted, got %v", len(archive), got)
+	}
+
+	// A failed status never reads the body.
+	bad := newTestServer(testResponse{statusCode: http.StatusForbidden, body: []byte("fail")})
+	defer bad.close()
+	rf.URL = bad.server.URL
+	before = testutil.ToFloat64(metrics.FetchBytesTotal)
+	rf.fetch()
+	if got := testutil.ToFloat64(metrics.FetchBytesTotal) - before; got != 0 {
+		t.Errorf("expected no bytes counted for a failed status, got %v", got)
+	}
+}
+
+func TestRemoteFetcher_fetch_EndpointLabel(t *testing.T) {
+	metrics.Reset()
+	server := newTestServer(
+		testResponse{statusCode: http.StatusOK, body: newValidMMDBArchive(t)},
+		testResponse{statusCode: http.StatusOK, body: newValidMMDBArchive(t)},
+	)
+	defer server.close()
+
+	country := NewRemoteFetcher(Config{Name: "GeoLite2-Country", URL: server.server.URL, Timeout: 5 * time.Second})
+	asn := NewRemoteFetcher(Config{Name: "GeoLite2-ASN", URL: server.server.URL, Timeout: 5 * time.Second})
+	for _, rf := range []*RemoteFetcher{country, asn} {
+		rf.Client = server.client
+		if err := rf.fetch(); err != nil {
+			t.Fatalf("%s fetch failed: %v", rf.Name, err)
+		}
+		defer rf.GetReader().Close()
+	}
+
+	for _, name := range []string{"GeoLite2-Country", "GeoLite2-ASN"} {
+		if got := testutil.ToFloat64(metrics.FetchAttemptsTotal.WithLabelValues(name)); got != 1 {
+			t.Errorf("Expected one fetch attempt labeled %s, got %v", name, got)
+		}
+	}
+	if got := testutil.CollectAndCount(metrics.FetchAttemptsTotal); got != 2 {
+		t.Errorf("Expected 2 endpoint series, got %d", got)
+	}
+
+	if rf := NewRemoteFetcher(Config{}); rf.endpoint() != "maxmind" {
+		t.Errorf("Expected an unnamed source to be labeled maxmind, got %q", rf.endpoint())
+	}
+}
+
+func TestRemoteFetcher_Snapshot_InMemory(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	defer rf.GetReader().Close()
+
+	body, size, err := rf.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	defer body.Close()
+	got, _ := io.ReadAll(body)
+	want := mustMockValidMMDB(t)
+	if size != int64(len(want)) || !bytes.Equal(got, want) {
+		t.Errorf("expected the buffered database (%d bytes), got %d bytes (size %d)", len(want), len(got), size)
+	}
+}
+
 func TestRemoteFetcher_fetch_InMemory_BadStatus(t *testing.T) {
 	server := newTestServer(testResponse{
 		statusCode: http.StatusForbidden,
@@ -440,6 +945,56 @@ func TestRemoteFetcher_fetch_InMemory_InvalidGzip(t *testing.T) {
 	}
 }
 
+func TestRemoteFetcher_fetchWithRetry_CorruptArchive(t *testing.T) {
+	archive := newValidMMDBArchive(t)
+	var garbage bytes.Buffer
+	gzw := gzip.NewWriter(&garbage)
+	gzw.Write(bytes.Repeat([]byte("garbage!"), 128))
+	gzw.Close()
+	badMMDB, err := CreateTarGz([]byte("not an mmdb"), "GeoLite2-Country.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		body  []byte
+		label string
+	}{
+		{name: "Truncated gzip", body: archive[:len(archive)/2], label: "gzip_decompression"},
+		{name: "Not a tar", body: garbage.Bytes(), label: "tar_extraction"},
+		{name: "Not an mmdb", body: badMMDB, label: "mmdb_parse"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics.Reset()
+			var hits atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				w.Write(tc.body)
+			}))
+			defer server.Close()
+
+			rf := newTestRemoteFetcher(server.Client(), true, "")
+			rf.URL = server.URL
+			rf.BaseBackoff = time.Millisecond
+			err := rf.fetchWithRetry()
+			if err == nil || !strings.Contains(err.Error(), "corrupt download") {
+				t.Fatalf("expected a corrupt download error, got %v", err)
+			}
+			if got := hits.Load(); got != 1 {
+				t.Errorf("expected a corrupt download not to be retried, got %d requests", got)
+			}
+			if got := testutil.ToFloat64(metrics.FetchErrorsTotal.WithLabelValues(tc.label)); got != 1 {
+				t.Errorf("expected one %s error, got %v", tc.label, got)
+			}
+			if got := testutil.ToFloat64(metrics.FetchErrorsTotal.WithLabelValues("http_body_read")); got != 0 {
+				t.Errorf("expected no http_body_read errors, got %v", got)
+			}
+		})
+	}
+}
+
 func TestRemoteFetcher_fetch_InMemory_MissingFileInTar(t *testing.T) {
 	arch, err := CreateTarGz([]byte("irrelevant"), "not-mmdb.txt")
 	if err != nil {
@@ -461,6 +1016,30 @@ func TestRemoteFetcher_fetch_InMemory_MissingFileInTar(t *testing.T) {
 	}
 }
 
+func TestRemoteFetcher_fetch_InMemory_ExtractAnyMMDB(t *testing.T) {
+	arch, err := CreateTarGz(mustMockValidMMDB(t), "GeoIP2-Country.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       arch,
+	})
+
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.extractAnyMMDB = true
+
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch should fall back to the only mmdb member: %v", err)
+	}
+	if !rf.IsReady() {
+		t.Error("expected ready after fetch")
+	}
+}
+
 func TestRemoteFetcher_fetch_InMemory_InvalidMMDB(t *testing.T) {
 	arch, err := CreateTarGz([]byte("not a mmdb"), "GeoLite2-Country.mmdb")
 	if err != nil {
@@ -591,6 +1170,36 @@ func TestRemoteFetcher_fetchWithRetry_RealTiming(t *testing.T) {
 	}
 }
 
+func TestRemoteFetcher_FetchHealthy(t *testing.T) {
+	archive := newValidMMDBArchive(t)
+	server := newTestServer(
+		testResponse{statusCode: http.StatusOK, body: archive},
+		testResponse{statusCode: http.StatusInternalServerError, body: []byte("error")},
+		testResponse{statusCode: http.StatusInternalServerError, body: []byte("error")},
+		testResponse{statusCode: http.StatusOK, body: archive},
+	)
+	defer server.close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.maxRetries = 1
+	rf.unhealthyAfter = 2
+
+	if !rf.FetchHealthy() {
+		t.Fatal("expected fetching to be healthy before the first fetch")
+	}
+	// healthy after each of: success, first failure, second failure, recovery
+	for i, want := range []bool{true, true, false, true} {
+		rf.fetchWithRetry()
+		if got := rf.FetchHealthy(); got != want {
+			t.Errorf("fetch %d: expected healthy=%v, got %v", i+1, want, got)
+		}
+		if !rf.IsReady() {
+			t.Errorf("fetch %d: expected the last good database to stay loaded", i+1)
+		}
+	}
+}
+
 func TestRemoteFetcher_Reload(t *testing.T) {
 	archive := newValidMMDBArchive(t)
 	server := newTestServer(testResponse{
@@ -784,6 +1393,67 @@ func TestRemoteFetcher_periodicFetch(t *testing.T) {
 	}
 }
 
+func TestRemoteFetcher_SetInterval(t *testing.T) {
+	var hits atomic.Int32
+	archive := newValidMMDBArchive(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	rf := newTestRemoteFetcher(server.Client(), true, "")
+	rf.URL = server.URL
+	if err := rf.SetInterval(0); err == nil {
+		t.Error("expected a zero interval to be rejected")
+	}
+	if err := rf.Start(); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer rf.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !rf.IsReady() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !rf.IsReady() {
+		t.Fatal("fetcher did not become ready")
+	}
+
+	// With the hour-long interval no further fetch would happen.
+	initial := hits.Load()
+	if err := rf.SetInterval(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetInterval failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := hits.Load(); got <= initial {
+		t.Errorf("expected the ticker to reset and fetch again, got %d fetches after %d", got, initial)
+	}
+}
+
+func TestUpdateReaderState_FailedSwapKeepsPrevious(t *testing.T) {
+	closed := false
+	previous := &mockGeoIPReader{
+		lookup: func(ip net.IP, record any) error { return nil },
+		close:  func() error { closed = true; return nil },
+	}
+	rf := &RemoteFetcher{reader: previous, ready: true}
+
+	err := rf.updateReaderState(&mockGeoIPReader{
+		lookup: func(ip net.IP, record any) error { return fmt.Errorf("corrupt") },
+		close:  func() error { return nil },
+	}, nil)
+	if err == nil {
+		t.Fatal("expected the swap to fail validation")
+	}
+	if closed {
+		t.Error("previous reader must not be closed by a failed swap")
+	}
+	if !rf.IsReady() || rf.GetReader() != ReaderInterface(previous) {
+		t.Error("expected the previous reader to keep serving after a failed swap")
+	}
+}
+
 func TestUpdateReaderState(t *testing.T) {
 	srv := newTestServer(testResponse{
 		statusCode: http.StatusOK,
@@ -847,7 +1517,7 @@ func TestUpdateReaderState(t *testing.T) {
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			err := tc.rf.updateReaderState(tc.reader)
+			err := tc.rf.updateReaderState(tc.reader, nil)
 			if tc.expectedErr != "" {
 				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
 					t.Errorf("expected error '%s', got %v", tc.expectedErr, err)
@@ -0,0 +1,37 @@
+//go:build s3
+
+package db
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+type s3Store struct {
+	client *s3.Client
+}
+
+// newS3Store builds an S3 client from the standard AWS credential chain.
+func newS3Store() ObjectStore {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return unavailableStore{err: errors.Wrap(err, "failed to load AWS configuration")}
+	}
+	return &s3Store{client: s3.NewFromConfig(cfg)}
+}
+
+func (s *s3Store) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
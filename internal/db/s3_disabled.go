@@ -0,0 +1,10 @@
+//go:build !s3
+
+package db
+
+import "github.com/pkg/errors"
+
+// newS3Store reports that S3 support was left out of this build.
+func newS3Store() ObjectStore {
+	return unavailableStore{err: errors.New("s3 support is not compiled in, rebuild with -tags s3")}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoDatabase is returned by Snapshot before a database has been loaded.
+var ErrNoDatabase = errors.New("no database loaded")
+
+// Snapshotter is implemented by sources that can hand out the raw bytes of
+// the installed database.
+type Snapshotter interface {
+	// Snapshot returns the installed database and its size. The snapshot is
+	// taken while no swap is in progress and stays consistent after one.
+	Snapshot() (io.ReadCloser, int64, error)
+}
+
+// openSnapshot opens path for streaming. An open file keeps its contents
+// even when a later swap renames a new database over the path.
+func openSnapshot(path string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to open database file")
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, errors.Wrap(err, "failed to stat database file")
+	}
+	return f, stat.Size(), nil
+}
+
+func (d *DiskLoader) Snapshot() (io.ReadCloser, int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if !d.ready {
+		return nil, 0, ErrNoDatabase
+	}
+	return openSnapshot(d.DBPath)
+}
+
+func (r *RemoteFetcher) Snapshot() (io.ReadCloser, int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if !r.ready || r.reader == nil {
+		return nil, 0, ErrNoDatabase
+	}
+	if m, ok := unwrapReader(r.reader).(*mmapReader); ok {
+		return openSnapshot(m.path)
+	}
+	if r.inMemory {
+		// data is never modified after a swap, only replaced.
+		return io.NopCloser(bytes.NewReader(r.data)), int64(len(r.data)), nil
+	}
+	return openSnapshot(r.DBPath)
+}
+
+// Snapshot returns the primary database once it is ready, and the embedded
+// fallback database until then.
+func (f *FallbackSource) Snapshot() (io.ReadCloser, int64, error) {
+	if f.GeoIPSource.IsReady() {
+		if snapshotter, ok := f.GeoIPSource.(Snapshotter); ok {
+			return snapshotter.Snapshot()
+		}
+	}
+	return io.NopCloser(bytes.NewReader(fallbackDB)), int64(len(fallbackDB)), nil
+}
@@ -2,6 +2,13 @@ package db
 
 import (
 	"net"
+	"time"
+)
+
+// Source type labels used on metrics shared by every GeoIPSource.
+const (
+	sourceDisk   = "disk"
+	sourceRemote = "remote"
 )
 
 // GeoIPSource abstracts a GeoIP database source.
@@ -19,9 +26,27 @@ type Fetcher interface {
 
 type DatabaseProvider interface {
 	GetReader() ReaderInterface
+	// Info describes the installed database; it is zero until one is loaded.
+	Info() DBInfo
+}
+
+// IntervalSetter is implemented by sources that refresh on a schedule whose
+// interval can be changed while running.
+type IntervalSetter interface {
+	SetInterval(interval time.Duration) error
+}
+
+// FetchHealthReporter is implemented by sources that fetch their database,
+// reporting whether recent fetches have been succeeding regardless of
+// whether a (possibly stale) database is loaded.
+type FetchHealthReporter interface {
+	FetchHealthy() bool
 }
 
 type ReaderInterface interface {
 	Lookup(ip net.IP, result interface{}) error
+	// LookupNetwork is Lookup that also returns the network the record is
+	// stored under; ok is false when ip has no record.
+	LookupNetwork(ip net.IP, result interface{}) (network *net.IPNet, ok bool, err error)
 	Close() error
 }
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	webhookMaxAttempts = 3
+	webhookBaseBackoff = time.Second
+	webhookTimeout     = 10 * time.Second
+)
+
+type (
+	// updateEvent is the JSON body POSTed to the update webhook after each
+	// successful database swap.
+	updateEvent struct {
+		Source       string `json:"source"`
+		DatabaseType string `json:"database_type"`
+		BuildEpoch   uint   `json:"build_epoch"`
+		SizeBytes    int64  `json:"size_bytes"`
+	}
+
+	// updateNotifier delivers update events to a webhook. Delivery runs in
+	// the background and its failures are only logged, so serving is never
+	// affected.
+	updateNotifier struct {
+		url         string
+		client      HTTPClient
+		maxAttempts int
+		baseBackoff time.Duration
+	}
+)
+
+// newUpdateNotifier returns nil when url is empty, which disables
+// notifications.
+func newUpdateNotifier(url string) *updateNotifier {
+	if url == "" {
+		return nil
+	}
+	return &updateNotifier{
+		url:         url,
+		client:      &http.Client{Timeout: webhookTimeout},
+		maxAttempts: webhookMaxAttempts,
+		baseBackoff: webhookBaseBackoff,
+	}
+}
+
+// deliver POSTs event, retrying with exponential backoff until it succeeds,
+// the attempts are exhausted or ctx is done.
+func (n *updateNotifier) deliver(ctx context.Context, event updateEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	backoff := n.baseBackoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(ctx, body)
+		if err == nil || attempt >= n.maxAttempts {
+			return err
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Msg("update webhook delivery failed, retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *updateNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteFetcher_fetch_UpdateWebhook(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	var (
+		mu       sync.Mutex
+		attempts int
+		events   []updateEvent
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // first delivery is retried
+			return
+		}
+		var event updateEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+		}
+		events = append(events, event)
+	}))
+	defer hook.Close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.notifier = newUpdateNotifier(hook.URL)
+	rf.notifier.baseBackoff = time.Millisecond
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	defer rf.GetReader().Close()
+	rf.wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("expected 2 webhook attempts, got %d", attempts)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 delivered event, got %d", len(events))
+	}
+	info := rf.Info()
+	want := updateEvent{
+		Source:       sourceRemote,
+		DatabaseType: info.DatabaseType,
+		BuildEpoch:   info.BuildEpoch,
+		SizeBytes:    int64(len(mustMockValidMMDB(t))),
+	}
+	if events[0] != want {
+		t.Errorf("expected event %+v, got %+v", want, events[0])
+	}
+	if events[0].BuildEpoch == 0 {
+		t.Error("expected a non-zero build epoch")
+	}
+}
+
+func TestRemoteFetcher_fetch_UpdateWebhookFailureIgnored(t *testing.T) {
+	server := newTestServer(testResponse{
+		statusCode: http.StatusOK,
+		body:       newValidMMDBArchive(t),
+	})
+	defer server.close()
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hook.Close()
+
+	rf := newTestRemoteFetcher(server.client, true, "")
+	rf.URL = server.server.URL
+	rf.notifier = newUpdateNotifier(hook.URL)
+	rf.notifier.baseBackoff = time.Millisecond
+	if err := rf.fetch(); err != nil {
+		t.Fatalf("expected webhook failures not to fail the fetch, got %v", err)
+	}
+	defer rf.GetReader().Close()
+	rf.wg.Wait()
+
+	if !rf.IsReady() {
+		t.Error("expected the fetcher to be ready despite webhook failures")
+	}
+}
+
+func TestNewUpdateNotifier_Disabled(t *testing.T) {
+	if n := newUpdateNotifier(""); n != nil {
+		t.Errorf("expected no notifier for an empty URL, got %+v", n)
+	}
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherCountry is the country label rare countries are bucketed into once the
+// top-countries limit is reached.
+const OtherCountry = "OTHER"
+
+// countryLabeler bounds the number of distinct country label values. It keeps
+// a frequency count of every country seen and gives a country its own label
+// while it ranks among the most frequent ones: a free slot goes to a country
+// no more than limit others outrank, and once the slots are full a country
+// seen more often than the least frequent labeled one takes over its slot.
+// With a window, frequencies and slots start over every window so the top
+// countries follow recent traffic. A country losing its label has its series
+// deleted, so no more than limit country series are exported.
+type countryLabeler struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	// forget deletes the series recorded under a country that lost its
+	// label.
+	forget func(country string)
+
+	// labeled maps each country owning a label to its counter. The map is
+	// never modified, only swapped under mutex, so requests from labeled
+	// countries are counted without locking.
+	labeled atomic.Pointer[map[string]*atomic.Uint64]
+	// counts maps every country seen in the window to its counter. Counting
+	// is lock-free too; mutex is only taken when a country may earn a label.
+	counts atomic.Pointer[sync.Map]
+	// windowEnd is when the current window ends, in Unix nanoseconds.
+	windowEnd atomic.Int64
+
+	mutex       sync.Mutex
+	windowStart time.Time
+}
+
+var countries = newCountryLabeler(0, 0)
+
+func newCountryLabeler(limit int, window time.Duration) *countryLabeler {
+	c := &countryLabeler{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		forget: forgetCountry,
+	}
+	c.startWindow(time.Now())
+	return c
+}
+
+// SetTopCountries caps the distinct country labels at n, collapsing the rest
+// into OtherCountry. Zero or a negative n disables the cap. A positive window
+// resets the frequencies every window; zero ranks countries over all time.
+// Any previously collected frequencies are discarded.
+func SetTopCountries(n int, window time.Duration) {
+	countries = newCountryLabeler(n, window)
+}
+
+// CountryLabel returns the label value to record for country.
+func CountryLabel(country string) string {
+	return countries.label(country)
+}
+
+// forgetCountry deletes the per-country series of country. A request that
+// got the label just before it was taken away may still recreate one; the
+// next eviction of that country deletes it again.
+func forgetCountry(country string) {
+	if RequestsTotal != nil {
+		RequestsTotal.DeletePartialMatch(prometheus.Labels{"country": country})
+	}
+	if WouldDenyTotal != nil {
+		WouldDenyTotal.DeleteLabelValues(country)
+	}
+}
+
+func (c *countryLabeler) label(country string) string {
+	if c.limit <= 0 {
+		return country
+	}
+	if c.window > 0 && c.now().UnixNano() >= c.windowEnd.Load() {
+		c.mutex.Lock()
+		if now := c.now(); now.UnixNano() >= c.windowEnd.Load() {
+			c.startWindow(now)
+		}
+		c.mutex.Unlock()
+	}
+	labeled := *c.labeled.Load()
+	if n, ok := labeled[country]; ok {
+		n.Add(1)
+		return country
+	}
+
+	hits := c.count(country).Add(1)
+	// With every slot taken, a country seen no more often than the least
+	// frequent labeled one cannot earn a label, which is decided without
+	// locking.
+	if len(labeled) >= c.limit {
+		if _, victimHits := leastFrequent(labeled); hits <= victimHits {
+			return OtherCountry
+		}
+	}
+	return c.promote(country)
+}
+
+// count returns the counter of country in the current window.
+func (c *countryLabeler) count(country string) *atomic.Uint64 {
+	counts := c.counts.Load()
+	if n, ok := counts.Load(country); ok {
+		return n.(*atomic.Uint64)
+	}
+	n, _ := counts.LoadOrStore(country, new(atomic.Uint64))
+	return n.(*atomic.Uint64)
+}
+
+// startWindow discards the frequencies and labels and starts a window at now.
+// It must be called with mutex held, or before the labeler is shared.
+func (c *countryLabeler) startWindow(now time.Time) {
+	if old := c.labeled.Load(); old != nil {
+		for country := range *old {
+			c.forget(country)
+		}
+	}
+	c.counts.Store(new(sync.Map))
+	c.labeled.Store(&map[string]*atomic.Uint64{})
+	c.windowStart = now
+	c.windowEnd.Store(now.Add(c.window).UnixNano())
+}
+
+// promote gives country a label if it now ranks among the top countries.
+func (c *countryLabeler) promote(country string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	labeled := *c.labeled.Load()
+	if _, ok := labeled[country]; ok {
+		return country
+	}
+	if len(labeled) < c.limit {
+		if c.rank(country) >= c.limit {
+			return OtherCountry
+		}
+		c.relabel(labeled, "", country)
+		return country
+	}
+	victim, victimHits := leastFrequent(labeled)
+	// Ties keep the current label, so two equally frequent countries do not
+	// take the slot from each other on every request.
+	if c.count(country).Load() <= victimHits {
+		return OtherCountry
+	}
+	c.relabel(labeled, victim, country)
+	c.forget(victim)
+	return country
+}
+
+// leastFrequent returns the labeled country seen least often and its count.
+func leastFrequent(labeled map[string]*atomic.Uint64) (string, uint64) {
+	var (
+		victim     string
+		victimHits uint64
+	)
+	for other, hits := range labeled {
+		if h := hits.Load(); victim == "" || h < victimHits {
+			victim, victimHits = other, h
+		}
+	}
+	return victim, victimHits
+}
+
+// relabel swaps in a copy of labeled without victim and with country.
+func (c *countryLabeler) relabel(labeled map[string]*atomic.Uint64, victim, country string) {
+	next := make(map[string]*atomic.Uint64, len(labeled)+1)
+	for other, hits := range labeled {
+		if other != victim {
+			next[other] = hits
+		}
+	}
+	next[country] = c.count(country)
+	c.labeled.Store(&next)
+}
+
+// rank returns how many countries have been seen more often than country.
+func (c *countryLabeler) rank(country string) int {
+	count := c.count(country).Load()
+	rank := 0
+	c.counts.Load().Range(func(other, n any) bool {
+		if other.(string) != country && n.(*atomic.Uint64).Load() > count {
+			rank++
+		}
+		return true
+	})
+	return rank
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCountryLabeler_CollapsesRareCountries(t *testing.T) {
+	c := newCountryLabeler(2, 0)
+
+	for range 5 {
+		if got := c.label("US"); got != "US" {
+			t.Fatalf("Expected US to keep its label, got %q", got)
+		}
+	}
+	for range 3 {
+		if got := c.label("DE"); got != "DE" {
+			t.Fatalf("Expected DE to keep its label, got %q", got)
+		}
+	}
+
+	for _, country := range []string{"FR", "RU", "BR", "FR"} {
+		if got := c.label(country); got != OtherCountry {
+			t.Errorf("Expected %s to collapse to %s, got %q", country, OtherCountry, got)
+		}
+	}
+
+	// Countries that already own a series keep it.
+	if got := c.label("DE"); got != "DE" {
+		t.Errorf("Expected DE to keep its label, got %q", got)
+	}
+	if labeled := *c.labeled.Load(); len(labeled) != 2 {
+		t.Errorf("Expected 2 labeled countries, got %d", len(labeled))
+	}
+}
+
+func TestCountryLabeler_FrequentCountryTakesOverSlot(t *testing.T) {
+	c := newCountryLabeler(2, 0)
+
+	for range 5 {
+		c.label("US")
+	}
+	for range 3 {
+		c.label("DE")
+	}
+	// FR shows up late, but once it is seen more often than DE it takes
+	// DE's slot.
+	for range 3 {
+		if got := c.label("FR"); got != OtherCountry {
+			t.Fatalf("Expected FR to collapse to %s until it outranks DE, got %q", OtherCountry, got)
+		}
+	}
+	if got := c.label("FR"); got != "FR" {
+		t.Errorf("Expected FR to take over a label, got %q", got)
+	}
+	if got := c.label("DE"); got != OtherCountry {
+		t.Errorf("Expected DE to lose its label to FR, got %q", got)
+	}
+	if got := c.label("US"); got != "US" {
+		t.Errorf("Expected US to keep its label, got %q", got)
+	}
+}
+
+func TestCountryLabeler_SkipsLowRankedCountries(t *testing.T) {
+	c := newCountryLabeler(2, 0)
+
+	for range 5 {
+		c.label("US")
+	}
+	// Only one slot is taken, but CN and JP outrank a single FR request.
+	for _, country := range []string{"CN", "JP"} {
+		c.count(country).Store(10)
+	}
+	if got := c.label("FR"); got != OtherCountry {
+		t.Errorf("Expected FR to collapse to %s, got %q", OtherCountry, got)
+	}
+	if got := c.label("CN"); got != "CN" {
+		t.Errorf("Expected CN to get its own label, got %q", got)
+	}
+}
+
+func TestCountryLabeler_Disabled(t *testing.T) {
+	c := newCountryLabeler(0, 0)
+	for _, country := range []string{"US", "DE", "FR"} {
+		if got := c.label(country); got != country {
+			t.Errorf("Expected %s to pass through, got %q", country, got)
+		}
+	}
+}
+
+func TestCountryLabeler_ResetsEachWindow(t *testing.T) {
+	c := newCountryLabeler(1, time.Minute)
+	now := c.windowStart
+	c.now = func() time.Time { return now }
+
+	for range 5 {
+		c.label("US")
+	}
+	if got := c.label("DE"); got != OtherCountry {
+		t.Fatalf("Expected DE to collapse to %s in the first window, got %q", OtherCountry, got)
+	}
+
+	// Once the window has passed, US's old traffic no longer holds the slot.
+	now = now.Add(time.Minute)
+	if got := c.label("DE"); got != "DE" {
+		t.Errorf("Expected DE to get its own label in the next window, got %q", got)
+	}
+	if got := c.label("US"); got != OtherCountry {
+		t.Errorf("Expected US to collapse to %s in the next window, got %q", OtherCountry, got)
+	}
+	if n := c.count("US").Load(); n != 1 {
+		t.Errorf("Expected US frequencies to restart, got %d", n)
+	}
+}
+
+func TestCountryLabeler_ForgetsEvictedCountries(t *testing.T) {
+	c := newCountryLabeler(1, time.Minute)
+	now := c.windowStart
+	c.now = func() time.Time { return now }
+	var forgotten []string
+	c.forget = func(country string) { forgotten = append(forgotten, country) }
+
+	c.label("US")
+	for range 2 {
+		c.label("DE")
+	}
+	if !slices.Equal(forgotten, []string{"US"}) {
+		t.Errorf("Expected US to be forgotten when DE took its slot, got %v", forgotten)
+	}
+
+	now = now.Add(time.Minute)
+	c.label("FR")
+	if !slices.Equal(forgotten, []string{"US", "DE"}) {
+		t.Errorf("Expected DE to be forgotten when the window reset, got %v", forgotten)
+	}
+}
+
+func TestForgetCountry(t *testing.T) {
+	InitMetrics()
+	RequestsTotal.WithLabelValues("US", "true").Inc()
+	RequestsTotal.WithLabelValues("US", "false").Inc()
+	RequestsTotal.WithLabelValues("DE", "true").Inc()
+	WouldDenyTotal.WithLabelValues("US").Inc()
+
+	forgetCountry("US")
+	if n := testutil.CollectAndCount(RequestsTotal); n != 1 {
+		t.Errorf("Expected only the DE request series to remain, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(WouldDenyTotal); n != 0 {
+		t.Errorf("Expected the US would-deny series to be deleted, got %d series", n)
+	}
+}
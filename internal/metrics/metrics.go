@@ -1,76 +1,355 @@
 package metrics
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
 	once           sync.Once
+	namespace      = "geoip"
 	RequestsTotal  *prometheus.CounterVec
-	CacheHits      prometheus.Counter
-	CacheEvictions prometheus.Counter
+	VerdictsTotal  *prometheus.CounterVec
+	WouldDenyTotal *prometheus.CounterVec
+	IPSourceTotal  *prometheus.CounterVec
+	// RequestRejectedTotal counts requests refused by a size or format guard.
+	RequestRejectedTotal *prometheus.CounterVec
+	CacheHits            prometheus.Counter
+	CacheEvictions       prometheus.Counter
+	CacheKeysThrottled   prometheus.Counter
+	LookupNotFound       prometheus.Counter
+	// CacheOldestEntryAge reports what the function passed to
+	// SetCacheOldestAgeFunc returns at scrape time.
+	CacheOldestEntryAge prometheus.GaugeFunc
+	cacheOldestAge      atomic.Pointer[func() time.Duration]
+
+	// Verdict latency, split by cache hit/miss
+	VerdictDuration *prometheus.HistogramVec
+	LookupDuration  prometheus.Histogram
 
 	// Remote fetcher metrics
 	FetchAttemptsTotal *prometheus.CounterVec
 	FetchSuccessTotal  prometheus.Counter
 	FetchErrorsTotal   *prometheus.CounterVec
+	FetchBytesTotal    prometheus.Counter
+	FetchBreakerState  prometheus.Gauge
+	FetchHealthy       prometheus.Gauge
+
+	// Database metrics shared by every source type
+	DBLastReloadTimestamp *prometheus.GaugeVec
+	DBFileSize            *prometheus.GaugeVec
+	DBReaderSwapsTotal    *prometheus.CounterVec
+	DBAge                 prometheus.Gauge
+
+	// HTTP connection metrics of the main listener
+	HTTPActiveConnections   prometheus.Gauge
+	HTTPNewConnectionsTotal prometheus.Counter
+
+	// Verdict audit events by result: published, dropped or failed
+	AuditEventsTotal *prometheus.CounterVec
 )
 
+// SetNamespace sets the prefix of every metric name. It must be called before
+// InitMetrics to take effect.
+func SetNamespace(ns string) {
+	namespace = ns
+}
+
 func InitMetrics() {
 	once.Do(func() {
-		registerMetrics()
+		registerMetrics(prometheus.DefaultRegisterer, namespace)
 	})
 }
 
-func registerMetrics() {
+// SetCacheOldestAgeFunc sets how the age of the verdict cache's oldest entry
+// is computed when the cache_oldest_entry_age metric is scraped.
+func SetCacheOldestAgeFunc(f func() time.Duration) {
+	cacheOldestAge.Store(&f)
+}
+
+// Register registers the process-wide metrics into reg as well, creating
+// them in the default registry first if InitMetrics has not. The collectors
+// are shared, not replaced, so the default registry keeps serving them.
+// Registering into the same registry twice is a no-op.
+func Register(reg prometheus.Registerer) {
+	InitMetrics()
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				panic(err)
+			}
+		}
+	}
+}
+
+// Reset is meant for tests. It replaces every process-wide metric with a
+// fresh one, so counters start from zero, and returns a new registry holding
+// them; the default registry is left alone and keeps serving the metrics it
+// had. It swaps the package variables without synchronization, so it must
+// not run while metrics are being updated.
+func Reset() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	registerMetrics(reg, namespace)
+	return reg
+}
+
+// collectors holds every metric of the last registerMetrics call, for
+// Register.
+var collectors []prometheus.Collector
+
+// register adds c to reg, returning the collector already registered under
+// the same descriptor when there is one.
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				collectors = append(collectors, existing)
+				return existing
+			}
+		}
+		panic(err)
+	}
+	collectors = append(collectors, c)
+	return c
+}
+
+// latencyBuckets span a cache hit (~1µs) to a slow lookup (~10ms) in ten
+// buckets.
+var latencyBuckets = []float64{.000001, .0000025, .000005, .00001, .000025, .00005, .0001, .00025, .001, .01}
+
+func registerMetrics(reg prometheus.Registerer, namespace string) {
+	collectors = nil
 	RequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "geoip_auth_requests_total",
-			Help: "Total number of auth requests",
+			Namespace: namespace,
+			Name:      "auth_requests_total",
+			Help:      "Total number of auth requests",
 		},
 		[]string{"country", "allowed"},
 	)
+	VerdictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_verdicts_total",
+			Help:      "Total number of auth verdicts by whether they were served from cache",
+		},
+		[]string{"cached"},
+	)
+	WouldDenyTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_would_deny_total",
+			Help:      "Total number of requests allowed in monitor mode that the policy would have denied",
+		},
+		[]string{"country"},
+	)
+	IPSourceTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ip_source_total",
+			Help:      "Total number of auth requests by where the client IP was taken from (header or remoteaddr)",
+		},
+		[]string{"source"},
+	)
+	RequestRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_rejected_total",
+			Help:      "Total number of requests rejected by a request limit or malformed body, by reason",
+		},
+		[]string{"reason"},
+	)
 	CacheHits = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "geoip_auth_cache_hits_total",
-			Help: "Total number of cache hits",
+			Namespace: namespace,
+			Name:      "auth_cache_hits_total",
+			Help:      "Total number of cache hits",
 		},
 	)
 	CacheEvictions = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "geoip_auth_cache_evictions_total",
-			Help: "Total number of cache purges",
+			Namespace: namespace,
+			Name:      "auth_cache_evictions_total",
+			Help:      "Total number of cache purges",
+		},
+	)
+	CacheKeysThrottled = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_cache_keys_throttled_total",
+			Help:      "Total number of verdicts served uncached because new cache keys exceeded -cache-new-key-rate",
+		},
+	)
+	LookupNotFound = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lookup_notfound_total",
+			Help:      "Total number of database lookups that found no country for the IP",
+		},
+	)
+	CacheOldestEntryAge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "auth_cache_oldest_entry_age_seconds",
+			Help:      "Age of the oldest cached verdict, sampled from large caches",
+		},
+		func() float64 {
+			if f := cacheOldestAge.Load(); f != nil {
+				return (*f)().Seconds()
+			}
+			return 0
+		},
+	)
+	VerdictDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "auth_verdict_duration_seconds",
+			Help:      "Time from the start of an auth request to its verdict, by whether it was served from cache",
+			Buckets:   latencyBuckets,
+		},
+		[]string{"cached"},
+	)
+	LookupDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "auth_lookup_duration_seconds",
+			Help:      "Time cache misses spend evaluating the database lookup",
+			Buckets:   latencyBuckets,
 		},
 	)
 
 	// Remote fetcher metrics
 	FetchAttemptsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "geoip_remote_fetch_attempts_total",
-			Help: "Total number of remote fetch attempts",
+			Namespace: namespace,
+			Name:      "remote_fetch_attempts_total",
+			Help:      "Total number of remote fetch attempts",
 		},
 		[]string{"endpoint"},
 	)
 	FetchSuccessTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "geoip_remote_fetch_success_total",
-			Help: "Total number of successful remote fetches",
+			Namespace: namespace,
+			Name:      "remote_fetch_success_total",
+			Help:      "Total number of successful remote fetches",
 		},
 	)
 	FetchErrorsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "geoip_remote_fetch_errors_total",
-			Help: "Total number of remote fetch errors by type",
+			Namespace: namespace,
+			Name:      "remote_fetch_errors_total",
+			Help:      "Total number of remote fetch errors by type",
 		},
 		[]string{"error_type"},
 	)
+	FetchBytesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "remote_fetch_bytes_total",
+			Help:      "Total number of bytes read from remote fetch response bodies",
+		},
+	)
+
+	FetchBreakerState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "remote_fetch_breaker_state",
+			Help:      "State of the remote fetch circuit breaker (0 closed, 1 open, 2 half-open)",
+		},
+	)
+
+	FetchHealthy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "remote_fetch_healthy",
+			Help:      "Whether recent remote fetches have been succeeding (1) or not (0), independent of whether a database is loaded",
+		},
+	)
+
+	DBLastReloadTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "db_last_reload_timestamp_seconds",
+			Help:      "Unix time of the last successful database swap by source type",
+		},
+		[]string{"source"},
+	)
+	DBFileSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "db_file_size_bytes",
+			Help:      "Size of the installed database file by source type",
+		},
+		[]string{"source"},
+	)
+	DBReaderSwapsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_reader_swaps_total",
+			Help:      "Total number of database readers installed by source type",
+		},
+		[]string{"source"},
+	)
+	DBAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "db_age_seconds",
+			Help:      "Seconds since the build of the serving database, refreshed every -db-age-interval",
+		},
+	)
+
+	HTTPActiveConnections = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_active_connections",
+			Help:      "Number of open client connections to the main listener",
+		},
+	)
+	HTTPNewConnectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_new_connections_total",
+			Help:      "Total number of client connections accepted by the main listener",
+		},
+	)
+	AuditEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "audit_events_total",
+			Help:      "Total number of verdict audit events by result: published, dropped or failed",
+		},
+		[]string{"result"},
+	)
 
-	prometheus.MustRegister(RequestsTotal)
-	prometheus.MustRegister(CacheHits)
-	prometheus.MustRegister(CacheEvictions)
-	prometheus.MustRegister(FetchAttemptsTotal)
-	prometheus.MustRegister(FetchSuccessTotal)
-	prometheus.MustRegister(FetchErrorsTotal)
+	RequestsTotal = register(reg, RequestsTotal)
+	VerdictsTotal = register(reg, VerdictsTotal)
+	WouldDenyTotal = register(reg, WouldDenyTotal)
+	IPSourceTotal = register(reg, IPSourceTotal)
+	RequestRejectedTotal = register(reg, RequestRejectedTotal)
+	CacheHits = register(reg, CacheHits)
+	CacheEvictions = register(reg, CacheEvictions)
+	CacheKeysThrottled = register(reg, CacheKeysThrottled)
+	LookupNotFound = register(reg, LookupNotFound)
+	CacheOldestEntryAge = register(reg, CacheOldestEntryAge)
+	VerdictDuration = register(reg, VerdictDuration)
+	LookupDuration = register(reg, LookupDuration)
+	FetchAttemptsTotal = register(reg, FetchAttemptsTotal)
+	FetchSuccessTotal = register(reg, FetchSuccessTotal)
+	FetchErrorsTotal = register(reg, FetchErrorsTotal)
+	FetchBytesTotal = register(reg, FetchBytesTotal)
+	FetchBreakerState = register(reg, FetchBreakerState)
+	FetchHealthy = register(reg, FetchHealthy)
+	DBLastReloadTimestamp = register(reg, DBLastReloadTimestamp)
+	DBFileSize = register(reg, DBFileSize)
+	DBReaderSwapsTotal = register(reg, DBReaderSwapsTotal)
+	DBAge = register(reg, DBAge)
+	HTTPActiveConnections = register(reg, HTTPActiveConnections)
+	HTTPNewConnectionsTotal = register(reg, HTTPNewConnectionsTotal)
+	AuditEventsTotal = register(reg, AuditEventsTotal)
 }
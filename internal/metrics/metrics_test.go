@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,12 +14,36 @@ func TestRegisterMetrics(t *testing.T) {
 	if RequestsTotal == nil {
 		t.Fatal("RequestsTotal should not be nil after registerMetrics")
 	}
+	if VerdictsTotal == nil {
+		t.Fatal("VerdictsTotal should not be nil after registerMetrics")
+	}
+	if WouldDenyTotal == nil {
+		t.Fatal("WouldDenyTotal should not be nil after registerMetrics")
+	}
 	if CacheHits == nil {
 		t.Fatal("CacheHits should not be nil after registerMetrics")
 	}
 	if CacheEvictions == nil {
 		t.Fatal("CacheEvictions should not be nil after registerMetrics")
 	}
+	if FetchBytesTotal == nil {
+		t.Fatal("FetchBytesTotal should not be nil after registerMetrics")
+	}
+	if FetchBreakerState == nil {
+		t.Fatal("FetchBreakerState should not be nil after registerMetrics")
+	}
+	if DBFileSize == nil {
+		t.Fatal("DBFileSize should not be nil after registerMetrics")
+	}
+	if DBLastReloadTimestamp == nil {
+		t.Fatal("DBLastReloadTimestamp should not be nil after registerMetrics")
+	}
+	if HTTPActiveConnections == nil {
+		t.Fatal("HTTPActiveConnections should not be nil after registerMetrics")
+	}
+	if HTTPNewConnectionsTotal == nil {
+		t.Fatal("HTTPNewConnectionsTotal should not be nil after registerMetrics")
+	}
 
 	// Test RequestsTotal labels
 	labels := prometheus.Labels{"country": "US", "allowed": "true"}
@@ -40,3 +65,84 @@ func TestRegisterMetrics(t *testing.T) {
 		t.Errorf("Expected CacheEvictions to be 2, got %v", testutil.ToFloat64(CacheEvictions))
 	}
 }
+
+func TestRegisterMetrics_Namespace(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	registerMetrics(reg, "edge")
+	RequestsTotal.WithLabelValues("US", "true").Inc()
+	DBLastReloadTimestamp.WithLabelValues("disk").Set(1)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	names := make(map[string]bool, len(families))
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "edge_") {
+			t.Errorf("Expected metric %s to carry the edge_ namespace", mf.GetName())
+		}
+		names[mf.GetName()] = true
+	}
+	for _, name := range []string{"edge_auth_requests_total", "edge_db_last_reload_timestamp_seconds"} {
+		if !names[name] {
+			t.Errorf("Expected %s to be registered, got %v", name, names)
+		}
+	}
+}
+
+func TestRegister_Twice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	Register(reg)
+	before := testutil.ToFloat64(CacheHits)
+	CacheHits.Inc()
+
+	Register(reg)
+	if got := testutil.ToFloat64(CacheHits); got != before+1 {
+		t.Errorf("Expected re-registration to keep the existing CacheHits, got %v", got)
+	}
+	if got := gatheredValue(t, reg, "auth_cache_hits_total"); got != before+1 {
+		t.Errorf("Expected the registry to serve the shared CacheHits, got %v", got)
+	}
+}
+
+// gatheredValue returns the value of the unlabeled counter whose name, less
+// the namespace, is name in g.
+func gatheredValue(t *testing.T, g prometheus.Gatherer, name string) float64 {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, mf := range families {
+		if strings.HasSuffix(mf.GetName(), "_"+name) {
+			return mf.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("metric %s not gathered", name)
+	return 0
+}
+
+func TestReset(t *testing.T) {
+	InitMetrics()
+	CacheHits.Inc()
+	before := gatheredValue(t, prometheus.DefaultGatherer, "auth_cache_hits_total")
+
+	reg := Reset()
+	fresh := Reset()
+	if fresh == reg {
+		t.Fatal("Expected Reset to return a new registry")
+	}
+	if got := testutil.ToFloat64(CacheHits); got != 0 {
+		t.Errorf("Expected CacheHits to start from zero after Reset, got %v", got)
+	}
+
+	// The returned registry serves the fresh collectors; the default one is
+	// left alone.
+	CacheHits.Add(3)
+	if got := gatheredValue(t, fresh, "auth_cache_hits_total"); got != 3 {
+		t.Errorf("Expected the returned registry to serve the reset CacheHits, got %v", got)
+	}
+	if got := gatheredValue(t, prometheus.DefaultGatherer, "auth_cache_hits_total"); got != before {
+		t.Errorf("Expected the default registry to be left alone, got %v, want %v", got, before)
+	}
+}
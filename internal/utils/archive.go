@@ -2,15 +2,57 @@ package utils
 
 import (
 	"archive/tar"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 )
 
+// ErrLinkMember is returned when the target or an .mmdb member is a symlink
+// or hardlink, which could point outside the archive.
+var ErrLinkMember = errors.New("archive member is a link")
+
+// ErrMemberTooLarge is returned when the fallback .mmdb member's size in the
+// archive header exceeds the caller's limit.
+var ErrMemberTooLarge = errors.New("archive member too large")
+
+// MemberNotFoundError is returned when the target file is not in the archive.
+// It lists the .mmdb members that were present to aid debugging.
+type MemberNotFoundError struct {
+	Target      string
+	MMDBMembers []string
+}
+
+func (e *MemberNotFoundError) Error() string {
+	if len(e.MMDBMembers) == 0 {
+		return fmt.Sprintf("file %s not found in archive", e.Target)
+	}
+	return fmt.Sprintf("file %s not found in archive (mmdb members: %s)",
+		e.Target, strings.Join(e.MMDBMembers, ", "))
+}
+
 // ExtractFileFromTar extracts a specific file from a tar archive.
 // It searches for the first file whose name contains the target string.
 // Returns a reader for the file content, the file size, and any error.
 func ExtractFileFromTar(tr *tar.Reader, target string) (io.Reader, int64, error) {
+	return extractFromTar(tr, target, false, 0)
+}
+
+// ExtractMMDBFromTar behaves like ExtractFileFromTar, but when anyMMDB is set
+// and the target is missing it falls back to the archive's only .mmdb member.
+// It still fails if the archive holds zero or several .mmdb members. A fallback
+// member larger than maxSize bytes fails with ErrMemberTooLarge before it is
+// buffered; 0 means no limit.
+func ExtractMMDBFromTar(tr *tar.Reader, target string, anyMMDB bool, maxSize int64) (io.Reader, int64, error) {
+	return extractFromTar(tr, target, anyMMDB, maxSize)
+}
+
+func extractFromTar(tr *tar.Reader, target string, anyMMDB bool, maxSize int64) (io.Reader, int64, error) {
+	var (
+		members   []string
+		candidate []byte
+	)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -20,6 +62,14 @@ func ExtractFileFromTar(tr *tar.Reader, target string) (io.Reader, int64, error)
 			return nil, 0, err
 		}
 
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			if strings.Contains(header.Name, target) || strings.HasSuffix(header.Name, ".mmdb") {
+				return nil, 0, fmt.Errorf("%w: %s -> %s", ErrLinkMember, header.Name, header.Linkname)
+			}
+			continue
+		}
+		// PAX and GNU long names are resolved into header.Name by
+		// archive/tar, so they match like any other regular file.
 		if header.Typeflag != tar.TypeReg {
 			continue
 		}
@@ -33,6 +83,26 @@ func ExtractFileFromTar(tr *tar.Reader, target string) (io.Reader, int64, error)
 			// Wrap in a LimitedReader to avoid reading beyond the file size
 			return io.LimitReader(tr, header.Size), header.Size, nil
 		}
+
+		if strings.HasSuffix(header.Name, ".mmdb") {
+			members = append(members, header.Name)
+			// The tar stream cannot be rewound, so keep the first candidate
+			// around in case the target never shows up.
+			if anyMMDB && len(members) == 1 {
+				// The header size is untrusted, so check it before the
+				// candidate is buffered.
+				if maxSize > 0 && header.Size > maxSize {
+					return nil, 0, fmt.Errorf("%w: %s is %d bytes", ErrMemberTooLarge, header.Name, header.Size)
+				}
+				if candidate, err = io.ReadAll(io.LimitReader(tr, header.Size)); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	}
+
+	if anyMMDB && len(members) == 1 {
+		return bytes.NewReader(candidate), int64(len(candidate)), nil
 	}
-	return nil, 0, fmt.Errorf("file %s not found in archive", target)
+	return nil, 0, &MemberNotFoundError{Target: target, MMDBMembers: members}
 }
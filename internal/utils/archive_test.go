@@ -3,7 +3,11 @@ package utils
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
 	"io"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -93,3 +97,158 @@ func TestExtractFileFromTar_DirectoryTraversal(t *testing.T) {
 		t.Error("Expected error for directory traversal attempt")
 	}
 }
+
+func TestExtractFileFromTar_LinkMembers(t *testing.T) {
+	for name, typeflag := range map[string]byte{"symlink": tar.TypeSymlink, "hardlink": tar.TypeLink} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			tw.WriteHeader(&tar.Header{
+				Name:     "GeoLite2-Country.mmdb",
+				Linkname: "/etc/passwd",
+				Mode:     0644,
+				Typeflag: typeflag,
+			})
+			tw.Close()
+
+			_, _, err := ExtractFileFromTar(tar.NewReader(&buf), "GeoLite2-Country.mmdb")
+			if !errors.Is(err, ErrLinkMember) {
+				t.Fatalf("Expected ErrLinkMember, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "/etc/passwd") {
+				t.Errorf("Expected the link target in the error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractFileFromTar_PAXLongName(t *testing.T) {
+	longName := strings.Repeat("very-long-directory-name/", 8) + "GeoLite2-Country.mmdb"
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	// An unrelated link is skipped rather than rejected.
+	tw.WriteHeader(&tar.Header{Name: "latest", Linkname: "elsewhere", Typeflag: tar.TypeSymlink})
+	hdr := &tar.Header{
+		Name:     longName,
+		Mode:     0644,
+		Size:     int64(len("database content")),
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("failed to write PAX header: %v", err)
+	}
+	tw.Write([]byte("database content"))
+	tw.Close()
+
+	reader, size, err := ExtractFileFromTar(tar.NewReader(&buf), "GeoLite2-Country.mmdb")
+	if err != nil {
+		t.Fatalf("Expected the PAX long-named file to match, got %v", err)
+	}
+	content, _ := io.ReadAll(reader)
+	if size != int64(len(content)) || string(content) != "database content" {
+		t.Errorf("Expected the database content, got %q (size %d)", content, size)
+	}
+}
+
+func newTestTar(t *testing.T, files map[string]string) *tar.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	return tar.NewReader(&buf)
+}
+
+func TestExtractMMDBFromTar_Fallback(t *testing.T) {
+	tests := []struct {
+		name            string
+		files           map[string]string
+		anyMMDB         bool
+		expectedContent string
+		expectedMembers []string
+	}{
+		{
+			name:            "zero mmdb members",
+			files:           map[string]string{"README.txt": "readme"},
+			anyMMDB:         true,
+			expectedMembers: nil,
+		}, {
+			name:            "one mmdb member",
+			files:           map[string]string{"README.txt": "readme", "dir/GeoIP2-Country.mmdb": "other db"},
+			anyMMDB:         true,
+			expectedContent: "other db",
+		}, {
+			name:            "one mmdb member without fallback",
+			files:           map[string]string{"dir/GeoIP2-Country.mmdb": "other db"},
+			anyMMDB:         false,
+			expectedMembers: []string{"dir/GeoIP2-Country.mmdb"},
+		}, {
+			name:            "many mmdb members",
+			files:           map[string]string{"a.mmdb": "a", "b.mmdb": "b"},
+			anyMMDB:         true,
+			expectedMembers: []string{"a.mmdb", "b.mmdb"},
+		}, {
+			name:            "target preferred over fallback",
+			files:           map[string]string{"GeoLite2-Country.mmdb": "target db"},
+			anyMMDB:         true,
+			expectedContent: "target db",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, size, err := ExtractMMDBFromTar(newTestTar(t, tc.files), "GeoLite2-Country.mmdb", tc.anyMMDB, 0)
+			if tc.expectedContent == "" {
+				var notFound *MemberNotFoundError
+				if !errors.As(err, &notFound) {
+					t.Fatalf("Expected MemberNotFoundError, got %v", err)
+				}
+				got := append([]string(nil), notFound.MMDBMembers...)
+				sort.Strings(got)
+				if !slices.Equal(got, tc.expectedMembers) {
+					t.Errorf("Expected members %v, got %v", tc.expectedMembers, got)
+				}
+				for _, member := range tc.expectedMembers {
+					if !strings.Contains(err.Error(), member) {
+						t.Errorf("Expected error to list %q, got %v", member, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to extract file: %v", err)
+			}
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.expectedContent || size != int64(len(tc.expectedContent)) {
+				t.Errorf("Expected %q (%d bytes), got %q (%d bytes)", tc.expectedContent, len(tc.expectedContent), content, size)
+			}
+		})
+	}
+}
+
+func TestExtractMMDBFromTar_MaxSize(t *testing.T) {
+	files := map[string]string{"dir/GeoIP2-Country.mmdb": "0123456789"}
+	_, _, err := ExtractMMDBFromTar(newTestTar(t, files), "GeoLite2-Country.mmdb", true, 4)
+	if !errors.Is(err, ErrMemberTooLarge) {
+		t.Errorf("Expected ErrMemberTooLarge, got %v", err)
+	}
+	if _, _, err := ExtractMMDBFromTar(newTestTar(t, files), "GeoLite2-Country.mmdb", true, 10); err != nil {
+		t.Errorf("Expected a member at the limit to be extracted, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// LockFile takes an advisory flock on the ".lock" file next to path, creating
+// it if needed, and blocks until the lock is granted. The database itself
+// cannot carry the lock, since a replace swaps its inode. exclusive is for
+// writers replacing path; readers take a shared lock so they never open a
+// half-swapped file. The returned function releases the lock.
+func LockFile(path string, exclusive bool) (func() error, error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open lock file")
+	}
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), how)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to lock file")
+	}
+	return f.Close, nil
+}
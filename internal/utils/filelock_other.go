@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "github.com/pkg/errors"
+
+func LockFile(path string, exclusive bool) (func() error, error) {
+	return nil, errors.New("-db-file-lock is not supported on this platform")
+}
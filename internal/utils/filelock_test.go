@@ -0,0 +1,86 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAtomicReplaceFileLocked_Serializes(t *testing.T) {
+	dir := t.TempDir()
+	targetPath := filepath.Join(dir, "target.mmdb")
+	if err := os.WriteFile(targetPath, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first replacer holds the lock while the second one tries to swap.
+	unlock, err := LockFile(targetPath, true)
+	if err != nil {
+		t.Fatalf("LockFile failed: %v", err)
+	}
+	tmpPath := filepath.Join(dir, "second.tmp")
+	if err := os.WriteFile(tmpPath, []byte("second"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- AtomicReplaceFileLocked(tmpPath, targetPath) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("second replace finished while the lock was held: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if content, _ := os.ReadFile(targetPath); string(content) != "original" {
+		t.Errorf("Expected the target untouched while locked, got %q", content)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("AtomicReplaceFileLocked failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second replace did not finish after the lock was released")
+	}
+	if content, _ := os.ReadFile(targetPath); string(content) != "second" {
+		t.Errorf("Expected 'second', got %q", content)
+	}
+}
+
+func TestLockFile_SharedWaitsForExclusive(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "target.mmdb")
+	unlock, err := LockFile(targetPath, true)
+	if err != nil {
+		t.Fatalf("LockFile failed: %v", err)
+	}
+
+	locked := make(chan func() error, 1)
+	go func() {
+		unlockShared, err := LockFile(targetPath, false)
+		if err != nil {
+			t.Errorf("shared LockFile failed: %v", err)
+		}
+		locked <- unlockShared
+	}()
+	select {
+	case <-locked:
+		t.Fatal("shared lock granted while the exclusive lock was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case unlockShared := <-locked:
+		if unlockShared != nil {
+			unlockShared()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared lock not granted after the exclusive lock was released")
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -35,6 +37,66 @@ func AtomicReplaceFile(tmpPath, targetPath string) error {
 	return nil
 }
 
+// AtomicReplaceFileLocked is AtomicReplaceFile holding an exclusive LockFile
+// lock on targetPath, so concurrent replacers serialize.
+func AtomicReplaceFileLocked(tmpPath, targetPath string) error {
+	unlock, err := LockFile(targetPath, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return AtomicReplaceFile(tmpPath, targetPath)
+}
+
+// RemoveTempFile removes the temporary file CreateTempFile made for basePath,
+// if there is one.
+func RemoveTempFile(basePath string) error {
+	if err := os.Remove(basePath + ".tmp"); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove temporary file")
+	}
+	return nil
+}
+
+// RemoveOrphanedFiles cleans up what an interrupted replace of basePath can
+// leave behind: the ".tmp" file, randomized "basePath.*.tmp" files and the
+// ".backup" file. A backup is restored instead of removed when basePath itself
+// is missing, since it then holds the only copy of the database. It returns
+// the paths it removed.
+func RemoveOrphanedFiles(basePath string) ([]string, error) {
+	backupPath := basePath + ".backup"
+	if _, err := os.Stat(basePath); os.IsNotExist(err) {
+		if err := os.Rename(backupPath, basePath); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to restore backup file")
+		}
+	}
+
+	candidates := []string{basePath + ".tmp", backupPath}
+	entries, err := os.ReadDir(filepath.Dir(basePath))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to list database directory")
+	}
+	prefix := filepath.Base(basePath) + "."
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && len(name) > len(prefix)+len(".tmp") &&
+			strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".tmp") {
+			candidates = append(candidates, filepath.Join(filepath.Dir(basePath), name))
+		}
+	}
+
+	var removed []string
+	for _, path := range candidates {
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, errors.Wrapf(err, "failed to remove %s", path)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
 // CreateTempFile creates a temporary file with the given base path.
 // The temporary file will have a ".tmp" suffix.
 func CreateTempFile(basePath string) (*os.File, string, error) {
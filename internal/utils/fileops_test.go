@@ -131,3 +131,75 @@ func TestCreateTempFile(t *testing.T) {
 		t.Errorf("Expected 'test content', got '%s'", string(content))
 	}
 }
+
+func TestRemoveTempFile(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "test.mmdb")
+
+	// Nothing to remove is not an error.
+	if err := RemoveTempFile(basePath); err != nil {
+		t.Fatalf("RemoveTempFile without a temp file failed: %v", err)
+	}
+
+	file, tmpPath, err := CreateTempFile(basePath)
+	if err != nil {
+		t.Fatalf("CreateTempFile failed: %v", err)
+	}
+	file.Close()
+	if err := RemoveTempFile(basePath); err != nil {
+		t.Fatalf("RemoveTempFile failed: %v", err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", tmpPath, err)
+	}
+}
+
+func TestRemoveOrphanedFiles(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	for _, name := range []string{
+		"GeoLite2-Country.mmdb",
+		"GeoLite2-Country.mmdb.tmp",
+		"GeoLite2-Country.mmdb.123456.tmp",
+		"GeoLite2-Country.mmdb.backup",
+		"GeoLite2-City.mmdb.tmp",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := RemoveOrphanedFiles(basePath)
+	if err != nil {
+		t.Fatalf("RemoveOrphanedFiles failed: %v", err)
+	}
+	if len(removed) != 3 {
+		t.Errorf("Expected 3 removed files, got %v", removed)
+	}
+	for _, name := range []string{"GeoLite2-Country.mmdb", "GeoLite2-City.mmdb.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+}
+
+func TestRemoveOrphanedFiles_RestoresBackup(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(basePath+".backup", []byte("backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(basePath+".tmp", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RemoveOrphanedFiles(basePath); err != nil {
+		t.Fatalf("RemoveOrphanedFiles failed: %v", err)
+	}
+	content, err := os.ReadFile(basePath)
+	if err != nil || string(content) != "backup" {
+		t.Errorf("Expected the backup to be restored, got %q, %v", content, err)
+	}
+	if _, err := os.Stat(basePath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected the temp file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package webserver
+
+import (
+	"net"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// asnRecord is the part of a MaxMind ASN record the allow-list needs.
+type asnRecord struct {
+	Number uint `maxminddb:"autonomous_system_number"`
+}
+
+// asnSource is the ASN database ASnnnn -allow entries are matched against;
+// nil without -asn-db.
+var asnSource db.GeoIPSource
+
+// SetASNSource installs the ASN database. It must be called before Run.
+func SetASNSource(source db.GeoIPSource) {
+	asnSource = source
+}
+
+// asnAllowed reports whether ip belongs to an allow-listed ASN. A missing or
+// not yet ready ASN database matches nothing.
+var asnAllowed = func(ip net.IP) bool {
+	allowed := config.GetAllowedASNs()
+	if len(allowed) == 0 || asnSource == nil || !asnSource.IsReady() {
+		return false
+	}
+	var record asnRecord
+	if err := asnSource.GetReader().Lookup(ip, &record); err != nil {
+		log.Warn().Err(err).Str("ip", ip.String()).Msg("ASN lookup failed")
+		return false
+	}
+	return allowed[record.Number]
+}
@@ -0,0 +1,75 @@
+package webserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestServeHTTP_AllowedASN(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US,AS15169", "--db=test.db", "--asn-db=asn.mmdb"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	metrics.InitMetrics()
+	exposeReason = func() bool { return true }
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+
+	asns := map[string]uint{"8.8.8.8": 15169, "1.1.1.1": 13335}
+	asnSource = &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+		record.(*asnRecord).Number = asns[ip.String()]
+		return nil
+	}}
+	// Every IP resolves to a country that is not allowed.
+	handler := NewAuthHandler(&mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+		record.(*geoRecord).Country.ISOCode = "RU"
+		return nil
+	}})
+
+	tests := []struct {
+		name           string
+		ip             string
+		expectedStatus int
+		expectedReason string
+	}{
+		{name: "Allow-listed ASN overrides the country", ip: "8.8.8.8", expectedStatus: http.StatusOK, expectedReason: "asn"},
+		{name: "Other ASN is denied", ip: "1.1.1.1", expectedStatus: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP(tc.ip) }
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/auth", nil))
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if got := rr.Header().Get(allowReasonHeader); got != tc.expectedReason {
+				t.Errorf("Expected %s %q, got %q", allowReasonHeader, tc.expectedReason, got)
+			}
+			if got := rr.Header().Get("X-Country"); rr.Code == http.StatusOK && got != "RU" {
+				t.Errorf("Expected X-Country RU, got %q", got)
+			}
+		})
+	}
+}
+
+func TestAsnAllowed_NoASNDatabase(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US,AS15169", "--db=test.db", "--asn-db=asn.mmdb"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	if asnAllowed(net.ParseIP("8.8.8.8")) {
+		t.Error("Expected no ASN match without an ASN database")
+	}
+	asnSource = &mockGeoIPSource{ready: false}
+	if asnAllowed(net.ParseIP("8.8.8.8")) {
+		t.Error("Expected no ASN match while the ASN database is not ready")
+	}
+}
@@ -0,0 +1,155 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Values of the result label of the audit events metric.
+const (
+	auditPublished = "published"
+	auditDropped   = "dropped"
+	auditFailed    = "failed"
+)
+
+type (
+	// auditEvent is the record of an /auth verdict published for audit.
+	auditEvent struct {
+		Timestamp time.Time `json:"timestamp"`
+		IP        string    `json:"ip"`
+		Country   string    `json:"country"`
+		Allowed   bool      `json:"allowed"`
+		Reason    string    `json:"reason"`
+	}
+
+	// auditPublisher delivers encoded audit events to a broker subject.
+	// Publish may return before the broker confirms the event, so several
+	// can be in flight; done is called once with the outcome. Close waits
+	// for the events in flight.
+	auditPublisher interface {
+		Publish(subject string, payload []byte, done func(error))
+		Close() error
+	}
+
+	// auditLog queues audit events and publishes them from one goroutine,
+	// so a slow or unreachable broker never fails a verdict, and under the
+	// drop policy never delays one either.
+	auditLog struct {
+		publisher auditPublisher
+		topic     string
+		block     bool
+		events    chan auditEvent
+		done      chan struct{}
+		stopped   chan struct{}
+	}
+)
+
+// audit publishes /auth verdicts when -audit-broker is set; nil disables it.
+var audit atomic.Pointer[auditLog]
+
+func newAuditLog(publisher auditPublisher, topic string, buffer int, policy string) *auditLog {
+	l := &auditLog{
+		publisher: publisher,
+		topic:     topic,
+		block:     policy == config.AuditPolicyBlock,
+		events:    make(chan auditEvent, buffer),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// enqueue queues event for publishing. A full queue drops it, or with the
+// block policy waits for room until the log is stopped.
+func (l *auditLog) enqueue(event auditEvent) {
+	select {
+	case <-l.done:
+		metrics.AuditEventsTotal.WithLabelValues(auditDropped).Inc()
+		return
+	default:
+	}
+	if l.block {
+		select {
+		case l.events <- event:
+		case <-l.done:
+			metrics.AuditEventsTotal.WithLabelValues(auditDropped).Inc()
+		}
+		return
+	}
+	select {
+	case l.events <- event:
+	default:
+		metrics.AuditEventsTotal.WithLabelValues(auditDropped).Inc()
+	}
+}
+
+func (l *auditLog) run() {
+	defer close(l.stopped)
+	for {
+		select {
+		case event := <-l.events:
+			l.publish(event)
+		case <-l.done:
+			// Flush what was queued before the stop.
+			for {
+				select {
+				case event := <-l.events:
+					l.publish(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *auditLog) publish(event auditEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		l.published(err)
+		return
+	}
+	l.publisher.Publish(l.topic, payload, l.published)
+}
+
+// published counts the outcome of publishing an event.
+func (l *auditLog) published(err error) {
+	if err != nil {
+		metrics.AuditEventsTotal.WithLabelValues(auditFailed).Inc()
+		log.Warn().Err(err).Str("topic", l.topic).Msg("Failed to publish audit event")
+		return
+	}
+	metrics.AuditEventsTotal.WithLabelValues(auditPublished).Inc()
+}
+
+// stop publishes the queued events and closes the publisher, which waits
+// for the broker to confirm them.
+func (l *auditLog) stop() {
+	close(l.done)
+	<-l.stopped
+	if err := l.publisher.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close audit publisher")
+	}
+}
+
+// recordAudit queues the verdict reached for ip when auditing is enabled.
+func recordAudit(ip net.IP, entry cacheEntry) {
+	l := audit.Load()
+	if l == nil {
+		return
+	}
+	l.enqueue(auditEvent{
+		Timestamp: time.Now().UTC(),
+		IP:        ip.String(),
+		Country:   entry.country,
+		Allowed:   entry.allowed,
+		Reason:    entry.reason,
+	})
+}
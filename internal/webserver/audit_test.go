@@ -0,0 +1,345 @@
+package webserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+// fakePublisher records published payloads. When release is set, each
+// publish first reports on started and then waits for release.
+type fakePublisher struct {
+	mutex    sync.Mutex
+	subjects []string
+	payloads [][]byte
+	closed   bool
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (f *fakePublisher) Publish(subject string, payload []byte, done func(error)) {
+	if f.release != nil {
+		f.started <- struct{}{}
+		<-f.release
+	}
+	f.mutex.Lock()
+	f.subjects = append(f.subjects, subject)
+	f.payloads = append(f.payloads, payload)
+	f.mutex.Unlock()
+	done(nil)
+}
+
+func (f *fakePublisher) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	return nil
+}
+
+func TestServeHTTP_Audit(t *testing.T) {
+	defer resetGlobals()
+	metrics.InitMetrics()
+	getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP(r.Header.Get("X-Test-IP")) }
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return ip.IsPrivate() }
+	handler := NewAuthHandler(&mockGeoIPSource{
+		ready: true,
+		lookup: func(ip net.IP, record any) error {
+			record.(*geoRecord).Country.ISOCode = "RU"
+			return nil
+		},
+	})
+	publisher := &fakePublisher{}
+	l := newAuditLog(publisher, "verdicts", 8, config.AuditPolicyDrop)
+	audit.Store(l)
+
+	for _, ip := range []string{"10.0.0.1", "2.3.4.5"} {
+		req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		req.Header.Set("X-Test-IP", ip)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	l.stop()
+
+	want := []auditEvent{
+		{IP: "10.0.0.1", Country: "LAN", Allowed: true, Reason: reasonLAN},
+		{IP: "2.3.4.5", Country: "RU", Allowed: false, Reason: reasonCountryNotAllowed},
+	}
+	if len(publisher.payloads) != len(want) {
+		t.Fatalf("Expected %d audit events, got %d", len(want), len(publisher.payloads))
+	}
+	for i, payload := range publisher.payloads {
+		var event auditEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatalf("failed to decode audit event %q: %v", payload, err)
+		}
+		if event.Timestamp.IsZero() {
+			t.Errorf("Expected event %d to carry a timestamp", i)
+		}
+		event.Timestamp = want[i].Timestamp
+		if event != want[i] {
+			t.Errorf("Expected event %+v, got %+v", want[i], event)
+		}
+		if publisher.subjects[i] != "verdicts" {
+			t.Errorf("Expected subject verdicts, got %q", publisher.subjects[i])
+		}
+	}
+	if !publisher.closed {
+		t.Error("Expected stop to close the publisher")
+	}
+}
+
+func TestAuditLog_DropsWhenFull(t *testing.T) {
+	metrics.InitMetrics()
+	publisher := &fakePublisher{started: make(chan struct{}), release: make(chan struct{})}
+	l := newAuditLog(publisher, "verdicts", 1, config.AuditPolicyDrop)
+	dropped := metrics.AuditEventsTotal.WithLabelValues(auditDropped)
+	before := testutil.ToFloat64(dropped)
+
+	// The first event is being published, the second is queued and the
+	// third finds the queue full.
+	l.enqueue(auditEvent{IP: "192.0.2.1"})
+	<-publisher.started
+	l.enqueue(auditEvent{IP: "192.0.2.2"})
+	l.enqueue(auditEvent{IP: "192.0.2.3"})
+	if got := testutil.ToFloat64(dropped) - before; got != 1 {
+		t.Errorf("Expected 1 dropped event, got %v", got)
+	}
+
+	go func() {
+		for range publisher.started {
+		}
+	}()
+	close(publisher.release)
+	l.stop()
+	close(publisher.started)
+	if len(publisher.payloads) != 2 {
+		t.Errorf("Expected the queued events to be published, got %d", len(publisher.payloads))
+	}
+}
+
+// startFakeNATS serves each connection accepted on a loopback port: it sends
+// info as the INFO payload, switches to TLS when tlsConfig is set, and hands
+// the connection to serve. It returns the address to dial.
+func startFakeNATS(t *testing.T, info string, tlsConfig *tls.Config, serve func(conn net.Conn, reader *bufio.Reader)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("INFO " + info + "\r\n"))
+			if tlsConfig != nil {
+				conn = tls.Server(conn, tlsConfig)
+			}
+			serve(conn, bufio.NewReader(conn))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// readNATSLine reads a protocol line from a fake NATS server's client.
+func readNATSLine(reader *bufio.Reader) string {
+	line, _ := reader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// publishNATS publishes payload and waits for the outcome.
+func publishNATS(p *natsPublisher, subject string, payload []byte) error {
+	result := make(chan error, 1)
+	p.Publish(subject, payload, func(err error) { result <- err })
+	return <-result
+}
+
+func TestNATSPublisher(t *testing.T) {
+	lines := make(chan string, 8)
+	addr := startFakeNATS(t, `{"server_id":"test"}`, nil, func(conn net.Conn, reader *bufio.Reader) {
+		lines <- readNATSLine(reader) // CONNECT
+		conn.Write([]byte("+OK\r\n"))
+		lines <- readNATSLine(reader) // PUB
+		lines <- readNATSLine(reader) // payload
+		conn.Write([]byte("+OK\r\nPING\r\n"))
+		lines <- readNATSLine(reader)
+	})
+
+	publisher, err := newNATSPublisher("nats://audit:secret@" + addr)
+	if err != nil {
+		t.Fatalf("newNATSPublisher failed: %v", err)
+	}
+	defer publisher.Close()
+	if err := publishNATS(publisher, "verdicts", []byte(`{"ip":"192.0.2.1"}`)); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	var connect natsConnect
+	line := <-lines
+	if !strings.HasPrefix(line, "CONNECT ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect) != nil {
+		t.Fatalf("Expected a CONNECT, got %q", line)
+	}
+	if connect.User != "audit" || connect.Pass != "secret" || !connect.Verbose || connect.TLS {
+		t.Errorf("Expected verbose plaintext CONNECT with the URL credentials, got %+v", connect)
+	}
+	for _, want := range []string{"PUB verdicts 18", `{"ip":"192.0.2.1"}`, "PONG"} {
+		if got := <-lines; got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestNATSPublisher_ServerErrorFailsPublish(t *testing.T) {
+	metrics.InitMetrics()
+	var accepted atomic.Int32
+	addr := startFakeNATS(t, `{"server_id":"test"}`, nil, func(conn net.Conn, reader *bufio.Reader) {
+		n := accepted.Add(1)
+		readNATSLine(reader) // CONNECT
+		conn.Write([]byte("+OK\r\n"))
+		for {
+			if line := readNATSLine(reader); line == "" {
+				return
+			}
+			readNATSLine(reader) // payload
+			if n == 1 {
+				conn.Write([]byte("-ERR 'Permissions Violation for Publish to verdicts'\r\n"))
+			} else {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+	publisher, err := newNATSPublisher("nats://" + addr)
+	if err != nil {
+		t.Fatalf("newNATSPublisher failed: %v", err)
+	}
+
+	// The rejected event is counted as failed, not published.
+	published := metrics.AuditEventsTotal.WithLabelValues(auditPublished)
+	failed := metrics.AuditEventsTotal.WithLabelValues(auditFailed)
+	publishedBefore, failedBefore := testutil.ToFloat64(published), testutil.ToFloat64(failed)
+	l := newAuditLog(publisher, "verdicts", 1, config.AuditPolicyBlock)
+	l.enqueue(auditEvent{IP: "192.0.2.1"})
+	l.stop()
+	if got := testutil.ToFloat64(failed) - failedBefore; got != 1 {
+		t.Errorf("Expected the rejected event to be counted as failed, got %v", got)
+	}
+	if got := testutil.ToFloat64(published) - publishedBefore; got != 0 {
+		t.Errorf("Expected no published event, got %v", got)
+	}
+
+	// The error dropped the connection; the next publish goes out on a new
+	// one.
+	if err := publishNATS(publisher, "verdicts", []byte("{}")); err != nil {
+		t.Errorf("Expected the publish after the error to succeed, got %v", err)
+	}
+	if got := accepted.Load(); got != 2 {
+		t.Errorf("Expected a reconnect after the server error, got %d connections", got)
+	}
+	publisher.Close()
+}
+
+func TestNATSPublisher_PipelinesPublishes(t *testing.T) {
+	addr := startFakeNATS(t, `{"server_id":"test"}`, nil, func(conn net.Conn, reader *bufio.Reader) {
+		readNATSLine(reader) // CONNECT
+		conn.Write([]byte("+OK\r\n"))
+		// Acknowledge only once all three publishes have arrived, which
+		// they cannot if each waits for its +OK.
+		for range 3 {
+			readNATSLine(reader) // PUB
+			readNATSLine(reader) // payload
+		}
+		conn.Write([]byte("+OK\r\n+OK\r\n-ERR 'Permissions Violation for Publish to denied'\r\n"))
+		readNATSLine(reader)
+	})
+	publisher, err := newNATSPublisher("nats://" + addr)
+	if err != nil {
+		t.Fatalf("newNATSPublisher failed: %v", err)
+	}
+	defer publisher.Close()
+
+	results := make(chan error, 3)
+	for _, subject := range []string{"verdicts", "verdicts", "denied"} {
+		publisher.Publish(subject, []byte("{}"), func(err error) { results <- err })
+	}
+	for i, wantErr := range []bool{false, false, true} {
+		if err := <-results; (err != nil) != wantErr {
+			t.Errorf("Expected publish %d to fail=%v, got %v", i+1, wantErr, err)
+		}
+	}
+}
+
+func TestNATSPublisher_ConnectRejected(t *testing.T) {
+	addr := startFakeNATS(t, `{"server_id":"test","auth_required":true}`, nil, func(conn net.Conn, reader *bufio.Reader) {
+		readNATSLine(reader) // CONNECT
+		conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+	})
+	publisher, err := newNATSPublisher("nats://audit:wrong@" + addr)
+	if err != nil {
+		t.Fatalf("newNATSPublisher failed: %v", err)
+	}
+	defer publisher.Close()
+	err = publishNATS(publisher, "verdicts", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected the rejected CONNECT to fail the publish, got %v", err)
+	}
+}
+
+func TestNATSPublisher_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certPath, keyPath, 1)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("failed to load key pair: %v", err)
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+
+	lines := make(chan string, 2)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+	addr := startFakeNATS(t, `{"server_id":"test","tls_required":true}`, serverTLS, func(conn net.Conn, reader *bufio.Reader) {
+		lines <- readNATSLine(reader) // CONNECT
+		conn.Write([]byte("+OK\r\n"))
+		lines <- readNATSLine(reader) // PUB
+		readNATSLine(reader)          // payload
+		conn.Write([]byte("+OK\r\n"))
+	})
+	publisher, err := newNATSPublisher("nats://" + addr)
+	if err != nil {
+		t.Fatalf("newNATSPublisher failed: %v", err)
+	}
+	publisher.tlsConfig = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	defer publisher.Close()
+	if err := publishNATS(publisher, "verdicts", []byte("{}")); err != nil {
+		t.Fatalf("Publish over TLS failed: %v", err)
+	}
+
+	var connect natsConnect
+	line := <-lines
+	if json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect) != nil || !connect.TLS {
+		t.Errorf("Expected a CONNECT requiring TLS, got %q", line)
+	}
+	if got := <-lines; got != "PUB verdicts 2" {
+		t.Errorf("Expected %q, got %q", "PUB verdicts 2", got)
+	}
+}
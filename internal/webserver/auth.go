@@ -1,9 +1,12 @@
 package webserver
 
 import (
+	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rdwr-valentineg/GeoIP/internal/config"
 	"github.com/rdwr-valentineg/GeoIP/internal/db"
@@ -18,84 +21,652 @@ type (
 
 	geoRecord struct {
 		Country struct {
-			ISOCode string `maxminddb:"iso_code"`
+			ISOCode           string            `maxminddb:"iso_code"`
+			Names             map[string]string `maxminddb:"names"`
+			IsInEuropeanUnion bool              `maxminddb:"is_in_european_union"`
 		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+		RepresentedCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"represented_country"`
+		Continent struct {
+			Code string `maxminddb:"code"`
+		} `maxminddb:"continent"`
+		// Subdivisions are only populated by City databases.
+		Subdivisions []struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"subdivisions"`
+		// Location is only populated by City databases.
+		Location struct {
+			TimeZone  string   `maxminddb:"time_zone"`
+			Latitude  *float64 `maxminddb:"latitude"`
+			Longitude *float64 `maxminddb:"longitude"`
+		} `maxminddb:"location"`
 	}
 	cacheEntry struct {
-		allowed bool
-		country string
+		allowed  bool
+		country  string
+		reason   string
+		timeZone string
+		names    map[string]string
+		// countryName is resolved per request from names and never cached.
+		countryName string
+		// compact drops the response body; it is set per request.
+		compact bool
+		// jsonDeny answers a denial with a denyResponse body; it is set per
+		// request from the Accept header.
+		jsonDeny bool
+		// fallback marks verdicts from the embedded fallback database; they
+		// are never cached so the primary takes over as soon as it is ready.
+		fallback bool
+		// network is the database network the record matched, e.g.
+		// "1.2.3.0/24"; it is empty for overrides and excluded IPs.
+		network string
+		// distanceKm is the distance from the geofence centre; it is only
+		// meaningful when geofenced is set.
+		distanceKm float64
+		geofenced  bool
+		// inEU is the record's EU membership flag; it is only meaningful
+		// when euKnown is set, i.e. the record has the MaxMind layout.
+		inEU    bool
+		euKnown bool
+		// storedAt is when the verdict was cached, for -cache-ttl.
+		storedAt time.Time
 	}
 )
 
+const (
+	reasonLAN               = "lan"
+	reasonCountryAllowed    = "country_allowed"
+	reasonCountryNotAllowed = "country_not_allowed"
+	reasonIPOverride        = "ip_override"
+	reasonGeofenceOutside   = "geofence_outside"
+	reasonRuleMatched       = "rule"
+	reasonRuleDefault       = "rule_default"
+	reasonASNAllowed        = "asn_allowed"
+	reasonEUMember          = "eu_member"
+	reasonNotEUMember       = "not_eu_member"
+)
+
 var (
 	geoCache = make(map[string]cacheEntry)
 	cacheMux = sync.RWMutex{}
+	// cacheBytes estimates the memory held by geoCache; guarded by cacheMux.
+	cacheBytes int
+	// cacheUsage tracks the use of each geoCache entry for -cache-eviction;
+	// guarded by cacheMux.
+	cacheUsage = make(map[string]*entryUsage)
+	// newKeyLimiter bounds how fast new distinct keys enter geoCache, so
+	// spoofed IPs cannot fill it; nil leaves it unbounded.
+	newKeyLimiter atomic.Pointer[rateLimiter]
+
+	// refreshing holds the keys of stale verdicts being refreshed, so each
+	// is looked up once however many requests hit it; guarded by refreshMux.
+	refreshing = make(map[string]struct{})
+	refreshMux sync.Mutex
 )
 
+// cacheEntryOverhead approximates the fixed cost of a cache entry: the entry
+// struct, the map bucket slot and the string headers.
+const cacheEntryOverhead = 160
+
 func NewAuthHandler(db db.GeoIPSource) *AuthHandler {
 	return &AuthHandler{
 		Db: db,
 	}
 }
 
+// CacheCleanup runs one purge tick and returns the number of evicted
+// entries. With -cache-purge-batch set it evicts at most that many, so the
+// write lock is held briefly and a large cache drains over several ticks.
 func CacheCleanup() int {
 	cacheMux.Lock()
+	defer cacheMux.Unlock()
+	if limit := cachePurgeBatch(); limit > 0 && len(geoCache) > limit {
+		return evictLocked(limit)
+	}
+	return purgeCacheLocked()
+}
+
+// PurgeCache drops every cached verdict, e.g. after the allow-list changed,
+// and returns the number of evicted entries.
+func PurgeCache() int {
+	cacheMux.Lock()
+	defer cacheMux.Unlock()
+	return purgeCacheLocked()
+}
+
+// evictLocked deletes up to limit entries, chosen by the -cache-eviction
+// strategy; the ttl strategy takes them in map order.
+func evictLocked(limit int) int {
+	evicted := 0
+	if strategy := cacheEviction(); strategy != config.CacheEvictionTTL {
+		for evicted < limit && evictOneLocked(strategy) {
+			evicted++
+		}
+		return evicted
+	}
+	for key := range geoCache {
+		if evicted == limit {
+			break
+		}
+		deleteEntryLocked(key)
+		evicted++
+	}
+	return evicted
+}
+
+func purgeCacheLocked() int {
 	evicted := len(geoCache)
 	geoCache = make(map[string]cacheEntry)
-	cacheMux.Unlock()
+	cacheUsage = make(map[string]*entryUsage)
+	cacheBytes = 0
 	return evicted
 }
 
+// cacheAgeSample caps the entries CacheOldestEntryAge inspects. Map iteration
+// starts at a random entry, so larger caches are sampled.
+const cacheAgeSample = 1024
+
+// CacheOldestEntryAge returns the age of the oldest cached verdict, or zero
+// when the cache is empty.
+func CacheOldestEntryAge() time.Duration {
+	cacheMux.RLock()
+	defer cacheMux.RUnlock()
+	var oldest time.Time
+	seen := 0
+	for _, entry := range geoCache {
+		if seen == cacheAgeSample {
+			break
+		}
+		if oldest.IsZero() || entry.storedAt.Before(oldest) {
+			oldest = entry.storedAt
+		}
+		seen++
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// entrySize estimates the bytes a cached verdict holds.
+func entrySize(key string, entry cacheEntry) int {
+	size := cacheEntryOverhead + len(key) + len(entry.country) + len(entry.reason) + len(entry.timeZone) + len(entry.network)
+	for lang, name := range entry.names {
+		size += len(lang) + len(name) + 32
+	}
+	return size
+}
+
+// storeVerdict caches entry under key. When the entry would push the cache
+// past -cache-max-bytes, entries are evicted by the -cache-eviction strategy
+// until it fits; the ttl strategy purges the whole cache instead. A replaced
+// entry keeps its usage.
+func storeVerdict(key string, entry cacheEntry) {
+	size := entrySize(key, entry)
+	cacheMux.Lock()
+	defer cacheMux.Unlock()
+	usage := cacheUsage[key]
+	if _, ok := geoCache[key]; ok {
+		deleteEntryLocked(key)
+	}
+	if limit := cacheMaxBytes(); limit > 0 && cacheBytes+size > limit {
+		var evicted int
+		if strategy := cacheEviction(); strategy == config.CacheEvictionTTL {
+			evicted = purgeCacheLocked()
+		} else {
+			for cacheBytes+size > limit && evictOneLocked(strategy) {
+				evicted++
+			}
+		}
+		metrics.CacheEvictions.Add(float64(evicted))
+		log.Debug().Int("evicted entries", evicted).Int("max_bytes", limit).Msg("Cache over byte budget, evicted")
+	}
+	if usage == nil {
+		usage = &entryUsage{}
+		usage.touch()
+	}
+	geoCache[key] = entry
+	cacheUsage[key] = usage
+	cacheBytes += size
+}
+
+// authOutcome is what serve decided, for the per-request log event.
+type authOutcome struct {
+	ip      net.IP
+	entry   cacheEntry
+	cached  bool
+	decided bool
+}
+
 func (ah *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Debug().Bool("ready", ah.Db.IsReady()).Msg("new auth request")
-	if !ah.Db.IsReady() {
-		http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+	start := time.Now()
+	event := log.Debug()
+	if !event.Enabled() {
+		observeVerdict(ah.serve(w, r), start)
 		return
 	}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	out := ah.serve(rec, r)
+	observeVerdict(out, start)
+	if !logAccess(accessLogMode(), out, rec.status) {
+		return
+	}
+	event = event.
+		Str("ip", ipString(out.ip)).
+		Str("source", ipSource(r)).
+		Int("status", rec.status)
+	if out.decided {
+		event = event.
+			Str("country", out.entry.country).
+			Bool("allowed", out.entry.allowed).
+			Bool("cached", out.cached)
+	}
+	event.Msg("auth request")
+}
 
-	ip := getIPFromRequest(r)
-	log.Debug().Str("ip", ip.String()).Msg("auth request from")
+// logAccess reports whether a request with outcome out and status is logged
+// under mode. A request fails when it ends in an error status without a
+// verdict; a denial is a verdict that did not allow the request.
+func logAccess(mode string, out authOutcome, status int) bool {
+	failed := !out.decided && status >= http.StatusBadRequest
+	switch mode {
+	case config.AccessLogAll:
+		return true
+	case config.AccessLogDeny:
+		return failed || (out.decided && !out.entry.allowed)
+	default:
+		return failed
+	}
+}
+
+// observeVerdict records how long a decided request took since start.
+func observeVerdict(out authOutcome, start time.Time) {
+	if !out.decided {
+		return
+	}
+	cached := "false"
+	if out.cached {
+		cached = "true"
+	}
+	metrics.VerdictDuration.WithLabelValues(cached).Observe(time.Since(start).Seconds())
+}
+
+// serve answers an /auth request. A cache hit takes the cache read lock once
+// and builds its key in a stack buffer, so it does not allocate beyond the
+// response headers.
+func (ah *AuthHandler) serve(w http.ResponseWriter, r *http.Request) (out authOutcome) {
+	if isDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return out
+	}
+	if !requireConfig(w) {
+		return out
+	}
+	if !ah.Db.IsReady() {
+		respondNotReady(w)
+		return out
+	}
+
+	source := ipSource(r)
+	w.Header().Set("X-Resolved-Source", source)
+	var ip net.IP
+	if source == ipSourceQuery {
+		queryIP, err := parseQueryIP(r.URL.Query().Get("ip"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return out
+		}
+		metrics.IPSourceTotal.WithLabelValues(ipSourceQuery).Inc()
+		ip = queryIP
+	} else {
+		ip = getIPFromRequest(r)
+	}
+	out.ip = ip
 	if ip == nil {
 		http.Error(w, "Unable to determine IP", http.StatusBadRequest)
-		return
+		return out
+	}
+
+	entry, cached, err := ah.verdict(cacheNamespace(r), ip)
+	if err != nil {
+		http.Error(w, "GeoIP lookup failed", http.StatusInternalServerError)
+		return out
+	}
+	out.entry, out.cached, out.decided = entry, cached, true
+	recordAudit(ip, entry)
+	if exposeTrace() {
+		w.Header().Set(decisionTraceHeader, decisionTrace(source, entry, cached))
+	}
+	if entry.reason == reasonLAN {
+		respondAllowed(w, entry)
+		metrics.RequestsTotal.WithLabelValues(metrics.CountryLabel(entry.country), "true").Inc()
+		return out
+	}
+
+	if entry.fallback {
+		w.Header().Set("X-Country-Source", "fallback")
+	}
+	entry.countryName = localizedName(entry.names, r.Header.Get("Accept-Language"))
+	entry.compact = compactResponse(r)
+	entry.jsonDeny = acceptsJSON(r)
+	serveVerdict(w, entry)
+	return out
+}
+
+// verdict resolves ip under the cache namespace through the overrides, the
+// cache and a database lookup, caching what the lookup decided. It is shared
+// by /auth and the gRPC Check.
+func (ah *AuthHandler) verdict(namespace string, ip net.IP) (entry cacheEntry, cached bool, err error) {
+	// Overrides bypass the cache so a reload takes effect immediately.
+	if entry, ok := overrideVerdict(ip); ok {
+		return entry, false, nil
 	}
 
+	var buf [64]byte
+	key := appendCacheKey(buf[:0], namespace, ip)
 	cacheMux.RLock()
-	entry, found := geoCache[ip.String()]
+	entry, cached = geoCache[string(key)]
+	usage := cacheUsage[string(key)]
 	cacheMux.RUnlock()
-
-	if found {
-		log.Debug().
-			Str("ip", ip.String()).
-			Str("country", entry.country).
-			Msg("Cache hit for")
+	known := cached
+	if cached {
+		if ttl := entryTTL(entry); ttl > 0 {
+			switch age := time.Since(entry.storedAt); {
+			case age <= ttl:
+			case age <= ttl+cacheStaleGrace():
+				ah.refresh(string(key), ip)
+			default:
+				cached = false
+			}
+		}
+	}
+	if cached {
+		if usage != nil {
+			usage.touch()
+		}
 		metrics.CacheHits.Inc()
-		serveVerdict(w, entry.allowed, entry.country)
+		metrics.VerdictsTotal.WithLabelValues("true").Inc()
+		return entry, true, nil
+	}
+
+	lookupStart := time.Now()
+	entry, err = ah.evaluate(ip)
+	if err != nil {
+		return cacheEntry{}, false, err
+	}
+	metrics.LookupDuration.Observe(time.Since(lookupStart).Seconds())
+	if entry.country == "" {
+		metrics.LookupNotFound.Inc()
+	}
+	metrics.VerdictsTotal.WithLabelValues("false").Inc()
+	if entry.reason != reasonLAN && !entry.fallback && (known || admitCacheKey()) {
+		entry.storedAt = time.Now()
+		storeVerdict(string(key), entry)
+	}
+	return entry, false, nil
+}
+
+// entryTTL returns how long entry stays fresh in the cache. Verdicts for IPs
+// the database has no country for use -cache-ttl-notfound when it is set, so
+// a spread of unknown IPs does not hold cache space as long as real verdicts.
+func entryTTL(entry cacheEntry) time.Duration {
+	if entry.country == "" {
+		if ttl := cacheTTLNotFound(); ttl > 0 {
+			return ttl
+		}
+	}
+	return cacheTTL()
+}
+
+// admitCacheKey reports whether a new key may enter the cache under
+// -cache-new-key-rate. Rejected verdicts are still served, just not cached.
+func admitCacheKey() bool {
+	l := newKeyLimiter.Load()
+	if l == nil {
+		return true
+	}
+	if ok, _ := l.allow(); !ok {
+		metrics.CacheKeysThrottled.Inc()
+		return false
+	}
+	return true
+}
+
+// refresh re-evaluates the stale verdict cached under key in the background.
+// Only one refresh per key runs at a time; the stale verdict keeps being
+// served until it completes.
+func (ah *AuthHandler) refresh(key string, ip net.IP) {
+	refreshMux.Lock()
+	if _, ok := refreshing[key]; ok {
+		refreshMux.Unlock()
 		return
 	}
+	refreshing[key] = struct{}{}
+	refreshMux.Unlock()
+
+	go func() {
+		defer func() {
+			refreshMux.Lock()
+			delete(refreshing, key)
+			refreshMux.Unlock()
+		}()
+		entry, err := ah.evaluate(ip)
+		if err != nil {
+			log.Warn().Err(err).Str("ip", ip.String()).Msg("Background verdict refresh failed")
+			return
+		}
+		if entry.reason != reasonLAN && !entry.fallback {
+			entry.storedAt = time.Now()
+			storeVerdict(key, entry)
+		}
+	}()
+}
+
+// chainCountry returns the first non-empty ISO code among the record's
+// country sources, in chain order. Anycast and VPN ranges often lack a
+// location country but carry a registered one.
+func chainCountry(record *geoRecord, chain []string) string {
+	for _, source := range chain {
+		var code string
+		switch source {
+		case config.CountrySourceLocation:
+			code = record.Country.ISOCode
+		case config.CountrySourceRegistered:
+			code = record.RegisteredCountry.ISOCode
+		case config.CountrySourceRepresented:
+			code = record.RepresentedCountry.ISOCode
+		}
+		if code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// countryVerdict applies the country policy to an upper-case ISO code.
+func countryVerdict(isoCode string) (bool, string) {
+	if config.GetAllowedCodes()[isoCode] {
+		return true, reasonCountryAllowed
+	}
+	return false, reasonCountryNotAllowed
+}
+
+// euVerdict applies -allow-eu-only to the record's EU membership flag.
+func euVerdict(inEU bool) (bool, string) {
+	if inEU {
+		return true, reasonEUMember
+	}
+	return false, reasonNotEUMember
+}
+
+// lookupFieldPath decodes the whole record for ip and returns the country
+// codes at path: one for a string, several for a list of strings, none when
+// the path is missing or holds anything else. It also returns the matched
+// network. It is the slow path for databases that do not follow the MaxMind
+// layout.
+func lookupFieldPath(reader db.ReaderInterface, ip net.IP, path []string) ([]string, *net.IPNet, error) {
+	var raw any
+	network, _, err := reader.LookupNetwork(ip, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, key := range path {
+		m, ok := raw.(map[string]any)
+		if !ok {
+			return nil, network, nil
+		}
+		raw = m[key]
+	}
+	switch v := raw.(type) {
+	case string:
+		return []string{v}, network, nil
+	case []any:
+		var codes []string
+		for _, item := range v {
+			if code, ok := item.(string); ok && code != "" {
+				codes = append(codes, code)
+			}
+		}
+		return codes, network, nil
+	}
+	return nil, network, nil
+}
+
+// resolveCountries looks up ip and returns its upper-case country codes,
+// read at path when it is set and along -country-source-chain from the
+// MaxMind layout, decoded into record, otherwise. It also returns the matched
+// network. There is always at least one code, empty when the record has none.
+func resolveCountries(reader db.ReaderInterface, ip net.IP, path []string, record *geoRecord) ([]string, *net.IPNet, error) {
+	var (
+		codes   []string
+		network *net.IPNet
+		err     error
+	)
+	if len(path) > 0 {
+		codes, network, err = lookupFieldPath(reader, ip, path)
+	} else {
+		network, _, err = reader.LookupNetwork(ip, record)
+		codes = []string{chainCountry(record, countrySourceChain())}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(codes) == 0 {
+		codes = []string{""}
+	}
+	for i, code := range codes {
+		codes[i] = strings.ToUpper(code)
+	}
+	return codes, network, nil
+}
 
+// ResolveCountries returns the country codes /auth resolves for ip from
+// reader under the loaded config, so a startup self-test decodes records
+// exactly as live traffic does.
+func ResolveCountries(reader db.ReaderInterface, ip net.IP) ([]string, error) {
+	var record geoRecord
+	codes, _, err := resolveCountries(reader, ip, countryFieldPath(), &record)
+	return codes, err
+}
+
+// multiCountryVerdict judges a record listing several countries. In "any"
+// mode the first allowed country decides, in "all" mode the first denied
+// one; otherwise the verdict of the first country stands.
+func multiCountryVerdict(codes []string, verdict func(string) (bool, string)) (bool, string) {
+	decisive := multiCountryMode() != config.MultiCountryAll
+	allowed, reason := verdict(codes[0])
+	if allowed == decisive {
+		return allowed, reason
+	}
+	for _, code := range codes[1:] {
+		if a, r := verdict(code); a == decisive {
+			return a, r
+		}
+	}
+	return allowed, reason
+}
+
+// ruleVerdict evaluates the ordered rules against ip and its record.
+func ruleVerdict(rs *config.RuleSet, ip net.IP, isoCode string, record *geoRecord) (bool, string) {
+	subject := config.RuleSubject{
+		IP:        ip,
+		Country:   isoCode,
+		Continent: strings.ToUpper(record.Continent.Code),
+	}
+	for _, sub := range record.Subdivisions {
+		if sub.ISOCode != "" {
+			subject.Subdivisions = append(subject.Subdivisions, isoCode+"-"+strings.ToUpper(sub.ISOCode))
+		}
+	}
+	allowed, index := rs.Evaluate(subject)
+	if index < 0 {
+		return allowed, reasonRuleDefault
+	}
+	return allowed, reasonRuleMatched
+}
+
+// overrideVerdict returns the forced verdict for ip, if one is configured.
+func overrideVerdict(ip net.IP) (cacheEntry, bool) {
+	allowed, ok := ipOverride(ip)
+	if !ok {
+		return cacheEntry{}, false
+	}
+	return cacheEntry{allowed: allowed, country: "OVERRIDE", reason: reasonIPOverride}, true
+}
+
+// evaluate computes the verdict for ip without touching the cache or metrics.
+func (ah *AuthHandler) evaluate(ip net.IP) (cacheEntry, error) {
+	if entry, ok := overrideVerdict(ip); ok {
+		return entry, nil
+	}
 	if isExcluded(ip, config.GetExcludeCIDR()) {
-		log.Debug().Str("ip", ip.String()).Msg("Excluded IP allowed")
-		respondAllowed(w, "LAN")
-		metrics.RequestsTotal.WithLabelValues("LAN", "true").Inc()
-		return
+		return cacheEntry{allowed: true, country: "LAN", reason: reasonLAN}, nil
 	}
 
 	var record geoRecord
-	err := ah.Db.GetReader().Lookup(ip, &record)
+	reader := ah.Db.GetReader()
+	path := countryFieldPath()
+	codes, network, err := resolveCountries(reader, ip, path, &record)
 	if err != nil {
-		http.Error(w, "GeoIP lookup failed", http.StatusInternalServerError)
-		return
+		return cacheEntry{}, err
 	}
 
-	isoCode := strings.ToUpper(record.Country.ISOCode)
-	allowed := config.GetAllowedCodes()[isoCode]
-
-	cacheMux.Lock()
-	geoCache[ip.String()] = cacheEntry{
-		allowed: allowed,
-		country: isoCode,
+	verdict := countryVerdict
+	rs := rules()
+	euOnly := allowEUOnly()
+	switch {
+	case rs != nil:
+		verdict = func(isoCode string) (bool, string) {
+			return ruleVerdict(rs, ip, isoCode, &record)
+		}
+	case euOnly:
+		verdict = func(string) (bool, string) {
+			return euVerdict(record.Country.IsInEuropeanUnion)
+		}
+	}
+	allowed, reason := multiCountryVerdict(codes, verdict)
+	entry := cacheEntry{
+		allowed:  allowed,
+		country:  strings.Join(codes, ","),
+		reason:   reason,
+		timeZone: record.Location.TimeZone,
+		names:    record.Country.Names,
+		fallback: db.IsFallback(reader),
+		inEU:     record.Country.IsInEuropeanUnion,
+		euKnown:  len(path) == 0,
+	}
+	if network != nil {
+		entry.network = network.String()
+	}
+	// Allow-listed ASNs are part of -allow, which a rule set or
+	// -allow-eu-only replaces.
+	if !entry.allowed && rs == nil && !euOnly && asnAllowed(ip) {
+		entry.allowed, entry.reason = true, reasonASNAllowed
 	}
-	cacheMux.Unlock()
-	serveVerdict(w, allowed, isoCode)
+	applyGeofence(&entry, &record)
+	return entry, nil
 }
@@ -0,0 +1,109 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// BatchLookupHandler resolves many IPs per request, spreading the lookups
+	// over a bounded pool of workers. The mmdb reader is safe for concurrent
+	// reads, and results keep the order of the request.
+	BatchLookupHandler struct {
+		auth         *AuthHandler
+		workers      int
+		maxBatchSize int
+	}
+
+	batchLookupRequest struct {
+		IPs []string `json:"ips"`
+	}
+
+	batchLookupResponse struct {
+		Results []lookupResponse `json:"results"`
+	}
+)
+
+func NewBatchLookupHandler(db db.GeoIPSource) *BatchLookupHandler {
+	workers := config.GetBatchWorkers()
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &BatchLookupHandler{
+		auth:         NewAuthHandler(db),
+		workers:      workers,
+		maxBatchSize: config.GetMaxBatchSize(),
+	}
+}
+
+func (bh *BatchLookupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !bh.auth.Db.IsReady() {
+		http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req batchLookupRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if bh.maxBatchSize > 0 && len(req.IPs) > bh.maxBatchSize {
+		metrics.RequestRejectedTotal.WithLabelValues(rejectBatchTooLarge).Inc()
+		http.Error(w, fmt.Sprintf("Batch too large, at most %d IPs allowed", bh.maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	results := bh.lookupAll(req.IPs, r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batchLookupResponse{Results: results})
+}
+
+// lookupAll fans the IPs out to the worker pool. Each worker writes only to
+// its own result index, so the output preserves the input order.
+func (bh *BatchLookupHandler) lookupAll(ips []string, acceptLanguage string) []lookupResponse {
+	results := make([]lookupResponse, len(ips))
+	workers := max(1, min(bh.workers, len(ips)))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = bh.lookupOne(ips[i], acceptLanguage)
+			}
+		}()
+	}
+	for i := range ips {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (bh *BatchLookupHandler) lookupOne(raw, acceptLanguage string) lookupResponse {
+	ip, err := parseQueryIP(raw)
+	if err != nil {
+		return lookupResponse{IP: raw, Error: err.Error()}
+	}
+	entry, err := bh.auth.evaluate(ip)
+	if err != nil {
+		log.Error().Err(err).Str("ip", ip.String()).Msg("batch lookup failed")
+		return lookupResponse{IP: ip.String(), Error: "lookup failed"}
+	}
+	return newLookupResponse(ip, entry, acceptLanguage)
+}
@@ -0,0 +1,143 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestBatchLookupHandler_PreservesOrder(t *testing.T) {
+	defer resetGlobals()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+
+	// Derive the country from the last octet so every result is checkable.
+	countries := []string{"US", "DE", "FR", "RU", "JP"}
+	source := &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+		record.(*geoRecord).Country.ISOCode = countries[int(ip.To4()[3])%len(countries)]
+		return nil
+	}}
+
+	const size = 2000
+	ips := make([]string, size)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)
+	}
+	ips[7] = "not-an-ip"
+	body, _ := json.Marshal(batchLookupRequest{IPs: ips})
+
+	handler := NewBatchLookupHandler(source)
+	handler.workers = 8
+	handler.maxBatchSize = 0
+	req := httptest.NewRequest("POST", "/lookup/batch", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp batchLookupResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Results) != size {
+		t.Fatalf("Expected %d results, got %d", size, len(resp.Results))
+	}
+	for i, res := range resp.Results {
+		if i == 7 {
+			if res.IP != "not-an-ip" || res.Error == "" {
+				t.Errorf("Expected an error entry for the invalid IP, got %+v", res)
+			}
+			continue
+		}
+		if res.IP != ips[i] {
+			t.Fatalf("result %d out of order: expected IP %s, got %s", i, ips[i], res.IP)
+		}
+		if expected := countries[(i%256)%len(countries)]; res.Country != expected || res.Error != "" {
+			t.Errorf("result %d: expected country %s, got %+v", i, expected, res)
+		}
+	}
+}
+
+func TestBatchLookupHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		source         *mockGeoIPSource
+		method         string
+		body           string
+		maxBatchSize   int
+		maxBody        int64
+		expectedStatus int
+		// rejected is the request_rejected_total reason expected, if any.
+		rejected string
+	}{
+		{
+			name:           "Wrong method",
+			source:         &mockGeoIPSource{ready: true},
+			method:         "GET",
+			expectedStatus: http.StatusMethodNotAllowed,
+		}, {
+			name:           "DB not ready",
+			source:         &mockGeoIPSource{ready: false},
+			body:           `{"ips":["1.2.3.4"]}`,
+			expectedStatus: http.StatusServiceUnavailable,
+		}, {
+			name:           "Invalid JSON",
+			source:         &mockGeoIPSource{ready: true},
+			body:           `{"ips":`,
+			expectedStatus: http.StatusBadRequest,
+			rejected:       rejectBadJSON,
+		}, {
+			name:           "Batch too large",
+			source:         &mockGeoIPSource{ready: true},
+			body:           `{"ips":["1.2.3.4","5.6.7.8"]}`,
+			maxBatchSize:   1,
+			expectedStatus: http.StatusBadRequest,
+			rejected:       rejectBatchTooLarge,
+		}, {
+			name:           "Body too large",
+			source:         &mockGeoIPSource{ready: true},
+			body:           `{"ips":["` + strings.Repeat("1.2.3.4", 20) + `"]}`,
+			maxBody:        64,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			rejected:       rejectBodyTooLarge,
+		}, {
+			name:           "Body within limit",
+			source:         &mockGeoIPSource{ready: true},
+			body:           `{"ips":["not-an-ip"]}`,
+			maxBody:        64,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "POST"
+			}
+			metrics.Reset()
+			handler := NewBatchLookupHandler(tc.source)
+			handler.maxBatchSize = tc.maxBatchSize
+			req := httptest.NewRequest(method, "/lookup/batch", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			limitBody(tc.maxBody, handler).ServeHTTP(w, req)
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if tc.rejected != "" {
+				if got := testutil.ToFloat64(metrics.RequestRejectedTotal.WithLabelValues(tc.rejected)); got != 1 {
+					t.Errorf("Expected request_rejected_total{reason=%q} to be 1, got %v", tc.rejected, got)
+				}
+			}
+			if got, want := testutil.CollectAndCount(metrics.RequestRejectedTotal), len(tc.rejected) > 0; (got == 1) != want {
+				t.Errorf("Expected a rejection series only for rejected requests, got %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+// Reasons of the request_rejected_total metric.
+const (
+	rejectBodyTooLarge  = "body_too_large"
+	rejectBatchTooLarge = "batch_too_large"
+	rejectBadJSON       = "bad_json"
+)
+
+// limitBody caps every request body read by next at maxBytes, so no endpoint
+// can be made to buffer an unbounded body. 0 disables the cap.
+func limitBody(maxBytes int64, next http.Handler) http.Handler {
+	if maxBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSONBody decodes the request body into v. On failure it answers 413
+// when the body exceeded the limit and 400 otherwise, and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		metrics.RequestRejectedTotal.WithLabelValues(rejectBodyTooLarge).Inc()
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	metrics.RequestRejectedTotal.WithLabelValues(rejectBadJSON).Inc()
+	http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+	return false
+}
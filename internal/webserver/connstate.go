@@ -0,0 +1,20 @@
+package webserver
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+// trackConnState is an http.Server ConnState hook counting accepted and open
+// connections, which reveals keep-alive churn from the upstream proxy.
+func trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		metrics.HTTPNewConnectionsTotal.Inc()
+		metrics.HTTPActiveConnections.Inc()
+	case http.StateHijacked, http.StateClosed:
+		metrics.HTTPActiveConnections.Dec()
+	}
+}
@@ -0,0 +1,52 @@
+package webserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestTrackConnState(t *testing.T) {
+	metrics.InitMetrics()
+	activeBefore := testutil.ToFloat64(metrics.HTTPActiveConnections)
+	newBefore := testutil.ToFloat64(metrics.HTTPNewConnectionsTotal)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = trackConnState
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	// Two requests over one keep-alive connection count a single connection.
+	for range 2 {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if got := testutil.ToFloat64(metrics.HTTPNewConnectionsTotal) - newBefore; got != 1 {
+		t.Errorf("Expected 1 new connection, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HTTPActiveConnections) - activeBefore; got != 1 {
+		t.Errorf("Expected 1 active connection, got %v", got)
+	}
+
+	client.CloseIdleConnections()
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(metrics.HTTPActiveConnections) != activeBefore {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the closed connection to leave the active gauge, got %v",
+				testutil.ToFloat64(metrics.HTTPActiveConnections)-activeBefore)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// mmdbContentType is what MaxMind serves database downloads as.
+const mmdbContentType = "application/octet-stream"
+
+// DBDownloadHandler streams the currently installed database, so operators
+// can snapshot exactly what is serving.
+type DBDownloadHandler struct {
+	source db.GeoIPSource
+}
+
+func NewDBDownloadHandler(source db.GeoIPSource) *DBDownloadHandler {
+	return &DBDownloadHandler{
+		source: source,
+	}
+}
+
+func (dh *DBDownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snapshotter, ok := dh.source.(db.Snapshotter)
+	if !ok {
+		http.Error(w, "DB source cannot be downloaded", http.StatusNotImplemented)
+		return
+	}
+
+	body, size, err := snapshotter.Snapshot()
+	if errors.Is(err, db.ErrNoDatabase) {
+		http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to snapshot database")
+		http.Error(w, "Failed to read database", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", mmdbContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", `attachment; filename="geoip.mmdb"`)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Error().Err(err).Msg("failed to stream database")
+	}
+}
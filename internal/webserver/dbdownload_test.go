@@ -0,0 +1,98 @@
+package webserver
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+// snapshotSource is a mock source that can be downloaded.
+type snapshotSource struct {
+	mockGeoIPSource
+	data []byte
+	err  error
+}
+
+func (s *snapshotSource) Snapshot() (io.ReadCloser, int64, error) {
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+	return io.NopCloser(bytes.NewReader(s.data)), int64(len(s.data)), nil
+}
+
+func TestDBDownloadHandler(t *testing.T) {
+	metrics.InitMetrics()
+	writer, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-Country", IncludeReservedNetworks: true})
+	if err != nil {
+		t.Fatalf("failed to create mmdb writer: %v", err)
+	}
+	_, network, _ := net.ParseCIDR("1.2.3.0/24")
+	writer.Insert(network, mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")}})
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create db file: %v", err)
+	}
+	writer.WriteTo(f)
+	f.Close()
+
+	loader := db.NewDiskLoader(path)
+	if err := loader.Start(); err != nil {
+		t.Fatalf("failed to load db: %v", err)
+	}
+	defer loader.Stop()
+
+	w := httptest.NewRecorder()
+	newAdminMux(loader).ServeHTTP(w, httptest.NewRequest("GET", "/db/download", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != mmdbContentType {
+		t.Errorf("Expected content type %q, got %q", mmdbContentType, ct)
+	}
+
+	reader, err := maxminddb.FromBytes(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Expected the download to open as a database: %v", err)
+	}
+	defer reader.Close()
+	var record geoRecord
+	if err := reader.Lookup(net.ParseIP("1.2.3.4"), &record); err != nil || record.Country.ISOCode != "US" {
+		t.Errorf("Expected US from the downloaded database, got %q (%v)", record.Country.ISOCode, err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		source         db.GeoIPSource
+		expectedStatus int
+	}{
+		{name: "Not ready", source: &snapshotSource{err: db.ErrNoDatabase}, expectedStatus: http.StatusServiceUnavailable},
+		{name: "Not downloadable", source: &mockGeoIPSource{ready: true}, expectedStatus: http.StatusNotImplemented},
+		{name: "Wrong method", method: http.MethodPost, source: loader, expectedStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			newAdminMux(tc.source).ServeHTTP(w, httptest.NewRequest(method, "/db/download", nil))
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// DebugLookupHandler returns the entire decoded database record for an
+	// IP, to diagnose schema and field-path differences between editions.
+	DebugLookupHandler struct {
+		Db db.GeoIPSource
+	}
+
+	debugLookupResponse struct {
+		IP     string         `json:"ip"`
+		Record map[string]any `json:"record"`
+	}
+)
+
+func NewDebugLookupHandler(db db.GeoIPSource) *DebugLookupHandler {
+	return &DebugLookupHandler{
+		Db: db,
+	}
+}
+
+func (dh *DebugLookupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !dh.Db.IsReady() {
+		http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip, err := parseQueryIP(r.URL.Query().Get("ip"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var record map[string]any
+	if err := dh.Db.GetReader().Lookup(ip, &record); err != nil {
+		log.Error().Err(err).Str("ip", ip.String()).Msg("debug lookup failed")
+		http.Error(w, "GeoIP lookup failed", http.StatusInternalServerError)
+		return
+	}
+	if len(record) == 0 {
+		http.Error(w, "No record found for ip", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(debugLookupResponse{
+		IP:     ip.String(),
+		Record: record,
+	})
+}
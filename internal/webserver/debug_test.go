@@ -0,0 +1,78 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestDebugLookupHandler(t *testing.T) {
+	reader := newTestReader(t, "GeoLite2-City", map[string]mmdbtype.Map{
+		"1.2.3.0/24": {
+			"country":  mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+			"location": mmdbtype.Map{"time_zone": mmdbtype.String("America/Chicago")},
+			"traits":   mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+		},
+	})
+	source := &mockGeoIPSource{ready: true, lookup: reader.Lookup}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		source         *mockGeoIPSource
+		expectedStatus int
+	}{
+		{name: "Record found", url: "/debug/lookup?ip=1.2.3.4", source: source, expectedStatus: http.StatusOK},
+		{name: "No record", url: "/debug/lookup?ip=5.6.7.8", source: source, expectedStatus: http.StatusNotFound},
+		{name: "Invalid ip", url: "/debug/lookup?ip=nope", source: source, expectedStatus: http.StatusBadRequest},
+		{name: "Missing ip", url: "/debug/lookup", source: source, expectedStatus: http.StatusBadRequest},
+		{name: "Wrong method", method: http.MethodPost, url: "/debug/lookup?ip=1.2.3.4", source: source, expectedStatus: http.StatusMethodNotAllowed},
+		{name: "DB not ready", url: "/debug/lookup?ip=1.2.3.4", source: &mockGeoIPSource{ready: false}, expectedStatus: http.StatusServiceUnavailable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			newAdminMux(tc.source).ServeHTTP(w, httptest.NewRequest(method, tc.url, nil))
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	newAdminMux(source).ServeHTTP(w, httptest.NewRequest("GET", "/debug/lookup?ip=1.2.3.4", nil))
+	if !strings.Contains(w.Body.String(), "\n  ") {
+		t.Errorf("Expected pretty-printed JSON, got %s", w.Body.String())
+	}
+	var got debugLookupResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.IP != "1.2.3.4" {
+		t.Errorf("Expected ip 1.2.3.4, got %q", got.IP)
+	}
+	location, _ := got.Record["location"].(map[string]any)
+	if location["time_zone"] != "America/Chicago" {
+		t.Errorf("Expected the raw location in the record, got %v", got.Record)
+	}
+	traits, _ := got.Record["traits"].(map[string]any)
+	if traits["is_anycast"] != true {
+		t.Errorf("Expected fields beyond the country in the record, got %v", got.Record)
+	}
+
+	// The endpoint must not be reachable from the public listener.
+	w = httptest.NewRecorder()
+	newMux(source, nil).ServeHTTP(w, httptest.NewRequest("GET", "/debug/lookup?ip=1.2.3.4", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /debug/lookup to be absent from the public mux, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,17 @@
+package webserver
+
+import "sync/atomic"
+
+// draining is set during shutdown so upstreams reroute before the listener
+// closes: /auth answers 503 and /healthz reports draining.
+var draining atomic.Bool
+
+// Drain flips the server into drain mode. It cannot be undone; the process is
+// expected to shut down after the drain period.
+func Drain() {
+	draining.Store(true)
+}
+
+func isDraining() bool {
+	return draining.Load()
+}
@@ -0,0 +1,31 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDrain(t *testing.T) {
+	defer resetGlobals()
+	mux := newMux(&mockGeoIPSource{ready: true}, nil)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected /healthz to be OK before draining, got %d", w.Code)
+	}
+
+	Drain()
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/auth", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /auth to answer 503 while draining, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "draining\n" {
+		t.Errorf("Expected /healthz to report draining, got %d %q", w.Code, w.Body.String())
+	}
+}
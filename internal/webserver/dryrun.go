@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// DryRunHandler evaluates the /auth policy for an explicit IP without
+	// caching the verdict or recording it in the request metrics.
+	DryRunHandler struct {
+		auth *AuthHandler
+	}
+
+	dryRunResponse struct {
+		IP      string `json:"ip"`
+		Country string `json:"country"`
+		Allowed bool   `json:"allowed"`
+		Reason  string `json:"reason"`
+	}
+)
+
+func NewDryRunHandler(db db.GeoIPSource) *DryRunHandler {
+	return &DryRunHandler{
+		auth: NewAuthHandler(db),
+	}
+}
+
+func (dh *DryRunHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !dh.auth.Db.IsReady() {
+		http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip, err := parseQueryIP(r.URL.Query().Get("ip"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := dh.auth.evaluate(ip)
+	if err != nil {
+		log.Error().Err(err).Str("ip", ip.String()).Msg("dry-run lookup failed")
+		http.Error(w, "GeoIP lookup failed", http.StatusInternalServerError)
+		return
+	}
+	log.Debug().
+		Str("ip", ip.String()).
+		Str("country", entry.country).
+		Bool("allowed", entry.allowed).
+		Msg("dry-run verdict")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dryRunResponse{
+		IP:      ip.String(),
+		Country: entry.country,
+		Allowed: entry.allowed,
+		Reason:  entry.reason,
+	})
+}
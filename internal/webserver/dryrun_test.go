@@ -0,0 +1,143 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestDryRunHandler(t *testing.T) {
+	metrics.InitMetrics()
+	defer resetGlobals()
+
+	ruLookup := func(ip net.IP, record any) error {
+		rec := record.(*geoRecord)
+		rec.Country.ISOCode = "ru"
+		return nil
+	}
+
+	tests := []struct {
+		name           string
+		source         *mockGeoIPSource
+		method         string
+		url            string
+		isExcludedFunc func(ip net.IP, excluded []*net.IPNet) bool
+		expectedStatus int
+		expected       *dryRunResponse
+	}{
+		{
+			name:           "Denied country",
+			source:         &mockGeoIPSource{ready: true, lookup: ruLookup},
+			url:            "/auth/dryrun?ip=2.3.4.5",
+			isExcludedFunc: func(ip net.IP, excluded []*net.IPNet) bool { return false },
+			expectedStatus: http.StatusOK,
+			expected:       &dryRunResponse{IP: "2.3.4.5", Country: "RU", Allowed: false, Reason: reasonCountryNotAllowed},
+		}, {
+			name:           "Excluded IP",
+			source:         &mockGeoIPSource{ready: true, lookup: ruLookup},
+			url:            "/auth/dryrun?ip=10.0.0.1",
+			isExcludedFunc: func(ip net.IP, excluded []*net.IPNet) bool { return true },
+			expectedStatus: http.StatusOK,
+			expected:       &dryRunResponse{IP: "10.0.0.1", Country: "LAN", Allowed: true, Reason: reasonLAN},
+		}, {
+			name:           "Missing ip",
+			source:         &mockGeoIPSource{ready: true, lookup: ruLookup},
+			url:            "/auth/dryrun",
+			isExcludedFunc: origIsExcluded,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Invalid ip",
+			source:         &mockGeoIPSource{ready: true, lookup: ruLookup},
+			url:            "/auth/dryrun?ip=not-an-ip",
+			isExcludedFunc: origIsExcluded,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "DB not ready",
+			source:         &mockGeoIPSource{ready: false},
+			url:            "/auth/dryrun?ip=2.3.4.5",
+			isExcludedFunc: origIsExcluded,
+			expectedStatus: http.StatusServiceUnavailable,
+		}, {
+			name: "Lookup error",
+			source: &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+				return errors.New("fail")
+			}},
+			url:            "/auth/dryrun?ip=2.3.4.5",
+			isExcludedFunc: func(ip net.IP, excluded []*net.IPNet) bool { return false },
+			expectedStatus: http.StatusInternalServerError,
+		}, {
+			name:           "Wrong method",
+			source:         &mockGeoIPSource{ready: true, lookup: ruLookup},
+			method:         http.MethodPost,
+			url:            "/auth/dryrun?ip=2.3.4.5",
+			isExcludedFunc: origIsExcluded,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			CacheCleanup()
+			isExcluded = tc.isExcludedFunc
+			deniedBefore := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("RU", "false"))
+			lanBefore := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("LAN", "true"))
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.url, nil)
+			w := httptest.NewRecorder()
+			NewDryRunHandler(tc.source).ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if tc.expected != nil {
+				var got dryRunResponse
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if got != *tc.expected {
+					t.Errorf("Expected %+v, got %+v", *tc.expected, got)
+				}
+			}
+
+			cacheMux.RLock()
+			cached := len(geoCache)
+			cacheMux.RUnlock()
+			if cached != 0 {
+				t.Errorf("Expected no cache entries after dry-run, got %d", cached)
+			}
+			if after := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("RU", "false")); after != deniedBefore {
+				t.Errorf("Expected RequestsTotal{RU,false} to stay %v, got %v", deniedBefore, after)
+			}
+			if after := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("LAN", "true")); after != lanBefore {
+				t.Errorf("Expected RequestsTotal{LAN,true} to stay %v, got %v", lanBefore, after)
+			}
+		})
+	}
+}
+
+func TestAdminMux(t *testing.T) {
+	mux := newAdminMux(&mockGeoIPSource{ready: false})
+
+	req := httptest.NewRequest("GET", "/auth/dryrun?ip=1.2.3.4", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected dry-run to be routed on the admin mux, got status %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/auth", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /auth to be absent from the admin mux, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package webserver
+
+import (
+	"sync/atomic"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+)
+
+// evictionSample is how many entries an LRU or LFU eviction compares. Like
+// Redis, both policies are approximated over a sample so an eviction never
+// scans the whole cache; caches up to this size are ranked exactly.
+const evictionSample = 16
+
+// entryUsage records how a cached verdict is used, for -cache-eviction. Cache
+// hits only hold the cache read lock, so it is updated with atomics.
+type entryUsage struct {
+	lastUsed atomic.Uint64
+	hits     atomic.Uint64
+}
+
+// usageClock orders accesses for LRU. A counter rather than the time keeps
+// the order exact and touch cheap.
+var usageClock atomic.Uint64
+
+// unusedEntry stands in for entries without usage, e.g. ones a test put in
+// geoCache directly; they rank below every used entry.
+var unusedEntry entryUsage
+
+func (u *entryUsage) touch() {
+	u.lastUsed.Store(usageClock.Add(1))
+	u.hits.Add(1)
+}
+
+// evictBefore reports whether the entry used as a goes before the one used
+// as b under strategy. LFU breaks ties between equally hit entries by
+// recency.
+func evictBefore(strategy string, a, b *entryUsage) bool {
+	if strategy == config.CacheEvictionLFU {
+		if aHits, bHits := a.hits.Load(), b.hits.Load(); aHits != bHits {
+			return aHits < bHits
+		}
+	}
+	return a.lastUsed.Load() < b.lastUsed.Load()
+}
+
+// evictOneLocked deletes the sampled entry strategy ranks first and reports
+// whether there was one.
+func evictOneLocked(strategy string) bool {
+	var (
+		victim      string
+		victimUsage *entryUsage
+		seen        int
+	)
+	for key := range geoCache {
+		if seen == evictionSample {
+			break
+		}
+		seen++
+		usage := cacheUsage[key]
+		if usage == nil {
+			usage = &unusedEntry
+		}
+		if victimUsage == nil || evictBefore(strategy, usage, victimUsage) {
+			victim, victimUsage = key, usage
+		}
+	}
+	if victimUsage == nil {
+		return false
+	}
+	deleteEntryLocked(victim)
+	return true
+}
+
+// deleteEntryLocked removes key from the cache and its size estimate.
+func deleteEntryLocked(key string) {
+	cacheBytes -= entrySize(key, geoCache[key])
+	delete(geoCache, key)
+	delete(cacheUsage, key)
+}
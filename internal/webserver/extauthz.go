@@ -0,0 +1,94 @@
+//go:build grpc
+
+package webserver
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rs/zerolog/log"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authzServer implements Envoy's envoy.service.auth.v3.Authorization, so the
+// service can be dropped in as an ext_authz filter.
+type authzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	auth *AuthHandler
+}
+
+func (as *authzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	if err := grpcUnavailable(as.auth); err != nil {
+		return deniedResponse(status.Code(err), typev3.StatusCode_ServiceUnavailable, ""), nil
+	}
+
+	attrs := req.GetAttributes()
+	httpReq := attrs.GetRequest().GetHttp()
+	ip := authzClientIP(attrs)
+	if ip == nil {
+		return deniedResponse(codes.InvalidArgument, typev3.StatusCode_BadRequest, ""), nil
+	}
+
+	// Mirrors cacheNamespace for the request Envoy is authorizing.
+	namespace := config.GetCacheNamespace()
+	if host := httpReq.GetHost(); config.GetCacheNamespaceByHost() && host != "" {
+		namespace = strings.ToLower(host)
+	}
+	entry, _, err := as.auth.verdict(namespace, ip)
+	if err != nil {
+		log.Error().Err(err).Str("ip", ip.String()).Msg("ext_authz lookup failed")
+		return deniedResponse(codes.Internal, typev3.StatusCode_InternalServerError, ""), nil
+	}
+
+	if !grantVerdict(ip, entry) {
+		return deniedResponse(codes.PermissionDenied, typev3.StatusCode_Forbidden, entry.country), nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{Headers: countryHeader(entry.country)},
+		},
+	}, nil
+}
+
+// authzClientIP mirrors getIPFromRequest: the first address of the
+// -ip-header request header, else the downstream source address.
+func authzClientIP(attrs *authv3.AttributeContext) net.IP {
+	// Envoy lower-cases the header keys it forwards.
+	headers := attrs.GetRequest().GetHttp().GetHeaders()
+	if hdr := headers[strings.ToLower(config.GetIpHeader())]; hdr != "" {
+		first, _, _ := strings.Cut(hdr, ",")
+		return parseIP(strings.TrimSpace(first))
+	}
+	return parseIP(attrs.GetSource().GetAddress().GetSocketAddress().GetAddress())
+}
+
+func deniedResponse(code codes.Code, httpStatus typev3.StatusCode, country string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: httpStatus},
+				Headers: countryHeader(country),
+			},
+		},
+	}
+}
+
+// countryHeader sets X-Country, overwriting any value the client sent.
+func countryHeader(country string) []*corev3.HeaderValueOption {
+	if country == "" {
+		return nil
+	}
+	return []*corev3.HeaderValueOption{{
+		Header:       &corev3.HeaderValue{Key: "X-Country", Value: country},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}}
+}
@@ -0,0 +1,135 @@
+//go:build grpc
+
+package webserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"google.golang.org/grpc/codes"
+)
+
+func authzRequest(source string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Source: &authv3.AttributeContext_Peer{Address: &corev3.Address{
+			Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{Address: source}},
+		}},
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Host:    "app.example.com",
+			Headers: headers,
+		}},
+	}}
+}
+
+func TestAuthzServer_Check(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	metrics.InitMetrics()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+	rules = func() *config.RuleSet {
+		return &config.RuleSet{
+			Rules:   []config.Rule{{Type: config.RuleTypeCountry, Match: "US", Action: config.RuleActionAllow}},
+			Default: config.RuleActionDeny,
+		}
+	}
+	source := &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+		if ip.String() == "8.8.8.8" {
+			record.(*geoRecord).Country.ISOCode = "US"
+		} else {
+			record.(*geoRecord).Country.ISOCode = "RU"
+		}
+		return nil
+	}}
+	as := &authzServer{auth: NewAuthHandler(source)}
+	publisher := &fakePublisher{}
+	l := newAuditLog(publisher, "verdicts", 8, config.AuditPolicyDrop)
+	audit.Store(l)
+
+	tests := []struct {
+		name            string
+		req             *authv3.CheckRequest
+		expectedCode    codes.Code
+		expectedStatus  typev3.StatusCode
+		expectedCountry string
+	}{
+		{
+			name:            "Allowed source address",
+			req:             authzRequest("8.8.8.8", nil),
+			expectedCountry: "US",
+		},
+		{
+			name:            "Denied source address",
+			req:             authzRequest("2.3.4.5", nil),
+			expectedCode:    codes.PermissionDenied,
+			expectedStatus:  typev3.StatusCode_Forbidden,
+			expectedCountry: "RU",
+		},
+		{
+			name:            "Allowed via XFF",
+			req:             authzRequest("10.0.0.1", map[string]string{"x-forwarded-for": "8.8.8.8, 10.0.0.1"}),
+			expectedCountry: "US",
+		},
+		{
+			name:            "Denied via XFF",
+			req:             authzRequest("8.8.8.8", map[string]string{"x-forwarded-for": "2.3.4.5"}),
+			expectedCode:    codes.PermissionDenied,
+			expectedStatus:  typev3.StatusCode_Forbidden,
+			expectedCountry: "RU",
+		},
+		{
+			name:           "No client IP",
+			req:            &authv3.CheckRequest{},
+			expectedCode:   codes.InvalidArgument,
+			expectedStatus: typev3.StatusCode_BadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := as.Check(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if code := codes.Code(resp.GetStatus().GetCode()); code != tc.expectedCode {
+				t.Fatalf("Expected code %v, got %v", tc.expectedCode, code)
+			}
+			headers := resp.GetOkResponse().GetHeaders()
+			if tc.expectedCode != codes.OK {
+				denied := resp.GetDeniedResponse()
+				if denied == nil {
+					t.Fatal("Expected a denied response")
+				}
+				if got := denied.GetStatus().GetCode(); got != tc.expectedStatus {
+					t.Errorf("Expected HTTP status %v, got %v", tc.expectedStatus, got)
+				}
+				headers = denied.GetHeaders()
+			} else if resp.GetOkResponse() == nil {
+				t.Fatal("Expected an OK response")
+			}
+			var country string
+			for _, h := range headers {
+				if h.GetHeader().GetKey() == "X-Country" {
+					country = h.GetHeader().GetValue()
+				}
+			}
+			if country != tc.expectedCountry {
+				t.Errorf("Expected X-Country %q, got %q", tc.expectedCountry, country)
+			}
+		})
+	}
+
+	// Every check that reached a verdict is audited.
+	l.stop()
+	if len(publisher.payloads) != 4 {
+		t.Errorf("Expected 4 audited ext_authz verdicts, got %d", len(publisher.payloads))
+	}
+}
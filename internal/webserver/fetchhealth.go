@@ -0,0 +1,48 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+)
+
+type (
+	// FetchHealthHandler reports whether the remote source's recent fetches
+	// have been succeeding, separately from /ready, so alerting can tell
+	// "serving stale data" from "no data at all".
+	FetchHealthHandler struct {
+		source db.GeoIPSource
+	}
+
+	fetchHealthResponse struct {
+		Healthy bool `json:"healthy"`
+		// Reader is whether a real (not fallback) database is loaded.
+		Reader bool `json:"reader"`
+	}
+)
+
+func NewFetchHealthHandler(source db.GeoIPSource) *FetchHealthHandler {
+	return &FetchHealthHandler{
+		source: source,
+	}
+}
+
+func (fh *FetchHealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := fh.source.(db.FetchHealthReporter)
+	if !ok {
+		http.Error(w, "DB source does not fetch", http.StatusNotImplemented)
+		return
+	}
+	reader := fh.source.GetReader()
+	resp := fetchHealthResponse{
+		Healthy: reporter.FetchHealthy(),
+		Reader:  reader != nil && !db.IsFallback(reader),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,81 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+)
+
+type mockFetchHealthSource struct {
+	mockGeoIPSource
+	healthy  bool
+	noReader bool
+}
+
+func (m *mockFetchHealthSource) FetchHealthy() bool {
+	return m.healthy
+}
+
+func (m *mockFetchHealthSource) GetReader() db.ReaderInterface {
+	if m.noReader {
+		return nil
+	}
+	return m.mockGeoIPSource.GetReader()
+}
+
+func TestFetchHealthHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		source         db.GeoIPSource
+		expectedStatus int
+		expected       fetchHealthResponse
+	}{
+		{
+			name:           "Reader present, fetch healthy",
+			source:         &mockFetchHealthSource{healthy: true},
+			expectedStatus: http.StatusOK,
+			expected:       fetchHealthResponse{Healthy: true, Reader: true},
+		}, {
+			name:           "Reader present, fetch unhealthy",
+			source:         &mockFetchHealthSource{},
+			expectedStatus: http.StatusServiceUnavailable,
+			expected:       fetchHealthResponse{Reader: true},
+		}, {
+			name:           "Reader absent, fetch healthy",
+			source:         &mockFetchHealthSource{healthy: true, noReader: true},
+			expectedStatus: http.StatusOK,
+			expected:       fetchHealthResponse{Healthy: true},
+		}, {
+			name:           "Reader absent, fetch unhealthy",
+			source:         &mockFetchHealthSource{noReader: true},
+			expectedStatus: http.StatusServiceUnavailable,
+			expected:       fetchHealthResponse{},
+		}, {
+			name:           "Source does not fetch",
+			source:         &mockGeoIPSource{ready: true},
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			NewFetchHealthHandler(tc.source).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health/fetch", nil))
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if rr.Code == http.StatusNotImplemented {
+				return
+			}
+			var got fetchHealthResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// FetchIntervalHandler changes how often a scheduled source refreshes
+	// its database, without a restart.
+	FetchIntervalHandler struct {
+		source db.GeoIPSource
+	}
+
+	fetchIntervalRequest struct {
+		Interval string `json:"interval"`
+	}
+
+	fetchIntervalResponse struct {
+		Interval string `json:"interval"`
+	}
+)
+
+func NewFetchIntervalHandler(source db.GeoIPSource) *FetchIntervalHandler {
+	return &FetchIntervalHandler{
+		source: source,
+	}
+}
+
+func (fh *FetchIntervalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	setter, ok := fh.source.(db.IntervalSetter)
+	if !ok {
+		http.Error(w, "DB source has no fetch interval", http.StatusNotImplemented)
+		return
+	}
+
+	var req fetchIntervalRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil || interval <= 0 {
+		http.Error(w, "Interval must be a positive duration", http.StatusBadRequest)
+		return
+	}
+	if err := setter.SetInterval(interval); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info().Dur("interval", interval).Msg("fetch interval updated via admin endpoint")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fetchIntervalResponse{Interval: interval.String()})
+}
@@ -0,0 +1,100 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockIntervalSource struct {
+	mockGeoIPSource
+	interval time.Duration
+	err      error
+}
+
+func (m *mockIntervalSource) SetInterval(interval time.Duration) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.interval = interval
+	return nil
+}
+
+func TestFetchIntervalHandler(t *testing.T) {
+	tests := []struct {
+		name             string
+		source           *mockIntervalSource
+		method           string
+		body             string
+		expectedStatus   int
+		expectedInterval time.Duration
+	}{
+		{
+			name:             "Valid interval",
+			source:           &mockIntervalSource{},
+			body:             `{"interval":"12h"}`,
+			expectedStatus:   http.StatusOK,
+			expectedInterval: 12 * time.Hour,
+		}, {
+			name:           "Zero interval",
+			source:         &mockIntervalSource{},
+			body:           `{"interval":"0s"}`,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Negative interval",
+			source:         &mockIntervalSource{},
+			body:           `{"interval":"-1h"}`,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Unparsable interval",
+			source:         &mockIntervalSource{},
+			body:           `{"interval":"soon"}`,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Invalid JSON",
+			source:         &mockIntervalSource{},
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Source rejects interval",
+			source:         &mockIntervalSource{err: errors.New("nope")},
+			body:           `{"interval":"1h"}`,
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Wrong method",
+			source:         &mockIntervalSource{},
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/admin/fetch-interval", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			newAdminMux(tc.source).ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if tc.source.interval != tc.expectedInterval {
+				t.Errorf("Expected interval %v, got %v", tc.expectedInterval, tc.source.interval)
+			}
+		})
+	}
+
+	t.Run("Source without interval", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/fetch-interval", strings.NewReader(`{"interval":"1h"}`))
+		w := httptest.NewRecorder()
+		newAdminMux(&mockGeoIPSource{ready: true}).ServeHTTP(w, req)
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+		}
+	})
+}
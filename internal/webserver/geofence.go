@@ -0,0 +1,47 @@
+package webserver
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+)
+
+// geofenceDistanceHeader carries the distance from the geofence centre, in
+// kilometres, for debugging geofenced verdicts.
+const geofenceDistanceHeader = "X-Geofence-Distance-Km"
+
+// earthRadiusKm is the mean radius of the earth used by haversineKm.
+const earthRadiusKm = 6371.0
+
+var geofence = config.GetGeofence
+
+// haversineKm returns the great-circle distance in kilometres between two
+// points given in degrees.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// applyGeofence denies entry when a geofence is configured and the record is
+// located outside it. Inside the fence, and for records without coordinates,
+// the verdict already reached stands.
+func applyGeofence(entry *cacheEntry, record *geoRecord) {
+	fence := geofence()
+	if fence == nil || record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return
+	}
+	entry.distanceKm = haversineKm(fence.Lat, fence.Lon, *record.Location.Latitude, *record.Location.Longitude)
+	entry.geofenced = true
+	if entry.distanceKm > fence.RadiusKm {
+		entry.allowed, entry.reason = false, reasonGeofenceOutside
+	}
+}
+
+func formatDistanceKm(km float64) string {
+	return strconv.FormatFloat(km, 'f', 1, 64)
+}
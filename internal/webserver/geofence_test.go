@@ -0,0 +1,115 @@
+package webserver
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestHaversineKm(t *testing.T) {
+	// Berlin to Munich is roughly 504 km.
+	got := haversineKm(52.52, 13.405, 48.137, 11.575)
+	if math.Abs(got-504) > 5 {
+		t.Errorf("Expected about 504 km, got %v", got)
+	}
+	if d := haversineKm(10, 20, 10, 20); d != 0 {
+		t.Errorf("Expected zero distance for the same point, got %v", d)
+	}
+}
+
+func TestServeHTTP_Geofence(t *testing.T) {
+	defer resetGlobals()
+	metrics.InitMetrics()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	reader := newTestReader(t, "GeoLite2-City", map[string]mmdbtype.Map{
+		"1.2.3.0/24": { // Potsdam
+			"country":  mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+			"location": mmdbtype.Map{"latitude": mmdbtype.Float64(52.39), "longitude": mmdbtype.Float64(13.06)},
+		},
+		"1.2.4.0/24": { // Potsdam
+			"country":  mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+			"location": mmdbtype.Map{"latitude": mmdbtype.Float64(52.39), "longitude": mmdbtype.Float64(13.06)},
+		},
+		"5.6.7.0/24": { // Munich
+			"country":  mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+			"location": mmdbtype.Map{"latitude": mmdbtype.Float64(48.137), "longitude": mmdbtype.Float64(11.575)},
+		},
+		"9.9.9.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+		},
+	})
+	source := &mockGeoIPSource{ready: true, lookup: reader.Lookup}
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+	geofence = func() *config.Geofence {
+		return &config.Geofence{Lat: 52.52, Lon: 13.405, RadiusKm: 100} // Berlin
+	}
+
+	tests := []struct {
+		name           string
+		ip             string
+		expectedStatus int
+		expectedReason string
+		distance       bool
+	}{
+		{
+			name:           "Allowed country inside the radius",
+			ip:             "1.2.3.4",
+			expectedStatus: http.StatusOK,
+			expectedReason: reasonCountryAllowed,
+			distance:       true,
+		},
+		{
+			name:           "Denied country inside the radius stays denied",
+			ip:             "1.2.4.4",
+			expectedStatus: http.StatusForbidden,
+			expectedReason: reasonCountryNotAllowed,
+			distance:       true,
+		},
+		{
+			name:           "Allowed country outside the radius",
+			ip:             "5.6.7.8",
+			expectedStatus: http.StatusForbidden,
+			expectedReason: reasonGeofenceOutside,
+			distance:       true,
+		},
+		{
+			name:           "No location falls back to the country policy",
+			ip:             "9.9.9.9",
+			expectedStatus: http.StatusForbidden,
+			expectedReason: reasonCountryNotAllowed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			CacheCleanup()
+			getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP(tc.ip) }
+			var served cacheEntry
+			serveVerdict = func(w http.ResponseWriter, entry cacheEntry) {
+				served = entry
+				origServeVerdict(w, entry)
+			}
+
+			w := httptest.NewRecorder()
+			NewAuthHandler(source).ServeHTTP(w, httptest.NewRequest("GET", "/auth", nil))
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if served.reason != tc.expectedReason {
+				t.Errorf("Expected reason %q, got %q", tc.expectedReason, served.reason)
+			}
+			if got := w.Header().Get(geofenceDistanceHeader); (got != "") != tc.distance {
+				t.Errorf("Expected distance header present=%v, got %q", tc.distance, got)
+			}
+		})
+	}
+}
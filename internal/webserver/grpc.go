@@ -0,0 +1,132 @@
+//go:build grpc
+
+package webserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	geoipv1 "github.com/rdwr-valentineg/GeoIP/api/geoip/v1"
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// verdictServer implements the gRPC VerdictService on top of the same cache
+// and reader as /auth.
+type verdictServer struct {
+	geoipv1.UnimplementedVerdictServiceServer
+	auth *AuthHandler
+}
+
+// serveGRPC starts the gRPC verdict service on addr.
+func serveGRPC(addr string, source db.GeoIPSource, errCh chan error) (grpcServer, error) {
+	lis, err := listenTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := newGRPCServer(source)
+	go func() {
+		log.Info().Str("addr", addr).Msg("GeoIP gRPC server listening")
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			log.Error().Err(err).Msg("gRPC server error")
+			errCh <- err
+		} else {
+			errCh <- nil
+		}
+	}()
+	return srv, nil
+}
+
+// newGRPCServer serves both the VerdictService and Envoy's ext_authz
+// Authorization service from one AuthHandler.
+func newGRPCServer(source db.GeoIPSource) *grpc.Server {
+	auth := NewAuthHandler(source)
+	srv := grpc.NewServer()
+	geoipv1.RegisterVerdictServiceServer(srv, &verdictServer{auth: auth})
+	authv3.RegisterAuthorizationServer(srv, &authzServer{auth: auth})
+	return srv
+}
+
+// grpcUnavailable returns the Unavailable status /auth answers with 503 for,
+// or nil when checks can be served.
+func grpcUnavailable(auth *AuthHandler) error {
+	if isDraining() {
+		return status.Error(codes.Unavailable, "server is draining")
+	}
+	if !configLoaded() {
+		log.Error().Msg("Configuration not loaded, rejecting gRPC check")
+		return status.Error(codes.Unavailable, "configuration not loaded")
+	}
+	if !auth.Db.IsReady() {
+		return status.Error(codes.Unavailable, "GeoIP DB not ready")
+	}
+	return nil
+}
+
+// grantVerdict audits the verdict reached for ip, applies monitor mode to it
+// and records it like serveVerdict, reporting whether the request is let
+// through.
+func grantVerdict(ip net.IP, entry cacheEntry) bool {
+	recordAudit(ip, entry)
+	allowed := entry.allowed
+	country := metrics.CountryLabel(entry.country)
+	if !allowed && monitorMode() {
+		allowed = true
+		metrics.WouldDenyTotal.WithLabelValues(country).Inc()
+	}
+	metrics.RequestsTotal.WithLabelValues(country, strconv.FormatBool(allowed)).Inc()
+	return allowed
+}
+
+func (vs *verdictServer) Check(ctx context.Context, req *geoipv1.CheckRequest) (*geoipv1.CheckResponse, error) {
+	if err := grpcUnavailable(vs.auth); err != nil {
+		return nil, err
+	}
+
+	var ip net.IP
+	if value := strings.TrimSpace(req.GetIp()); value != "" {
+		if ip = parseIP(value); ip == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ip %q", value)
+		}
+	} else if ip = ipFromMetadata(ctx); ip == nil {
+		return nil, status.Error(codes.InvalidArgument, "unable to determine IP")
+	}
+
+	entry, _, err := vs.auth.verdict(config.GetCacheNamespace(), ip)
+	if err != nil {
+		log.Error().Err(err).Str("ip", ip.String()).Msg("gRPC lookup failed")
+		return nil, status.Error(codes.Internal, "GeoIP lookup failed")
+	}
+
+	return &geoipv1.CheckResponse{Allowed: grantVerdict(ip, entry), Country: entry.country}, nil
+}
+
+// ipFromMetadata mirrors getIPFromRequest for gRPC: the first address in the
+// -ip-header metadata, else the peer address.
+func ipFromMetadata(ctx context.Context) net.IP {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(config.GetIpHeader()); len(values) > 0 && values[0] != "" {
+			first, _, _ := strings.Cut(values[0], ",")
+			return parseIP(strings.TrimSpace(first))
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return nil
+		}
+		return parseIP(host)
+	}
+	return nil
+}
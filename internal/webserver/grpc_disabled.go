@@ -0,0 +1,14 @@
+//go:build !grpc
+
+package webserver
+
+import (
+	"errors"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+)
+
+// serveGRPC reports that gRPC support was left out of this build.
+func serveGRPC(addr string, source db.GeoIPSource, errCh chan error) (grpcServer, error) {
+	return nil, errors.New("grpc support is not compiled in, rebuild with -tags grpc")
+}
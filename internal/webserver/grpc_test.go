@@ -0,0 +1,104 @@
+//go:build grpc
+
+package webserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	geoipv1 "github.com/rdwr-valentineg/GeoIP/api/geoip/v1"
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestVerdictServer_Check(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	metrics.InitMetrics()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+	rules = func() *config.RuleSet {
+		return &config.RuleSet{
+			Rules:   []config.Rule{{Type: config.RuleTypeCountry, Match: "US", Action: config.RuleActionAllow}},
+			Default: config.RuleActionDeny,
+		}
+	}
+	source := &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+		if ip.String() == "8.8.8.8" {
+			record.(*geoRecord).Country.ISOCode = "US"
+		} else {
+			record.(*geoRecord).Country.ISOCode = "RU"
+		}
+		return nil
+	}}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(source)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := geoipv1.NewVerdictServiceClient(conn)
+	publisher := &fakePublisher{}
+	l := newAuditLog(publisher, "verdicts", 8, config.AuditPolicyDrop)
+	audit.Store(l)
+
+	tests := []struct {
+		name            string
+		ip              string
+		header          string
+		expectedAllowed bool
+		expectedCountry string
+		expectedCode    codes.Code
+	}{
+		{name: "Allowed IP", ip: "8.8.8.8", expectedAllowed: true, expectedCountry: "US"},
+		{name: "Denied IP", ip: "2.3.4.5", expectedCountry: "RU"},
+		{name: "IP from metadata", header: "8.8.8.8, 10.0.0.1", expectedAllowed: true, expectedCountry: "US"},
+		{name: "Invalid IP", ip: "nope", expectedCode: codes.InvalidArgument},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.header != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, config.GetIpHeader(), tc.header)
+			}
+			resp, err := client.Check(ctx, &geoipv1.CheckRequest{Ip: tc.ip})
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("Expected code %v, got %v (%v)", tc.expectedCode, code, err)
+			}
+			if err != nil {
+				return
+			}
+			if resp.GetAllowed() != tc.expectedAllowed || resp.GetCountry() != tc.expectedCountry {
+				t.Errorf("Expected allowed=%v country=%q, got allowed=%v country=%q",
+					tc.expectedAllowed, tc.expectedCountry, resp.GetAllowed(), resp.GetCountry())
+			}
+		})
+	}
+
+	// Every check that reached a verdict is audited.
+	l.stop()
+	if len(publisher.payloads) != 3 {
+		t.Errorf("Expected 3 audited gRPC verdicts, got %d", len(publisher.payloads))
+	}
+
+	// Checks share the /auth verdict cache.
+	if _, found := geoCache[cacheKey(config.GetCacheNamespace(), net.ParseIP("8.8.8.8"))]; !found {
+		t.Error("Expected the gRPC verdict to be cached for /auth")
+	}
+}
@@ -1,28 +1,184 @@
 package webserver
 
 import (
+	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/rdwr-valentineg/GeoIP/internal/config"
 	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
 	"github.com/rs/zerolog/log"
 )
 
+// compactResponseHeader asks /auth for a body-less verdict.
+const compactResponseHeader = "X-Compact-Response"
+
+// allowReasonHeader tells downstreams why a request was let through; it is
+// only sent with -expose-reason.
+const allowReasonHeader = "X-Allow-Reason"
+
+// decisionTraceHeader summarizes how an /auth verdict was reached; it is
+// only sent with -expose-trace.
+const decisionTraceHeader = "X-Decision-Trace"
+
+// euHeader carries the record's EU membership flag on allowed verdicts.
+const euHeader = "X-EU"
+
+// denyResponse is the /auth denial body for clients that accept JSON. The
+// resolved country and the reason are only sent with -expose-reason.
+type denyResponse struct {
+	Allowed bool   `json:"allowed"`
+	Country string `json:"country,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Values of the X-Resolved-Source header and the resolved_source field.
+const (
+	ipSourceHeader     = "header"
+	ipSourceRemoteAddr = "remoteaddr"
+	ipSourceQuery      = "query"
+)
+
 var (
-	serveVerdict = func(w http.ResponseWriter, allowed bool, country string) {
-		if allowed {
-			respondAllowed(w, country)
-			metrics.RequestsTotal.WithLabelValues(country, "true").Inc()
-			log.Debug().Str("Country", country).Msg("allowed")
+	serveVerdict = func(w http.ResponseWriter, entry cacheEntry) {
+		if entry.geofenced {
+			w.Header().Set(geofenceDistanceHeader, formatDistanceKm(entry.distanceKm))
+		}
+		if entry.network != "" {
+			w.Header().Set("X-Matched-Network", entry.network)
+		}
+		if entry.allowed {
+			respondAllowed(w, entry)
+			metrics.RequestsTotal.WithLabelValues(metrics.CountryLabel(entry.country), "true").Inc()
+			log.Debug().Str("Country", entry.country).Msg("allowed")
+		} else if monitorMode() {
+			respondAllowed(w, entry)
+			metrics.RequestsTotal.WithLabelValues(metrics.CountryLabel(entry.country), "true").Inc()
+			metrics.WouldDenyTotal.WithLabelValues(metrics.CountryLabel(entry.country)).Inc()
+			log.Info().Str("Country", entry.country).Str("reason", entry.reason).Msg("would deny (monitor mode)")
 		} else {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			metrics.RequestsTotal.WithLabelValues(country, "false").Inc()
-			log.Debug().Str("Country", country).Msg("denied")
+			respondDenied(w, entry)
+			metrics.RequestsTotal.WithLabelValues(metrics.CountryLabel(entry.country), "false").Inc()
+			log.Debug().Str("Country", entry.country).Msg("denied")
 		}
 	}
 
+	// respondDenied answers 403. Compact responses carry only X-Country and
+	// no body, dropping the "Forbidden\n" body and its Content-Type and
+	// X-Content-Type-Options headers: 60 instead of 129 bytes per denial as
+	// measured by BenchmarkServeVerdict_Deny.
+	respondDenied = func(w http.ResponseWriter, entry cacheEntry) {
+		rs := responder()
+		switch {
+		case entry.compact || !rs.denyBody:
+			w.Header().Set("X-Country", entry.country)
+			w.WriteHeader(rs.denyStatus)
+		case entry.jsonDeny:
+			resp := denyResponse{}
+			if exposeReason() {
+				resp.Country, resp.Reason = entry.country, entry.reason
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(rs.denyStatus)
+			json.NewEncoder(w).Encode(resp)
+		default:
+			http.Error(w, http.StatusText(rs.denyStatus), rs.denyStatus)
+		}
+	}
+
+	// respondNotReady answers /auth while the DB is not ready, following
+	// -not-ready-policy. Allowing fails open so an auth_request proxy keeps
+	// serving during the initial fetch.
+	respondNotReady = func(w http.ResponseWriter) {
+		switch notReadyPolicy() {
+		case config.NotReadyAllow:
+			if exposeReason() {
+				w.Header().Set(allowReasonHeader, "not-ready")
+			}
+			w.WriteHeader(responder().allowStatus)
+		case config.NotReadyDeny:
+			status := responder().denyStatus
+			http.Error(w, http.StatusText(status), status)
+		default:
+			http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+		}
+	}
+
+	// compactResponse reports whether the verdict should be sent without a
+	// body, either for every request or when the caller asks for it.
+	compactResponse = func(r *http.Request) bool {
+		return config.GetCompactResponses() || r.Header.Get(compactResponseHeader) != ""
+	}
+
+	ipOverride = config.GetIPOverride
+
+	// cachePurgeBatch bounds the entries evicted per purge tick.
+	cachePurgeBatch = config.GetCachePurgeBatch
+
+	// cacheTTL is how long a cached verdict is fresh; 0 never expires it.
+	cacheTTL = config.GetCacheTTL
+
+	// cacheTTLNotFound is how long a verdict for an IP without a country
+	// is fresh; 0 falls back to cacheTTL.
+	cacheTTLNotFound = config.GetCacheTTLNotFound
+
+	// cacheStaleGrace is how long past cacheTTL a verdict is served stale
+	// while it is refreshed.
+	cacheStaleGrace = config.GetCacheStaleGrace
+
+	// cacheEviction names the strategy picking entries to evict.
+	cacheEviction = config.GetCacheEviction
+
+	// cacheMaxBytes bounds the estimated cache size; 0 disables the bound.
+	cacheMaxBytes = config.GetCacheMaxBytes
+
+	// countryFieldPath selects the generic record decode when non-empty.
+	countryFieldPath = config.GetCountryFieldPath
+
+	// countrySourceChain orders the record's country sources.
+	countrySourceChain = config.GetCountrySourceChain
+
+	// multiCountryMode judges records listing several countries.
+	multiCountryMode = config.GetMultiCountryMode
+
+	// allowEUOnly replaces the country allow-list with the EU membership flag.
+	allowEUOnly = config.GetAllowEUOnly
+
+	// rules returns the ordered rule set replacing the country allow-list.
+	rules = config.GetRules
+
+	// configLoaded guards handlers that must not answer before InitConfig.
+	configLoaded = config.IsLoaded
+
+	// exposeReason adds X-Allow-Reason to allowed verdicts.
+	exposeReason = config.GetExposeReason
+
+	// exposeTrace adds X-Decision-Trace to /auth verdicts.
+	exposeTrace = config.GetExposeTrace
+
+	// notReadyPolicy selects how /auth answers before the DB is ready.
+	notReadyPolicy = config.GetNotReadyPolicy
+
+	// accessLogMode selects which /auth requests get an access log event.
+	accessLogMode = config.GetAccessLogMode
+
+	// responder shapes /auth verdicts for the gateway in front.
+	responder = func() verdictResponder {
+		return newVerdictResponder(config.GetResponder(), config.GetAllowStatus(), config.GetDenyStatus())
+	}
+
+	// monitorMode lets denied verdicts through while recording them.
+	monitorMode = config.GetMonitorMode
+
+	// policyFingerprint tags cache snapshots with the policy their verdicts
+	// were decided under.
+	policyFingerprint = config.PolicyFingerprint
+
 	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool {
 		for _, subnet := range excluded {
 			if subnet.Contains(ip) {
@@ -32,25 +188,183 @@ var (
 		return false
 	}
 
-	respondAllowed = func(w http.ResponseWriter, isoCode string) {
-		w.Header().Set("X-Country", isoCode)
-		w.WriteHeader(http.StatusOK)
+	respondAllowed = func(w http.ResponseWriter, entry cacheEntry) {
+		w.Header().Set("X-Country", entry.country)
+		if exposeReason() {
+			w.Header().Set(allowReasonHeader, allowReason(entry))
+		}
+		if entry.countryName != "" {
+			w.Header().Set("X-Country-Name", entry.countryName)
+		}
+		if entry.timeZone != "" {
+			w.Header().Set("X-Time-Zone", entry.timeZone)
+		}
+		if entry.euKnown {
+			w.Header().Set(euHeader, strconv.FormatBool(entry.inEU))
+		}
+		w.WriteHeader(responder().allowStatus)
+	}
+
+	// cacheNamespace selects the policy namespace a request's verdict is
+	// cached under, so identical IPs under different policies never collide.
+	cacheNamespace = func(r *http.Request) string {
+		if config.GetCacheNamespaceByHost() && r.Host != "" {
+			return strings.ToLower(r.Host)
+		}
+		return config.GetCacheNamespace()
+	}
+
+	// authQueryIP lets /auth take the client IP from the ip query parameter.
+	authQueryIP = config.GetAuthQueryIP
+
+	// ipSource reports where /auth takes the client IP from.
+	ipSource = func(r *http.Request) string {
+		if authQueryIP() && r.URL.Query().Has("ip") {
+			return ipSourceQuery
+		}
+		if r.Header.Get(config.GetIpHeader()) != "" {
+			return ipSourceHeader
+		}
+		return ipSourceRemoteAddr
 	}
 
 	getIPFromRequest = func(r *http.Request) net.IP {
 		hdr := r.Header.Get(config.GetIpHeader())
 		if hdr != "" {
 			log.Debug().Str("value", hdr).Msg("ip header found")
+			metrics.IPSourceTotal.WithLabelValues(ipSourceHeader).Inc()
 			parts := strings.Split(hdr, ",")
-			ip := strings.TrimSpace(parts[0])
-			return net.ParseIP(ip)
+			return parseIP(strings.TrimSpace(parts[0]))
 		}
 		log.Debug().Str("value", r.RemoteAddr).Msg("ip header found not found, using RemoteAddr")
+		metrics.IPSourceTotal.WithLabelValues(ipSourceRemoteAddr).Inc()
 		host, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to parse RemoteAddr")
 			return nil
 		}
-		return net.ParseIP(host)
+		return parseIP(host)
 	}
 )
+
+// cacheKey returns the verdict cache key of ip under namespace.
+func cacheKey(namespace string, ip net.IP) string {
+	return string(appendCacheKey(nil, namespace, ip))
+}
+
+// appendCacheKey appends the cache key of ip under namespace to dst. It
+// renders the IP like net.IP.String but without allocating, so a cache hit
+// can look the key up from a stack buffer.
+func appendCacheKey(dst []byte, namespace string, ip net.IP) []byte {
+	if namespace != "" {
+		dst = append(dst, namespace...)
+		dst = append(dst, '|')
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return append(dst, ip.String()...)
+	}
+	return addr.Unmap().AppendTo(dst)
+}
+
+// Errors of parseQueryIP, worded for the 400 response body.
+var (
+	errIPMissing  = errors.New("missing ip parameter")
+	errIPCIDR     = errors.New("ip must be a single address, not a CIDR")
+	errIPHostname = errors.New("ip must be an address, not a hostname")
+	errIPInvalid  = errors.New("invalid ip address")
+)
+
+// parseQueryIP validates an IP passed to the lookup endpoints. Surrounding
+// whitespace is trimmed, and the returned IP's String is the canonical
+// (lower-case IPv6) form.
+func parseQueryIP(raw string) (net.IP, error) {
+	s := strings.TrimSpace(raw)
+	switch {
+	case s == "":
+		return nil, errIPMissing
+	case strings.Contains(s, "/"):
+		return nil, errIPCIDR
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		if !strings.Contains(s, ":") && strings.ContainsFunc(s, unicode.IsLetter) {
+			return nil, errIPHostname
+		}
+		return nil, errIPInvalid
+	}
+	return ip, nil
+}
+
+// parseIP parses an IP address, dropping any IPv6 zone such as "%eth0" that
+// net.ParseIP rejects.
+func parseIP(s string) net.IP {
+	addr, _, _ := strings.Cut(s, "%")
+	return net.ParseIP(addr)
+}
+
+// requireConfig answers 503 and reports false when the configuration has
+// not been loaded, e.g. when the server is started before InitConfig.
+func requireConfig(w http.ResponseWriter) bool {
+	if configLoaded() {
+		return true
+	}
+	log.Error().Msg("Configuration not loaded, answering 503")
+	http.Error(w, "Configuration not loaded", http.StatusServiceUnavailable)
+	return false
+}
+
+// decisionTrace summarizes the evaluation of entry for X-Decision-Trace:
+// where the IP came from, whether it was excluded, the country and the
+// verdict with its reason.
+func decisionTrace(source string, entry cacheEntry, cached bool) string {
+	return "ip=" + source +
+		"; excluded=" + strconv.FormatBool(entry.reason == reasonLAN) +
+		"; country=" + entry.country +
+		"; allowed=" + strconv.FormatBool(entry.allowed) +
+		"; reason=" + entry.reason +
+		"; cached=" + strconv.FormatBool(cached)
+}
+
+// allowReason names why entry is let through. A denied entry only reaches
+// respondAllowed in monitor mode.
+func allowReason(entry cacheEntry) string {
+	if !entry.allowed {
+		return "monitor"
+	}
+	switch entry.reason {
+	case reasonLAN:
+		return "lan"
+	case reasonIPOverride:
+		return "allowlist-ip"
+	case reasonCountryAllowed:
+		return "country"
+	case reasonASNAllowed:
+		return "asn"
+	case reasonEUMember:
+		return "eu"
+	case reasonRuleMatched, reasonRuleDefault:
+		return "rule"
+	}
+	return entry.reason
+}
+
+// statusRecorder remembers the status code written through it so the
+// per-request log event can report it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// ipString renders ip for logging; a nil IP logs as an empty string.
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
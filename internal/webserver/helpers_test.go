@@ -3,9 +3,14 @@ package webserver
 import (
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
 )
 
 func TestIsExcluded(t *testing.T) {
@@ -48,49 +53,392 @@ func TestIsExcluded(t *testing.T) {
 		})
 	}
 
+	t.Run("IPv6 private ranges in the default excludes", func(t *testing.T) {
+		defer resetGlobals()
+		os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+		if err := config.InitConfig(); err != nil {
+			t.Fatalf("InitConfig failed: %v", err)
+		}
+		excluded := config.GetExcludeCIDR()
+		for ip, want := range map[string]bool{
+			"fd12:3456:789a::1": true,  // unique local
+			"fe80::1":           true,  // link-local
+			"::1":               true,  // loopback
+			"2001:db8::1":       false, // documentation, routed like global
+		} {
+			if got := isExcluded(parseIP(ip), excluded); got != want {
+				t.Errorf("Expected isExcluded(%s) = %v, got %v", ip, want, got)
+			}
+		}
+	})
 }
 
 func TestGetIPFromRequest(t *testing.T) {
-	config.InitConfig()
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
 	tests := []struct {
-		name       string
-		request    *http.Request
-		expectedIP net.IP
+		name           string
+		request        *http.Request
+		expectedIP     net.IP
+		expectedSource string
 	}{
 		{
 			name: "IP from header",
 			request: &http.Request{
 				Header: http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
 			},
-			expectedIP: net.ParseIP("1.2.3.4"),
+			expectedIP:     net.ParseIP("1.2.3.4"),
+			expectedSource: ipSourceHeader,
 		}, {
 			name: "Multiple IPs in header",
 			request: &http.Request{
 				Header: http.Header{"X-Forwarded-For": []string{"1.2.3.4,5.6.7.8"}},
 			},
-			expectedIP: net.ParseIP("1.2.3.4"),
+			expectedIP:     net.ParseIP("1.2.3.4"),
+			expectedSource: ipSourceHeader,
+		}, {
+			name: "Zoned link-local IPv6 in header",
+			request: &http.Request{
+				Header: http.Header{"X-Forwarded-For": []string{"fe80::1%eth0"}},
+			},
+			expectedIP:     net.ParseIP("fe80::1"),
+			expectedSource: ipSourceHeader,
+		}, {
+			name: "Global IPv6 in header",
+			request: &http.Request{
+				Header: http.Header{"X-Forwarded-For": []string{"2001:db8::1, 1.2.3.4"}},
+			},
+			expectedIP:     net.ParseIP("2001:db8::1"),
+			expectedSource: ipSourceHeader,
+		}, {
+			name:           "Zoned link-local IPv6 RemoteAddr",
+			request:        &http.Request{RemoteAddr: "[fe80::1%eth0]:5678"},
+			expectedIP:     net.ParseIP("fe80::1"),
+			expectedSource: ipSourceRemoteAddr,
 		}, {
-			name:       "IP from RemoteAddr",
-			request:    &http.Request{RemoteAddr: "1.2.3.4:5678"},
-			expectedIP: net.ParseIP("1.2.3.4"),
+			name:           "IP from RemoteAddr",
+			request:        &http.Request{RemoteAddr: "1.2.3.4:5678"},
+			expectedIP:     net.ParseIP("1.2.3.4"),
+			expectedSource: ipSourceRemoteAddr,
 		}, {
-			name:       "bad remote address value",
-			request:    &http.Request{RemoteAddr: "bad:address"},
-			expectedIP: nil,
+			name:           "bad remote address value",
+			request:        &http.Request{RemoteAddr: "bad:address"},
+			expectedIP:     nil,
+			expectedSource: ipSourceRemoteAddr,
 		}, {
-			name:       "SplitHostPort error",
-			request:    &http.Request{RemoteAddr: "missingport"},
-			expectedIP: nil,
+			name:           "SplitHostPort error",
+			request:        &http.Request{RemoteAddr: "missingport"},
+			expectedIP:     nil,
+			expectedSource: ipSourceRemoteAddr,
 		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			metrics.Reset()
 			ip := getIPFromRequest(tc.request)
 			if (ip == nil && tc.expectedIP != nil) ||
 				(ip != nil && tc.expectedIP == nil) ||
 				!ip.Equal(tc.expectedIP) {
 				t.Errorf("Expected IP %s, got %s", tc.expectedIP.String(), ip.String())
 			}
+			if source := ipSource(tc.request); source != tc.expectedSource {
+				t.Errorf("Expected source %q, got %q", tc.expectedSource, source)
+			}
+			if got := testutil.ToFloat64(metrics.IPSourceTotal.WithLabelValues(tc.expectedSource)); got != 1 {
+				t.Errorf("Expected ip_source_total{source=%q} to be 1, got %v", tc.expectedSource, got)
+			}
+			if got := testutil.CollectAndCount(metrics.IPSourceTotal); got != 1 {
+				t.Errorf("Expected only the %q source to be counted, got %d series", tc.expectedSource, got)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	ip := net.ParseIP("1.2.3.4")
+	if got := cacheKey("", ip); got != "1.2.3.4" {
+		t.Errorf("Expected bare IP key without namespace, got %q", got)
+	}
+	a, b := cacheKey("tenant-a", ip), cacheKey("tenant-b", ip)
+	if a == b {
+		t.Errorf("Expected distinct keys for distinct namespaces, both got %q", a)
+	}
+	if a != "tenant-a|1.2.3.4" {
+		t.Errorf("Expected namespaced key %q, got %q", "tenant-a|1.2.3.4", a)
+	}
+}
+
+func TestServeHTTP_CompactResponses(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	metrics.InitMetrics()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+
+	handler := NewAuthHandler(&mockGeoIPSource{
+		ready: true,
+		lookup: func(ip net.IP, record any) error {
+			if ip.String() == "8.8.8.8" {
+				record.(*geoRecord).Country.ISOCode = "US"
+			} else {
+				record.(*geoRecord).Country.ISOCode = "RU"
+			}
+			return nil
+		},
+	})
+
+	tests := []struct {
+		name           string
+		ip             string
+		compactHeader  bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Allow has no body", ip: "8.8.8.8", expectedStatus: http.StatusOK},
+		{name: "Compact allow has no body", ip: "8.8.8.8", compactHeader: true, expectedStatus: http.StatusOK},
+		{name: "Default deny has a body", ip: "2.3.4.5", expectedStatus: http.StatusForbidden, expectedBody: "Forbidden\n"},
+		{name: "Compact deny has no body", ip: "2.3.4.5", compactHeader: true, expectedStatus: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP(tc.ip) }
+			req := httptest.NewRequest("GET", "/auth", nil)
+			if tc.compactHeader {
+				req.Header.Set(compactResponseHeader, "1")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if got := w.Body.String(); got != tc.expectedBody {
+				t.Errorf("Expected body %q, got %q", tc.expectedBody, got)
+			}
+			if tc.compactHeader && w.Header().Get("X-Country") == "" {
+				t.Error("Expected compact responses to carry X-Country")
+			}
+		})
+	}
+}
+
+func TestServeHTTP_JSONDeny(t *testing.T) {
+	defer resetGlobals()
+	metrics.InitMetrics()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+	getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP("2.3.4.5") }
+
+	handler := NewAuthHandler(&mockGeoIPSource{
+		ready: true,
+		lookup: func(ip net.IP, record any) error {
+			record.(*geoRecord).Country.ISOCode = "RU"
+			return nil
+		},
+	})
+
+	tests := []struct {
+		name         string
+		accept       string
+		expose       bool
+		expectedType string
+		expectedBody string
+	}{
+		{name: "Plain", expectedType: "text/plain; charset=utf-8", expectedBody: "Forbidden\n"},
+		{name: "JSON with reason", accept: "application/json", expose: true, expectedType: "application/json", expectedBody: `{"allowed":false,"country":"RU","reason":"country_not_allowed"}` + "\n"},
+		{name: "JSON hides the country without reason", accept: "text/html, application/json;q=0.9", expectedType: "application/json", expectedBody: `{"allowed":false}` + "\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			exposeReason = func() bool { return tc.expose }
+			req := httptest.NewRequest("GET", "/auth", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.expectedType {
+				t.Errorf("Expected Content-Type %q, got %q", tc.expectedType, got)
+			}
+			if got := w.Body.String(); got != tc.expectedBody {
+				t.Errorf("Expected body %q, got %q", tc.expectedBody, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_DecisionTrace(t *testing.T) {
+	defer resetGlobals()
+	metrics.InitMetrics()
+	getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP(r.Header.Get("X-Test-IP")) }
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return ip.IsPrivate() }
+
+	handler := NewAuthHandler(&mockGeoIPSource{
+		ready: true,
+		lookup: func(ip net.IP, record any) error {
+			record.(*geoRecord).Country.ISOCode = "RU"
+			return nil
+		},
+	})
+	serve := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth", nil)
+		req.Header.Set("X-Test-IP", ip)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if got := serve("2.3.4.5").Header().Get(decisionTraceHeader); got != "" {
+		t.Errorf("Expected no trace by default, got %q", got)
+	}
+
+	exposeTrace = func() bool { return true }
+	tests := []struct {
+		name     string
+		ip       string
+		expected string
+	}{
+		{name: "LAN hit", ip: "10.0.0.1", expected: "ip=remoteaddr; excluded=true; country=LAN; allowed=true; reason=lan; cached=false"},
+		{name: "Country deny", ip: "2.3.4.5", expected: "ip=remoteaddr; excluded=false; country=RU; allowed=false; reason=country_not_allowed; cached=true"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := serve(tc.ip).Header().Get(decisionTraceHeader); got != tc.expected {
+				t.Errorf("Expected trace %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_AllowReason(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	metrics.InitMetrics()
+
+	handler := NewAuthHandler(&mockGeoIPSource{
+		ready: true,
+		lookup: func(ip net.IP, record any) error {
+			if ip.String() == "8.8.8.8" {
+				record.(*geoRecord).Country.ISOCode = "US"
+			} else {
+				record.(*geoRecord).Country.ISOCode = "RU"
+			}
+			return nil
+		},
+	})
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return ip.String() == "10.0.0.1" }
+	ipOverride = func(ip net.IP) (bool, bool) { return true, ip.String() == "203.0.113.5" }
+
+	tests := []struct {
+		name           string
+		ip             string
+		monitor        bool
+		expose         bool
+		expectedStatus int
+		expectedReason string
+	}{
+		{name: "LAN", ip: "10.0.0.1", expose: true, expectedStatus: http.StatusOK, expectedReason: "lan"},
+		{name: "IP override", ip: "203.0.113.5", expose: true, expectedStatus: http.StatusOK, expectedReason: "allowlist-ip"},
+		{name: "Allowed country", ip: "8.8.8.8", expose: true, expectedStatus: http.StatusOK, expectedReason: "country"},
+		{name: "Monitor mode", ip: "2.3.4.5", monitor: true, expose: true, expectedStatus: http.StatusOK, expectedReason: "monitor"},
+		{name: "Off by default", ip: "8.8.8.8", expectedStatus: http.StatusOK},
+		{name: "Denied has no reason", ip: "2.3.4.5", expose: true, expectedStatus: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			CacheCleanup()
+			getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP(tc.ip) }
+			monitorMode = func() bool { return tc.monitor }
+			exposeReason = func() bool { return tc.expose }
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/auth", nil))
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get(allowReasonHeader); got != tc.expectedReason {
+				t.Errorf("Expected %s %q, got %q", allowReasonHeader, tc.expectedReason, got)
+			}
+		})
+	}
+}
+
+func TestCompactResponse_Config(t *testing.T) {
+	defer resetGlobals()
+	compactResponse = func(r *http.Request) bool { return true }
+	metrics.InitMetrics()
+
+	w := httptest.NewRecorder()
+	entry := cacheEntry{country: "RU", compact: compactResponse(httptest.NewRequest("GET", "/auth", nil))}
+	serveVerdict(w, entry)
+	if w.Code != http.StatusForbidden || w.Body.Len() != 0 {
+		t.Errorf("Expected an empty 403, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// BenchmarkServeVerdict_Deny reports the bytes on the wire for a denial with
+// and without compact responses.
+func BenchmarkServeVerdict_Deny(b *testing.B) {
+	metrics.InitMetrics()
+	for _, compact := range []bool{false, true} {
+		name := "default"
+		if compact {
+			name = "compact"
+		}
+		b.Run(name, func(b *testing.B) {
+			entry := cacheEntry{country: "RU", reason: reasonCountryNotAllowed, compact: compact}
+			var w *httptest.ResponseRecorder
+			for b.Loop() {
+				w = httptest.NewRecorder()
+				serveVerdict(w, entry)
+			}
+			dump, err := httputil.DumpResponse(w.Result(), true)
+			if err != nil {
+				b.Fatalf("failed to dump response: %v", err)
+			}
+			b.ReportMetric(float64(len(dump)), "bytes/resp")
+		})
+	}
+}
+
+func TestParseQueryIP(t *testing.T) {
+	tests := []struct {
+		raw         string
+		expected    string
+		expectedErr error
+	}{
+		{raw: "1.2.3.4", expected: "1.2.3.4"},
+		{raw: "  1.2.3.4\t\n", expected: "1.2.3.4"},
+		{raw: " 2001:DB8:0:0::1 ", expected: "2001:db8::1"},
+		{raw: "", expectedErr: errIPMissing},
+		{raw: "   ", expectedErr: errIPMissing},
+		{raw: "10.0.0.0/8", expectedErr: errIPCIDR},
+		{raw: "2001:db8::/32", expectedErr: errIPCIDR},
+		{raw: "example.com", expectedErr: errIPHostname},
+		{raw: "localhost", expectedErr: errIPHostname},
+		{raw: "1.2.3.999", expectedErr: errIPInvalid},
+		{raw: "2001:db8::zz", expectedErr: errIPInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.raw, func(t *testing.T) {
+			ip, err := parseQueryIP(tc.raw)
+			if err != tc.expectedErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err == nil && ip.String() != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, ip)
+			}
 		})
 	}
 }
@@ -0,0 +1,46 @@
+package webserver
+
+import (
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"golang.org/x/text/language"
+)
+
+// localizedName picks the entry of a MaxMind names map that best matches the
+// client's Accept-Language, falling back to the configured locale and then to
+// English. It returns "" when the record carries no names.
+func localizedName(names map[string]string, acceptLanguage string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	if acceptLanguage != "" {
+		tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+		if err == nil {
+			for _, tag := range tags {
+				if name := nameForTag(names, tag); name != "" {
+					return name
+				}
+			}
+		}
+	}
+
+	for _, locale := range []string{config.GetLocale(), "en"} {
+		if name := names[locale]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// nameForTag matches MaxMind's locale keys, which are either a bare language
+// ("de") or a language with region ("pt-BR", "zh-CN").
+func nameForTag(names map[string]string, tag language.Tag) string {
+	if name := names[tag.String()]; name != "" {
+		return name
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No {
+		return ""
+	}
+	return names[base.String()]
+}
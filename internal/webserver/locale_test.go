@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalizedName(t *testing.T) {
+	names := map[string]string{
+		"en":    "Germany",
+		"fr":    "Allemagne",
+		"de":    "Deutschland",
+		"pt-BR": "Alemanha",
+	}
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		names          map[string]string
+		expected       string
+	}{
+		{name: "French", acceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8", names: names, expected: "Allemagne"},
+		{name: "German", acceptLanguage: "de", names: names, expected: "Deutschland"},
+		{name: "Region specific", acceptLanguage: "pt-BR", names: names, expected: "Alemanha"},
+		{name: "Quality ordering", acceptLanguage: "fr;q=0.5, de;q=0.9", names: names, expected: "Deutschland"},
+		{name: "Unsupported falls back to English", acceptLanguage: "xx", names: names, expected: "Germany"},
+		{name: "Malformed header falls back to English", acceptLanguage: ";;;", names: names, expected: "Germany"},
+		{name: "No header", names: names, expected: "Germany"},
+		{name: "No names", acceptLanguage: "fr", expected: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := localizedName(tc.names, tc.acceptLanguage); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_CountryNameHeader(t *testing.T) {
+	defer resetGlobals()
+	CacheCleanup()
+	getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP("1.2.3.4") }
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+	serveVerdict = func(w http.ResponseWriter, entry cacheEntry) { respondAllowed(w, entry) }
+	handler := NewAuthHandler(&mockGeoIPSource{
+		ready: true,
+		lookup: func(ip net.IP, record any) error {
+			rec := record.(*geoRecord)
+			rec.Country.ISOCode = "DE"
+			rec.Country.Names = map[string]string{"en": "Germany", "fr": "Allemagne"}
+			return nil
+		},
+	})
+
+	// The second request is a cache hit and must still honour its own locale.
+	for _, tc := range []struct{ lang, expected string }{{"fr", "Allemagne"}, {"de", "Germany"}} {
+		req := httptest.NewRequest("GET", "/auth", nil)
+		req.Header.Set("Accept-Language", tc.lang)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Header().Get("X-Country-Name"); got != tc.expected {
+			t.Errorf("Accept-Language %q: expected X-Country-Name %q, got %q", tc.lang, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// LookupHandler reports the country and verdict for an explicit IP.
+	// Like the dry-run it neither caches nor counts towards RequestsTotal.
+	LookupHandler struct {
+		auth *AuthHandler
+	}
+
+	lookupResponse struct {
+		IP          string `json:"ip"`
+		Country     string `json:"country"`
+		Allowed     bool   `json:"allowed"`
+		CountryName string `json:"country_name,omitempty"`
+		TimeZone    string `json:"time_zone,omitempty"`
+		// EU is the record's EU membership flag; it is false for records
+		// without the MaxMind layout and for overrides and excluded IPs.
+		EU bool `json:"eu"`
+		// MatchedNetwork is the database network the record was found under.
+		MatchedNetwork string `json:"matched_network,omitempty"`
+		// ResolvedSource tells where the looked-up IP came from.
+		ResolvedSource string `json:"resolved_source,omitempty"`
+		// Error is only set on failed entries of a batch lookup.
+		Error string `json:"error,omitempty"`
+	}
+)
+
+func NewLookupHandler(db db.GeoIPSource) *LookupHandler {
+	return &LookupHandler{
+		auth: NewAuthHandler(db),
+	}
+}
+
+func (lh *LookupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !lh.auth.Db.IsReady() {
+		http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip, err := parseQueryIP(r.URL.Query().Get("ip"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := lh.auth.evaluate(ip)
+	if err != nil {
+		log.Error().Err(err).Str("ip", ip.String()).Msg("lookup failed")
+		http.Error(w, "GeoIP lookup failed", http.StatusInternalServerError)
+		return
+	}
+
+	resp := newLookupResponse(ip, entry, r.Header.Get("Accept-Language"))
+	resp.ResolvedSource = ipSourceQuery
+	w.Header().Set("X-Resolved-Source", ipSourceQuery)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newLookupResponse(ip net.IP, entry cacheEntry, acceptLanguage string) lookupResponse {
+	return lookupResponse{
+		IP:             ip.String(),
+		Country:        entry.country,
+		Allowed:        entry.allowed,
+		CountryName:    localizedName(entry.names, acceptLanguage),
+		TimeZone:       entry.timeZone,
+		MatchedNetwork: entry.network,
+		EU:             entry.inEU,
+	}
+}
@@ -0,0 +1,117 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLookupHandler(t *testing.T) {
+	defer resetGlobals()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return false }
+
+	tests := []struct {
+		name           string
+		source         *mockGeoIPSource
+		url            string
+		expectedStatus int
+		expected       *lookupResponse
+	}{
+		{
+			name: "Found",
+			source: &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+				record.(*geoRecord).Country.ISOCode = "ru"
+				return nil
+			}},
+			url:            "/lookup?ip=2.3.4.5",
+			expectedStatus: http.StatusOK,
+			expected:       &lookupResponse{IP: "2.3.4.5", Country: "RU", Allowed: false, ResolvedSource: ipSourceQuery},
+		}, {
+			name: "Matched network",
+			source: &mockGeoIPSource{ready: true, network: mustCIDR("2.0.0.0/8"), lookup: func(ip net.IP, record any) error {
+				record.(*geoRecord).Country.ISOCode = "ru"
+				return nil
+			}},
+			url:            "/lookup?ip=2.3.4.5",
+			expectedStatus: http.StatusOK,
+			expected: &lookupResponse{
+				IP: "2.3.4.5", Country: "RU", Allowed: false, ResolvedSource: ipSourceQuery,
+				MatchedNetwork: "2.0.0.0/8",
+			},
+		}, {
+			name: "EU member",
+			source: &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+				record.(*geoRecord).Country.ISOCode = "fr"
+				record.(*geoRecord).Country.IsInEuropeanUnion = true
+				return nil
+			}},
+			url:            "/lookup?ip=2.3.4.5",
+			expectedStatus: http.StatusOK,
+			expected:       &lookupResponse{IP: "2.3.4.5", Country: "FR", Allowed: false, EU: true, ResolvedSource: ipSourceQuery},
+		}, {
+			name:           "Invalid ip",
+			source:         &mockGeoIPSource{ready: true},
+			url:            "/lookup?ip=nope",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name: "Whitespace-padded IPv6",
+			source: &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+				record.(*geoRecord).Country.ISOCode = "de"
+				return nil
+			}},
+			url:            "/lookup?ip=%20%202001:DB8::1%09",
+			expectedStatus: http.StatusOK,
+			expected:       &lookupResponse{IP: "2001:db8::1", Country: "DE", Allowed: false, ResolvedSource: ipSourceQuery},
+		}, {
+			name:           "Hostname",
+			source:         &mockGeoIPSource{ready: true},
+			url:            "/lookup?ip=example.com",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "CIDR",
+			source:         &mockGeoIPSource{ready: true},
+			url:            "/lookup?ip=10.0.0.0/8",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Missing ip",
+			source:         &mockGeoIPSource{ready: true},
+			url:            "/lookup",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "DB not ready",
+			source:         &mockGeoIPSource{ready: false},
+			url:            "/lookup?ip=2.3.4.5",
+			expectedStatus: http.StatusServiceUnavailable,
+		}, {
+			name: "Lookup error",
+			source: &mockGeoIPSource{ready: true, lookup: func(ip net.IP, record any) error {
+				return errors.New("fail")
+			}},
+			url:            "/lookup?ip=2.3.4.5",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			w := httptest.NewRecorder()
+			NewLookupHandler(tc.source).ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if tc.expected != nil {
+				var got lookupResponse
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if got != *tc.expected {
+					t.Errorf("Expected %+v, got %+v", *tc.expected, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package webserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	natsTimeout = 5 * time.Second
+	// natsMaxInFlight bounds the publishes awaiting the server's +OK; a
+	// publish beyond it waits for one to be acknowledged.
+	natsMaxInFlight = 1024
+)
+
+// errNATSClosed fails the publishes pending when the publisher is closed.
+var errNATSClosed = errors.New("nats publisher closed")
+
+type (
+	// natsPublisher speaks the NATS client protocol: CONNECT after the
+	// server's INFO, upgrading to TLS first when the server requires it, PUB
+	// per message, and PONG to the server's PINGs. The connection is verbose,
+	// so the server acknowledges each publish with +OK, in order; publishes
+	// are pipelined and matched to their +OK as it arrives. A -ERR fails the
+	// pending publishes and drops the connection, as do a connection failure
+	// and a publish left unacknowledged for natsTimeout; the next publish
+	// reconnects.
+	natsPublisher struct {
+		url *url.URL
+		// tlsConfig is used when the server requires TLS; nil verifies the
+		// server against the system roots.
+		tlsConfig *tls.Config
+		// inflight holds a token per pending publish.
+		inflight chan struct{}
+		mutex    sync.Mutex
+		conn     net.Conn
+		writer   *bufio.Writer
+		// pending holds the publishes awaiting the server's +OK, in the
+		// order they were sent.
+		pending []natsPending
+		// watched is the connection whose acknowledgements are being timed.
+		watched net.Conn
+		// drained is closed once pending empties, when Close waits for it.
+		drained chan struct{}
+	}
+
+	// natsPending is a publish awaiting the server's +OK.
+	natsPending struct {
+		done func(error)
+		sent time.Time
+	}
+
+	// natsInfo is the part of the server's INFO payload the publisher uses.
+	natsInfo struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+
+	// natsConnect is the CONNECT options payload.
+	natsConnect struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		TLS      bool   `json:"tls_required"`
+		Name     string `json:"name"`
+		User     string `json:"user,omitempty"`
+		Pass     string `json:"pass,omitempty"`
+	}
+)
+
+// newNATSPublisher returns a publisher for a nats://[user:pass@]host:port
+// URL.
+func newNATSPublisher(rawURL string) (*natsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats url: %w", err)
+	}
+	return &natsPublisher{url: u, inflight: make(chan struct{}, natsMaxInFlight)}, nil
+}
+
+// Publish sends payload to subject and returns without waiting for the
+// server's +OK; done is called with the outcome, with the publisher's mutex
+// held, so it must not call back into the publisher.
+func (p *natsPublisher) Publish(subject string, payload []byte, done func(error)) {
+	p.inflight <- struct{}{}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn == nil {
+		if err := p.connectLocked(); err != nil {
+			<-p.inflight
+			done(err)
+			return
+		}
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	fmt.Fprintf(p.writer, "PUB %s %d\r\n", subject, len(payload))
+	p.writer.Write(payload)
+	p.writer.WriteString("\r\n")
+	if err := p.writer.Flush(); err != nil {
+		err = fmt.Errorf("failed to publish to nats: %w", err)
+		p.closeLocked(err)
+		<-p.inflight
+		done(err)
+		return
+	}
+	p.pending = append(p.pending, natsPending{done: done, sent: time.Now()})
+	if p.watched != p.conn {
+		p.watched = p.conn
+		conn := p.conn
+		time.AfterFunc(natsTimeout, func() { p.watchAcks(conn) })
+	}
+}
+
+// watchAcks drops conn once its oldest pending publish has waited natsTimeout
+// for the server's +OK, and otherwise checks again when it will have.
+func (p *natsPublisher) watchAcks(conn net.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn != conn {
+		return
+	}
+	if len(p.pending) == 0 {
+		p.watched = nil
+		return
+	}
+	if wait := time.Until(p.pending[0].sent.Add(natsTimeout)); wait > 0 {
+		time.AfterFunc(wait, func() { p.watchAcks(conn) })
+		return
+	}
+	p.closeLocked(errors.New("nats publish was not acknowledged"))
+}
+
+// settleLocked completes the oldest pending publish with err.
+func (p *natsPublisher) settleLocked(err error) {
+	p.pending[0].done(err)
+	p.pending = p.pending[1:]
+	<-p.inflight
+	if len(p.pending) == 0 && p.drained != nil {
+		close(p.drained)
+		p.drained = nil
+	}
+}
+
+func (p *natsPublisher) connectLocked() error {
+	conn, err := net.DialTimeout("tcp", p.url.Host, natsTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(natsTimeout))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats handshake failed: %q: %v", strings.TrimSpace(line), err)
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		conn.Close()
+		return fmt.Errorf("invalid nats info: %w", err)
+	}
+	if info.TLSRequired {
+		tlsConfig := p.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: p.url.Hostname()}
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		conn.SetDeadline(time.Now().Add(natsTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("nats tls handshake failed: %w", err)
+		}
+		conn, reader = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	opts := natsConnect{Verbose: true, TLS: info.TLSRequired, Name: "geoip-audit"}
+	if user := p.url.User; user != nil {
+		opts.User = user.Username()
+		opts.Pass, _ = user.Password()
+	}
+	options, err := json.Marshal(opts)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	writer := bufio.NewWriter(conn)
+	conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	fmt.Fprintf(writer, "CONNECT %s\r\n", options)
+	if err := writer.Flush(); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send nats connect: %w", err)
+	}
+	// The server acknowledges CONNECT, or rejects it, e.g. for bad
+	// credentials, before any publish is sent.
+	conn.SetReadDeadline(time.Now().Add(natsTimeout))
+	line, err = reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "+OK") {
+		conn.Close()
+		return fmt.Errorf("nats connect rejected: %q: %v", strings.TrimSpace(line), err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	p.conn, p.writer = conn, writer
+	go p.readLoop(conn, reader)
+	return nil
+}
+
+// readLoop answers the server's PINGs and acknowledges pending publishes
+// until conn fails or the server reports an error, then drops conn so the
+// next publish reconnects.
+func (p *natsPublisher) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			p.mutex.Lock()
+			if p.conn == conn {
+				p.closeLocked(fmt.Errorf("nats connection lost: %w", err))
+			}
+			p.mutex.Unlock()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.mutex.Lock()
+			if p.conn == conn {
+				p.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+				p.writer.WriteString("PONG\r\n")
+				p.writer.Flush()
+			}
+			p.mutex.Unlock()
+		case strings.HasPrefix(line, "+OK"):
+			p.mutex.Lock()
+			if p.conn == conn && len(p.pending) > 0 {
+				p.settleLocked(nil)
+			}
+			p.mutex.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			msg := strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))
+			log.Warn().Str("error", msg).Msg("NATS server reported an error")
+			p.mutex.Lock()
+			if p.conn == conn {
+				p.closeLocked(fmt.Errorf("nats server error: %s", msg))
+			}
+			p.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// closeLocked closes the connection and fails the pending publishes with
+// reason.
+func (p *natsPublisher) closeLocked(reason error) error {
+	err := p.conn.Close()
+	p.conn, p.writer, p.watched = nil, nil, nil
+	for len(p.pending) > 0 {
+		p.settleLocked(reason)
+	}
+	return err
+}
+
+// Close waits up to natsTimeout for the pending publishes to be acknowledged,
+// then closes the connection, failing those still pending.
+func (p *natsPublisher) Close() error {
+	p.mutex.Lock()
+	if len(p.pending) > 0 {
+		drained := make(chan struct{})
+		p.drained = drained
+		p.mutex.Unlock()
+		timer := time.NewTimer(natsTimeout)
+		select {
+		case <-drained:
+		case <-timer.C:
+		}
+		timer.Stop()
+		p.mutex.Lock()
+	}
+	defer p.mutex.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	return p.closeLocked(errNATSClosed)
+}
@@ -0,0 +1,93 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// PolicyCheckHandler reports whether a country is allowed by the current
+	// policy, without needing an IP or the database. When the verdict also
+	// depends on the request's IP or record, it reports the check as
+	// indeterminate rather than guessing.
+	PolicyCheckHandler struct{}
+
+	policyCheckResponse struct {
+		Country       string `json:"country"`
+		Allowed       bool   `json:"allowed"`
+		Indeterminate bool   `json:"indeterminate,omitempty"`
+	}
+)
+
+func NewPolicyCheckHandler() *PolicyCheckHandler {
+	return &PolicyCheckHandler{}
+}
+
+func (ph *PolicyCheckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("country")))
+	if !isCountryCode(country) {
+		http.Error(w, "Invalid or missing country parameter", http.StatusBadRequest)
+		return
+	}
+
+	allowed, determinate := checkCountry(country)
+	log.Debug().Str("country", country).Bool("allowed", allowed).Bool("determinate", determinate).Msg("policy check")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policyCheckResponse{
+		Country:       country,
+		Allowed:       allowed,
+		Indeterminate: !determinate,
+	})
+}
+
+// checkCountry judges a request from country the way evaluate does, with the
+// same precedence: the rules file, then -allow-eu-only, then the allow-list,
+// which -allow-url replaces once fetched. determinate is false when the
+// verdict also depends on the request's IP or record; allowed is then false.
+func checkCountry(country string) (allowed, determinate bool) {
+	rs := rules()
+	switch {
+	case rs != nil:
+		allowed, _, determinate = rs.EvaluateCountry(country)
+		if !determinate {
+			return false, false
+		}
+	case allowEUOnly():
+		// The EU membership flag comes from the record, not the country.
+		return false, false
+	default:
+		allowed, _ = countryVerdict(country)
+		// An allow-listed ASN lets a denied country through.
+		if !allowed && len(config.GetAllowedASNs()) > 0 && asnSource != nil {
+			return false, false
+		}
+	}
+	// The geofence denies located requests outside it.
+	if allowed && geofence() != nil {
+		return false, false
+	}
+	return allowed, true
+}
+
+// isCountryCode reports whether code looks like an ISO 3166-1 alpha-2 code.
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
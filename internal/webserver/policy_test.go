@@ -0,0 +1,151 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+)
+
+func TestPolicyCheckHandler(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		expectedStatus int
+		expected       *policyCheckResponse
+	}{
+		{
+			name:           "Allowed country",
+			url:            "/policy/check?country=US",
+			expectedStatus: http.StatusOK,
+			expected:       &policyCheckResponse{Country: "US", Allowed: true},
+		}, {
+			name:           "Lower-case code is normalized",
+			url:            "/policy/check?country=%20us%20",
+			expectedStatus: http.StatusOK,
+			expected:       &policyCheckResponse{Country: "US", Allowed: true},
+		}, {
+			name:           "Denied country",
+			url:            "/policy/check?country=DE",
+			expectedStatus: http.StatusOK,
+			expected:       &policyCheckResponse{Country: "DE", Allowed: false},
+		}, {
+			name:           "Missing country",
+			url:            "/policy/check",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Too long code",
+			url:            "/policy/check?country=USA",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Non-letter code",
+			url:            "/policy/check?country=1A",
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "Wrong method",
+			method:         http.MethodPost,
+			url:            "/policy/check?country=US",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.url, nil)
+			w := httptest.NewRecorder()
+			newAdminMux(&mockGeoIPSource{ready: true}).ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if tc.expected != nil {
+				var got policyCheckResponse
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if got != *tc.expected {
+					t.Errorf("Expected %+v, got %+v", *tc.expected, got)
+				}
+			}
+		})
+	}
+
+	// The check must not be reachable from the public listener.
+	w := httptest.NewRecorder()
+	newMux(&mockGeoIPSource{ready: true}, nil).ServeHTTP(w, httptest.NewRequest("GET", "/policy/check?country=US", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /policy/check to be absent from the public mux, got status %d", w.Code)
+	}
+}
+
+func TestPolicyCheckHandler_Rules(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	// The rules replace -allow, so US is denied.
+	rules = func() *config.RuleSet {
+		return &config.RuleSet{
+			Rules: []config.Rule{
+				{Type: config.RuleTypeCountry, Match: "US", Action: config.RuleActionDeny},
+				{Type: config.RuleTypeCountry, Match: "DE", Action: config.RuleActionAllow},
+				{Type: config.RuleTypeSubdivision, Match: "CA-QC", Action: config.RuleActionDeny},
+				{Type: config.RuleTypeCountry, Match: "CA", Action: config.RuleActionAllow},
+			},
+			Default: config.RuleActionDeny,
+		}
+	}
+
+	for _, want := range []policyCheckResponse{
+		{Country: "US", Allowed: false},
+		{Country: "DE", Allowed: true},
+		{Country: "CA", Indeterminate: true},
+		{Country: "FR", Indeterminate: true},
+	} {
+		w := httptest.NewRecorder()
+		newAdminMux(&mockGeoIPSource{ready: true}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/policy/check?country="+want.Country, nil))
+		var got policyCheckResponse
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestCheckCountry_DependsOnRequest(t *testing.T) {
+	defer resetGlobals()
+	os.Args = []string{"cmd", "--allow=US", "--db=test.db"}
+	if err := config.InitConfig(); err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+
+	allowEUOnly = func() bool { return true }
+	if _, determinate := checkCountry("DE"); determinate {
+		t.Error("Expected -allow-eu-only to make the check indeterminate")
+	}
+	allowEUOnly = origAllowEUOnly
+
+	geofence = func() *config.Geofence { return &config.Geofence{Lat: 52.5, Lon: 13.4, RadiusKm: 50} }
+	if _, determinate := checkCountry("US"); determinate {
+		t.Error("Expected a geofence to make an allowed country indeterminate")
+	}
+	if allowed, determinate := checkCountry("FR"); allowed || !determinate {
+		t.Errorf("Expected a geofence to keep a denied country denied, got allowed=%v determinate=%v", allowed, determinate)
+	}
+}
@@ -0,0 +1,77 @@
+package webserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rateLimiter is a token bucket shared by every request passing through it.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newRateLimiter returns a limiter allowing perSecond requests per second with
+// a burst of the same size, or nil when perSecond is not positive.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(perSecond))
+	return &rateLimiter{
+		rate:   perSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// allow takes a token if one is available. Otherwise it reports how long the
+// caller should wait before the next token becomes available.
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// limitRequests rejects requests with 429 once the limiter is exhausted.
+// A nil limiter lets every request through.
+func limitRequests(l *rateLimiter, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow()
+		if !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			log.Debug().Str("path", r.URL.Path).Int("retry_after", retryAfter).Msg("rate limit exceeded")
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
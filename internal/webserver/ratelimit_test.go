@@ -0,0 +1,81 @@
+package webserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	for i := range 2 {
+		if ok, _ := l.allow(); !ok {
+			t.Fatalf("request %d should be within the burst", i+1)
+		}
+	}
+	ok, wait := l.allow()
+	if ok {
+		t.Fatal("expected the limiter to be exhausted after the burst")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected wait of 500ms, got %v", wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.allow(); !ok {
+		t.Error("expected a token to be refilled after 500ms")
+	}
+}
+
+func TestNewRateLimiter_Disabled(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Errorf("expected nil limiter for zero rate, got %+v", l)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if got := limitRequests(nil, handler); got == nil {
+		t.Error("expected the handler to be returned unchanged for a nil limiter")
+	}
+}
+
+func TestLookupRateLimit_IndependentOfAuth(t *testing.T) {
+	defer resetGlobals()
+	isExcluded = func(ip net.IP, excluded []*net.IPNet) bool { return true }
+	getIPFromRequest = func(r *http.Request) net.IP { return net.ParseIP("10.0.0.1") }
+
+	source := &mockGeoIPSource{ready: true}
+	limiter := newRateLimiter(1)
+	limiter.now = func() time.Time { return limiter.last } // freeze refills
+	mux := newMux(source, limiter)
+
+	req := httptest.NewRequest("GET", "/lookup?ip=10.0.0.1", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first lookup should pass, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/lookup/anything", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the lookup limiter is exhausted, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After of 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	for i := range 5 {
+		req := httptest.NewRequest("GET", "/auth", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("/auth request %d should not be throttled, got %d", i+1, w.Code)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package webserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// readinessGate adds hysteresis to /ready so a brief reader swap does not
+// make orchestrators churn. Unready is only reported once the source has
+// been unready for debounce, and after that recoverChecks consecutive ready
+// checks are needed before ready is reported again.
+type readinessGate struct {
+	mutex         sync.Mutex
+	debounce      time.Duration
+	recoverChecks int
+	now           func() time.Time
+
+	reported     bool
+	everReady    bool
+	unreadySince time.Time
+	successes    int
+}
+
+func newReadinessGate(debounce time.Duration, recoverChecks int) *readinessGate {
+	return &readinessGate{
+		debounce:      debounce,
+		recoverChecks: recoverChecks,
+		now:           time.Now,
+	}
+}
+
+// check records the source's current readiness and returns what to report.
+// The very first transition to ready is reported immediately.
+func (g *readinessGate) check(ready bool) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if ready {
+		g.unreadySince = time.Time{}
+		if g.reported {
+			return true
+		}
+		g.successes++
+		if !g.everReady || g.successes >= g.recoverChecks {
+			g.reported, g.everReady, g.successes = true, true, 0
+			log.Info().Msg("readiness reported as ready")
+		}
+		return g.reported
+	}
+
+	g.successes = 0
+	if !g.reported {
+		return false
+	}
+	now := g.now()
+	if g.unreadySince.IsZero() {
+		g.unreadySince = now
+	}
+	if now.Sub(g.unreadySince) >= g.debounce {
+		g.reported = false
+		log.Warn().Dur("unready_for", now.Sub(g.unreadySince)).Msg("readiness reported as not ready")
+	}
+	return g.reported
+}
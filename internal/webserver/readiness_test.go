@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadinessGate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := newReadinessGate(5*time.Second, 2)
+	g.now = func() time.Time { return now }
+
+	if g.check(false) {
+		t.Fatal("expected not ready before the source was ever ready")
+	}
+	if !g.check(true) {
+		t.Fatal("expected the first ready check to report ready")
+	}
+
+	// A transient swap failure shorter than the debounce does not flip.
+	if !g.check(false) {
+		t.Fatal("expected a single unready check to be debounced")
+	}
+	now = now.Add(2 * time.Second)
+	if !g.check(false) {
+		t.Fatal("expected unready within the debounce to stay ready")
+	}
+	if !g.check(true) {
+		t.Fatal("expected ready after the transient failure")
+	}
+
+	// The debounce window restarts after a ready check.
+	now = now.Add(4 * time.Second)
+	if !g.check(false) {
+		t.Fatal("expected the debounce window to restart")
+	}
+
+	// A persistent outage flips after the debounce.
+	now = now.Add(5 * time.Second)
+	if g.check(false) {
+		t.Fatal("expected unready once the outage outlasted the debounce")
+	}
+
+	// Recovering needs two consecutive ready checks.
+	if g.check(true) {
+		t.Fatal("expected one ready check not to be enough to recover")
+	}
+	if g.check(false) {
+		t.Fatal("expected to stay unready")
+	}
+	if g.check(true) {
+		t.Fatal("expected the success count to restart after an unready check")
+	}
+	if !g.check(true) {
+		t.Fatal("expected two consecutive ready checks to recover")
+	}
+}
+
+func TestReadinessGate_NoHysteresis(t *testing.T) {
+	g := newReadinessGate(0, 0)
+	if !g.check(true) || g.check(false) || !g.check(true) {
+		t.Error("expected a zero debounce and recover count to follow the source")
+	}
+}
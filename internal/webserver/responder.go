@@ -0,0 +1,32 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+)
+
+// verdictResponder encodes how a gateway expects /auth verdicts: the status
+// codes of allowed and denied requests, and whether a denial carries a body
+// for the gateway to pass on to the client.
+type verdictResponder struct {
+	allowStatus int
+	denyStatus  int
+	denyBody    bool
+}
+
+// newVerdictResponder returns the responder named by -responder. nginx's
+// auth_request only takes 401 and 403 as denials and discards the body, so
+// its denials carry only X-Country. Traefik's forwardAuth and Envoy's
+// ext_authz hand a denial, body included, to the client. The custom
+// responder uses allowStatus and denyStatus.
+func newVerdictResponder(name string, allowStatus, denyStatus int) verdictResponder {
+	switch name {
+	case config.ResponderNginx:
+		return verdictResponder{allowStatus: http.StatusOK, denyStatus: http.StatusForbidden}
+	case config.ResponderTraefik, config.ResponderEnvoy:
+		return verdictResponder{allowStatus: http.StatusOK, denyStatus: http.StatusForbidden, denyBody: true}
+	default:
+		return verdictResponder{allowStatus: allowStatus, denyStatus: denyStatus, denyBody: true}
+	}
+}
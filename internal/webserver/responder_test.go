@@ -0,0 +1,54 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestVerdictResponder(t *testing.T) {
+	defer resetGlobals()
+	metrics.InitMetrics()
+
+	tests := []struct {
+		name        string
+		responder   string
+		allowStatus int
+		denyStatus  int
+		denyBody    string
+	}{
+		{name: "nginx", responder: config.ResponderNginx, allowStatus: http.StatusOK, denyStatus: http.StatusForbidden},
+		{name: "traefik", responder: config.ResponderTraefik, allowStatus: http.StatusOK, denyStatus: http.StatusForbidden, denyBody: "Forbidden\n"},
+		{name: "envoy", responder: config.ResponderEnvoy, allowStatus: http.StatusOK, denyStatus: http.StatusForbidden, denyBody: "Forbidden\n"},
+		{name: "custom", responder: config.ResponderCustom, allowStatus: http.StatusNoContent, denyStatus: http.StatusUnauthorized, denyBody: "Unauthorized\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// The custom codes must only apply to the custom responder.
+			responder = func() verdictResponder {
+				return newVerdictResponder(tc.responder, http.StatusNoContent, http.StatusUnauthorized)
+			}
+
+			w := httptest.NewRecorder()
+			serveVerdict(w, cacheEntry{allowed: true, country: "US"})
+			if w.Code != tc.allowStatus {
+				t.Errorf("Expected allow status %d, got %d", tc.allowStatus, w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			serveVerdict(w, cacheEntry{country: "RU", reason: reasonCountryNotAllowed})
+			if w.Code != tc.denyStatus {
+				t.Errorf("Expected deny status %d, got %d", tc.denyStatus, w.Code)
+			}
+			if got := w.Body.String(); got != tc.denyBody {
+				t.Errorf("Expected deny body %q, got %q", tc.denyBody, got)
+			}
+			if tc.denyBody == "" && w.Header().Get("X-Country") != "RU" {
+				t.Error("Expected a body-less denial to carry X-Country")
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package webserver
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setReusePort is a net.ListenConfig Control function enabling SO_REUSEPORT.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
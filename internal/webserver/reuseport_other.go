@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package webserver
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("-reuse-port is not supported on this platform")
+}
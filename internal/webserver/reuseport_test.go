@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package webserver
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSetReusePort(t *testing.T) {
+	lc := net.ListenConfig{Control: setReusePort}
+	first, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("first listen failed: %v", err)
+	}
+	defer first.Close()
+
+	second, err := lc.Listen(context.Background(), "tcp", first.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s with SO_REUSEPORT, got %v", first.Addr(), err)
+	}
+	second.Close()
+
+	// Without the option the port stays exclusive.
+	if ln, err := net.Listen("tcp", first.Addr().String()); err == nil {
+		ln.Close()
+		t.Errorf("Expected binding %s without SO_REUSEPORT to fail", first.Addr())
+	}
+}
@@ -1,8 +1,12 @@
 package webserver
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rdwr-valentineg/GeoIP/internal/config"
@@ -12,22 +16,143 @@ import (
 
 type Server struct {
 	Srv *http.Server
+	// Admin serves the operator-only endpoints; nil when no admin port is set.
+	Admin *http.Server
+	// certs serves the TLS certificate of both listeners; nil without TLS.
+	certs *certReloader
+	// grpc serves the gRPC verdict service; nil unless -grpc-addr is set.
+	grpc grpcServer
+	// audit publishes /auth verdicts; nil unless -audit-broker is set.
+	audit *auditLog
+}
+
+// grpcServer is the part of *grpc.Server the Server needs, so builds without
+// gRPC support do not import it.
+type grpcServer interface {
+	GracefulStop()
 }
 
 func Run(source db.GeoIPSource, errCh chan error) *Server {
+	newKeyLimiter.Store(newRateLimiter(config.GetCacheNewKeyRate()))
+	mux := newMux(source, newRateLimiter(config.GetLookupRateLimit()))
+	addr := fmt.Sprintf(":%d", config.GetPort())
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   limitDuration(config.GetRequestTimeout(), limitBody(config.GetMaxRequestBody(), mux)),
+		ConnState: trackConnState,
+	}
+	server := &Server{Srv: srv}
+
+	if broker := config.GetAuditBroker(); broker != "" {
+		publisher, err := newNATSPublisher(broker)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up audit publisher")
+			errCh <- err
+			return server
+		}
+		server.audit = newAuditLog(publisher, config.GetAuditTopic(), config.GetAuditBuffer(), config.GetAuditPolicy())
+		audit.Store(server.audit)
+	}
+
+	if certFile := config.GetTLSCert(); certFile != "" {
+		certs, err := newCertReloader(certFile, config.GetTLSKey())
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load TLS certificate")
+			errCh <- err
+			return server
+		}
+		server.certs = certs
+		srv.TLSConfig = certs.tlsConfig()
+		done := make(chan struct{})
+		srv.RegisterOnShutdown(func() { close(done) })
+		go certs.watch(certWatchInterval, done)
+	}
+	listen(srv, "GeoIP server", errCh)
+
+	if port := config.GetAdminPort(); port != 0 {
+		server.Admin = &http.Server{
+			Addr:    fmt.Sprintf(":%d", port),
+			Handler: limitDuration(config.GetRequestTimeout(), limitBody(config.GetMaxRequestBody(), newAdminMux(source))),
+		}
+		if server.certs != nil {
+			server.Admin.TLSConfig = server.certs.tlsConfig()
+		}
+		listen(server.Admin, "GeoIP admin server", errCh)
+	}
+
+	if addr := config.GetGRPCAddr(); addr != "" {
+		srv, err := serveGRPC(addr, source, errCh)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start gRPC server")
+			errCh <- err
+			return server
+		}
+		server.grpc = srv
+	}
+
+	return server
+}
+
+// StopGRPC gracefully stops the gRPC verdict service, if it runs.
+func (s *Server) StopGRPC() {
+	if s.grpc != nil {
+		s.grpc.GracefulStop()
+	}
+}
+
+// StopAudit publishes the queued audit events and disconnects from the
+// broker, if auditing is enabled.
+func (s *Server) StopAudit() {
+	if s.audit != nil {
+		audit.Store(nil)
+		s.audit.stop()
+	}
+}
+
+// ReloadCertificates re-reads the TLS key pair, e.g. on SIGHUP. It is a no-op
+// when TLS is disabled.
+func (s *Server) ReloadCertificates() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.Reload()
+}
+
+// newMux builds the public handler. lookupLimiter throttles every /lookup*
+// route independently of /auth; nil leaves them unthrottled.
+func newMux(source db.GeoIPSource, lookupLimiter *rateLimiter) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.Handle("/auth", NewAuthHandler(source))
 
+	lookupMux := http.NewServeMux()
+	lookupMux.Handle("/lookup", NewLookupHandler(source))
+	lookupMux.Handle("/lookup/batch", NewBatchLookupHandler(source))
+	lookup := limitRequests(lookupLimiter, lookupMux)
+	mux.Handle("/lookup", lookup)
+	mux.Handle("/lookup/", lookup)
+
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		log.Debug().Msg("/healthz endpoint called")
+		if isDraining() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
 
+	readiness := newReadinessGate(config.GetReadyDebounce(), config.GetReadyRecoverChecks())
 	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		ready := source.IsReady()
+		if !requireConfig(w) {
+			return
+		}
+		ready := readiness.check(source.IsReady())
 		log.Debug().Bool("Ready", ready).Msg("/healthz endpoint called")
+		if acceptsJSON(r) {
+			writeReady(w, ready, source)
+			return
+		}
 		if !ready {
 			log.Warn().Msg("GeoIP database is not ready")
 			http.Error(w, "Service not ready", http.StatusServiceUnavailable)
@@ -36,17 +161,92 @@ func Run(source db.GeoIPSource, errCh chan error) *Server {
 		}
 	})
 
+	mux.Handle("/health/fetch", NewFetchHealthHandler(source))
+
+	mux.HandleFunc("/dbinfo", func(w http.ResponseWriter, r *http.Request) {
+		log.Debug().Msg("/dbinfo endpoint called")
+		if !source.IsReady() {
+			http.Error(w, "GeoIP DB not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(source.Info())
+	})
+
 	mux.Handle("/metrics", promhttp.Handler())
-	addr := fmt.Sprintf(":%d", config.GetPort())
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+	return mux
+}
+
+// readyResponse is the /ready body for clients that accept JSON.
+type readyResponse struct {
+	Ready      bool   `json:"ready"`
+	DBType     string `json:"db_type,omitempty"`
+	BuildEpoch uint   `json:"build_epoch,omitempty"`
+}
+
+// acceptsJSON reports whether the client asked for a JSON response.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeReady answers /ready with the readiness and the loaded database's
+// edition and build, so a probe or operator needs no separate /dbinfo call.
+func writeReady(w http.ResponseWriter, ready bool, source db.GeoIPSource) {
+	resp := readyResponse{Ready: ready}
+	if ready {
+		info := source.Info()
+		resp.DBType, resp.BuildEpoch = info.DatabaseType, info.BuildEpoch
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
+	json.NewEncoder(w).Encode(resp)
+}
 
+// newAdminMux builds the handler for endpoints that must only be reachable
+// from the admin listener.
+func newAdminMux(source db.GeoIPSource) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/auth/dryrun", NewDryRunHandler(source))
+	mux.Handle("/policy/check", NewPolicyCheckHandler())
+	mux.Handle("/admin/fetch-interval", NewFetchIntervalHandler(source))
+	mux.Handle("/debug/lookup", NewDebugLookupHandler(source))
+	mux.Handle("/db/download", NewDBDownloadHandler(source))
+	return mux
+}
+
+// listenTCP binds addr, with SO_REUSEPORT when -reuse-port is set so a new
+// process can bind the same port while the old one drains.
+func listenTCP(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if config.GetReusePort() {
+		lc.Control = setReusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func listen(srv *http.Server, name string, errCh chan error) {
 	go func() {
-		fmt.Printf("Starting GeoIP server on %s\n", addr)
-		log.Info().Str("addr", addr).Msg("GeoIP server listening")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("Starting %s on %s\n", name, srv.Addr)
+		log.Info().Str("addr", srv.Addr).Msgf("%s listening", name)
+		ln, err := listenTCP(srv.Addr)
+		if err == nil {
+			if srv.TLSConfig != nil {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+		}
+		if err != nil && err != http.ErrServerClosed {
 			fmt.Printf("HTTP server error: %v\n", err)
 			log.Error().Err(err).Msg("HTTP server error")
 			errCh <- err
@@ -54,6 +254,4 @@ func Run(source db.GeoIPSource, errCh chan error) *Server {
 			errCh <- nil
 		}
 	}()
-
-	return &Server{Srv: srv}
 }
@@ -1,12 +1,14 @@
 package webserver
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/rdwr-valentineg/GeoIP/internal/config"
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
 )
 
 func TestRun(t *testing.T) {
@@ -54,6 +56,91 @@ func TestRun(t *testing.T) {
 		})
 	}
 
+	t.Run("Ready endpoint without config", func(t *testing.T) {
+		defer resetGlobals()
+		configLoaded = func() bool { return false }
+		mux := newMux(&mockGeoIPSource{ready: true}, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status %d without config, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+	})
+
+	t.Run("Ready endpoint with JSON", func(t *testing.T) {
+		info := db.DBInfo{DatabaseType: "GeoLite2-Country", BuildEpoch: 1700000000}
+		tests := []struct {
+			name   string
+			accept string
+			ready  bool
+			status int
+			body   string
+		}{
+			{name: "plain probe", accept: "", ready: true, status: http.StatusOK, body: ""},
+			{name: "other media type", accept: "text/plain", ready: true, status: http.StatusOK, body: ""},
+			{
+				name:   "json ready",
+				accept: "text/html, application/json;q=0.9",
+				ready:  true,
+				status: http.StatusOK,
+				body:   `{"ready":true,"db_type":"GeoLite2-Country","build_epoch":1700000000}` + "\n",
+			},
+			{
+				name:   "json not ready",
+				accept: "application/json",
+				ready:  false,
+				status: http.StatusServiceUnavailable,
+				body:   `{"ready":false}` + "\n",
+			},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				mux := newMux(&mockGeoIPSource{ready: tc.ready, info: info}, nil)
+				req := httptest.NewRequest("GET", "/ready", nil)
+				if tc.accept != "" {
+					req.Header.Set("Accept", tc.accept)
+				}
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, req)
+				if w.Code != tc.status {
+					t.Errorf("Expected status %d, got %d", tc.status, w.Code)
+				}
+				if tc.body != "" && w.Body.String() != tc.body {
+					t.Errorf("Expected body %q, got %q", tc.body, w.Body.String())
+				}
+				if tc.body == "" && tc.ready && w.Body.Len() != 0 {
+					t.Errorf("Expected an empty body for a plain probe, got %q", w.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("DB info endpoint", func(t *testing.T) {
+		info := db.DBInfo{DatabaseType: "GeoLite2-Country", BuildEpoch: 1700000000}
+		mux := newMux(&mockGeoIPSource{ready: true, info: info}, nil)
+
+		req := httptest.NewRequest("GET", "/dbinfo", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var got db.DBInfo
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if got != info {
+			t.Errorf("Expected %+v, got %+v", info, got)
+		}
+
+		mux = newMux(&mockGeoIPSource{ready: false}, nil)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/dbinfo", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status %d when not ready, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+	})
+
 	// Simulate ListenAndServe error by passing a faulty address or handler
 	t.Run("ListenAndServe error", func(t *testing.T) {
 		config.InitConfig()
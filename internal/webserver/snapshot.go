@@ -0,0 +1,138 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/utils"
+)
+
+// cacheSnapshotVersion is bumped whenever the snapshot layout changes, so an
+// older file is ignored instead of misread.
+const cacheSnapshotVersion = 2
+
+type (
+	// cacheSnapshot is the on-disk form of the verdict cache. BuildEpoch is
+	// the database the verdicts were computed against and Policy the
+	// config.PolicyFingerprint they were decided under.
+	cacheSnapshot struct {
+		Version    int             `json:"version"`
+		BuildEpoch uint            `json:"build_epoch"`
+		Policy     string          `json:"policy"`
+		Entries    []snapshotEntry `json:"entries"`
+	}
+
+	snapshotEntry struct {
+		Key        string            `json:"key"`
+		Allowed    bool              `json:"allowed"`
+		Country    string            `json:"country,omitempty"`
+		Reason     string            `json:"reason,omitempty"`
+		TimeZone   string            `json:"time_zone,omitempty"`
+		Names      map[string]string `json:"names,omitempty"`
+		Network    string            `json:"network,omitempty"`
+		DistanceKm float64           `json:"distance_km,omitempty"`
+		Geofenced  bool              `json:"geofenced,omitempty"`
+		InEU       bool              `json:"in_eu,omitempty"`
+		EUKnown    bool              `json:"eu_known,omitempty"`
+		StoredAt   time.Time         `json:"stored_at"`
+	}
+)
+
+// SaveCacheSnapshot writes the verdict cache to path, tagged with the build
+// epoch of the database that produced it and the current policy. The file is
+// replaced atomically.
+func SaveCacheSnapshot(path string, buildEpoch uint) (int, error) {
+	snapshot := cacheSnapshot{Version: cacheSnapshotVersion, BuildEpoch: buildEpoch, Policy: policyFingerprint()}
+	cacheMux.RLock()
+	snapshot.Entries = make([]snapshotEntry, 0, len(geoCache))
+	for key, entry := range geoCache {
+		snapshot.Entries = append(snapshot.Entries, snapshotEntry{
+			Key:        key,
+			Allowed:    entry.allowed,
+			Country:    entry.country,
+			Reason:     entry.reason,
+			TimeZone:   entry.timeZone,
+			Names:      entry.names,
+			Network:    entry.network,
+			DistanceKm: entry.distanceKm,
+			Geofenced:  entry.geofenced,
+			InEU:       entry.inEU,
+			EUKnown:    entry.euKnown,
+			StoredAt:   entry.storedAt,
+		})
+	}
+	cacheMux.RUnlock()
+
+	out, tmpPath, err := utils.CreateTempFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.NewEncoder(out).Encode(snapshot); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := utils.AtomicReplaceFile(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return len(snapshot.Entries), nil
+}
+
+// LoadCacheSnapshot fills the verdict cache from the snapshot at path and
+// returns the number of entries restored. A snapshot of another version,
+// database build or policy is ignored, since its verdicts may no longer hold,
+// and entries older than -cache-ttl are dropped. A missing file restores
+// nothing.
+func LoadCacheSnapshot(path string, buildEpoch uint) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("invalid cache snapshot: %w", err)
+	}
+	if snapshot.Version != cacheSnapshotVersion {
+		return 0, fmt.Errorf("cache snapshot version %d is not supported", snapshot.Version)
+	}
+	if snapshot.BuildEpoch != buildEpoch {
+		return 0, fmt.Errorf("cache snapshot is from database build %d, not %d", snapshot.BuildEpoch, buildEpoch)
+	}
+	if snapshot.Policy != policyFingerprint() {
+		return 0, errors.New("cache snapshot was taken under a different policy")
+	}
+
+	ttl := cacheTTL()
+	restored := 0
+	for _, e := range snapshot.Entries {
+		if ttl > 0 && time.Since(e.StoredAt) > ttl {
+			continue
+		}
+		storeVerdict(e.Key, cacheEntry{
+			allowed:    e.Allowed,
+			country:    e.Country,
+			reason:     e.Reason,
+			timeZone:   e.TimeZone,
+			names:      e.Names,
+			network:    e.Network,
+			distanceKm: e.DistanceKm,
+			geofenced:  e.Geofenced,
+			inEU:       e.InEU,
+			euKnown:    e.EUKnown,
+			storedAt:   e.StoredAt,
+		})
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshot_RoundTrip(t *testing.T) {
+	defer resetGlobals()
+	path := filepath.Join(t.TempDir(), "cache.json")
+	storedAt := time.Now().Add(-time.Minute).Round(0)
+	entry := cacheEntry{
+		allowed:  true,
+		country:  "US",
+		reason:   reasonCountryAllowed,
+		timeZone: "America/Chicago",
+		names:    map[string]string{"en": "United States"},
+		network:  "1.2.3.0/24",
+		storedAt: storedAt,
+	}
+	storeVerdict("1.2.3.4", entry)
+	storeVerdict("5.6.7.8", cacheEntry{country: "DE", reason: reasonCountryNotAllowed, storedAt: storedAt})
+
+	if saved, err := SaveCacheSnapshot(path, 1700000000); err != nil || saved != 2 {
+		t.Fatalf("SaveCacheSnapshot = %d, %v; want 2 entries", saved, err)
+	}
+	CacheCleanup()
+
+	restored, err := LoadCacheSnapshot(path, 1700000000)
+	if err != nil || restored != 2 {
+		t.Fatalf("LoadCacheSnapshot = %d, %v; want 2 entries", restored, err)
+	}
+	got := geoCache["1.2.3.4"]
+	if !got.storedAt.Equal(storedAt) {
+		t.Errorf("Expected storedAt %v, got %v", storedAt, got.storedAt)
+	}
+	got.storedAt = entry.storedAt
+	if !reflect.DeepEqual(got, entry) {
+		t.Errorf("Expected %+v, got %+v", entry, got)
+	}
+	if cacheBytes == 0 {
+		t.Error("Expected restored entries to count towards the cache size")
+	}
+}
+
+func TestCacheSnapshot_Invalidation(t *testing.T) {
+	defer resetGlobals()
+	path := filepath.Join(t.TempDir(), "cache.json")
+	storeVerdict("1.2.3.4", cacheEntry{allowed: true, country: "US", storedAt: time.Now().Add(-time.Hour)})
+	storeVerdict("5.6.7.8", cacheEntry{allowed: true, country: "US", storedAt: time.Now()})
+	if _, err := SaveCacheSnapshot(path, 1700000000); err != nil {
+		t.Fatalf("SaveCacheSnapshot failed: %v", err)
+	}
+	CacheCleanup()
+
+	// A new database build may change verdicts, so the snapshot is dropped.
+	if restored, err := LoadCacheSnapshot(path, 1800000000); err == nil || restored != 0 || len(geoCache) != 0 {
+		t.Errorf("Expected a snapshot of another build to be ignored, got %d entries, %v", restored, err)
+	}
+
+	// A changed allow-list, rules or enforcement may change verdicts too.
+	policyFingerprint = func() string { return "other-policy" }
+	if restored, err := LoadCacheSnapshot(path, 1700000000); err == nil || restored != 0 || len(geoCache) != 0 {
+		t.Errorf("Expected a snapshot of another policy to be ignored, got %d entries, %v", restored, err)
+	}
+	policyFingerprint = origPolicy
+
+	// Entries older than the TTL are dropped.
+	cacheTTL = func() time.Duration { return time.Minute }
+	if restored, err := LoadCacheSnapshot(path, 1700000000); err != nil || restored != 1 {
+		t.Errorf("Expected only the fresh entry to be restored, got %d, %v", restored, err)
+	}
+	if _, ok := geoCache["1.2.3.4"]; ok {
+		t.Error("Expected the expired entry to be dropped")
+	}
+
+	// A missing snapshot is a cold start, not an error.
+	if restored, err := LoadCacheSnapshot(filepath.Join(t.TempDir(), "missing.json"), 1700000000); err != nil || restored != 0 {
+		t.Errorf("Expected a missing snapshot to restore nothing, got %d, %v", restored, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 99, "build_epoch": 1700000000}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCacheSnapshot(path, 1700000000); err == nil {
+		t.Error("Expected a snapshot of another version to be rejected")
+	}
+}
@@ -0,0 +1,31 @@
+package webserver
+
+import (
+	"net/http"
+	"time"
+)
+
+// untimedPaths are exempt from -request-timeout: scrapes and streamed
+// downloads legitimately outlast a verdict-sized budget, and TimeoutHandler
+// would buffer the whole download.
+var untimedPaths = map[string]bool{
+	"/metrics":     true,
+	"/db/download": true,
+}
+
+// limitDuration answers 503 for any request next has not finished within
+// timeout, including time spent waiting on rate limiters and writing the
+// response. 0 disables the limit.
+func limitDuration(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	timed := http.TimeoutHandler(next, timeout, "Request timed out")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if untimedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timed.ServeHTTP(w, r)
+	})
+}
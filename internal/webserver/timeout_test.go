@@ -0,0 +1,43 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitDuration(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		path    string
+		want    int
+	}{
+		{name: "slow request times out", timeout: 20 * time.Millisecond, path: "/auth", want: http.StatusServiceUnavailable},
+		{name: "metrics is exempt", timeout: 20 * time.Millisecond, path: "/metrics", want: http.StatusOK},
+		{name: "download is exempt", timeout: 20 * time.Millisecond, path: "/db/download", want: http.StatusOK},
+		{name: "disabled", timeout: 0, path: "/auth", want: http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			limitDuration(tc.timeout, slow).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if w.Code != tc.want {
+				t.Fatalf("Expected %d, got %d", tc.want, w.Code)
+			}
+			if tc.want == http.StatusServiceUnavailable && !strings.Contains(w.Body.String(), "timed out") {
+				t.Errorf("Expected a timeout message, got %q", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// certWatchInterval is how often the certificate files are checked for
+// changes, e.g. after cert-manager rotated them.
+var certWatchInterval = 30 * time.Second
+
+// certReloader serves a certificate that can be swapped without restarting
+// the listener. New handshakes pick up the current certificate.
+type certReloader struct {
+	certPath string
+	keyPath  string
+	cert     atomic.Pointer[tls.Certificate]
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	cr := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload loads the key pair from disk. The current certificate is kept when
+// the new one fails to load.
+func (cr *certReloader) Reload() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+	cr.cert.Store(&cert)
+	cr.modTime = modTime
+	return nil
+}
+
+// tlsConfig returns a server TLS config that always serves the current
+// certificate.
+func (cr *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+	}
+}
+
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// reloadIfChanged reloads the key pair when either file was modified since
+// the last successful load, and reports whether it did.
+func (cr *certReloader) reloadIfChanged() (bool, error) {
+	cr.mu.Lock()
+	modTime, err := cr.latestModTime()
+	changed := err == nil && modTime.After(cr.modTime)
+	cr.mu.Unlock()
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, cr.Reload()
+}
+
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certPath, cr.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// watch polls the certificate files every interval until done is closed.
+func (cr *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			reloaded, err := cr.reloadIfChanged()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to reload TLS certificate")
+			} else if reloaded {
+				log.Info().Msg("TLS certificate reloaded")
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed key pair with the given serial number.
+func writeTestCert(t *testing.T, certPath, keyPath string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+// handshakeSerial dials addr and returns the serial of the served certificate.
+func handshakeSerial(t *testing.T, addr string) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCert(t, certPath, keyPath, 1)
+
+	certs, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("newCertReloader failed: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = tls.NewListener(server.Listener, certs.tlsConfig())
+	server.Start()
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	if serial := handshakeSerial(t, addr); serial != 1 {
+		t.Fatalf("Expected the initial certificate, got serial %d", serial)
+	}
+
+	t.Run("Reload swaps the certificate", func(t *testing.T) {
+		writeTestCert(t, certPath, keyPath, 2)
+		if err := certs.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		if serial := handshakeSerial(t, addr); serial != 2 {
+			t.Errorf("Expected new handshakes to use the new certificate, got serial %d", serial)
+		}
+	})
+
+	t.Run("Failed reload keeps the current certificate", func(t *testing.T) {
+		if err := os.WriteFile(certPath, []byte("not a certificate"), 0o600); err != nil {
+			t.Fatalf("failed to corrupt certificate: %v", err)
+		}
+		if err := certs.Reload(); err == nil {
+			t.Fatal("Expected Reload to fail on an invalid certificate")
+		}
+		if serial := handshakeSerial(t, addr); serial != 2 {
+			t.Errorf("Expected the previous certificate to be kept, got serial %d", serial)
+		}
+	})
+
+	t.Run("File change is picked up", func(t *testing.T) {
+		writeTestCert(t, certPath, keyPath, 3)
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(certPath, future, future)
+		reloaded, err := certs.reloadIfChanged()
+		if err != nil || !reloaded {
+			t.Fatalf("Expected a reload after the files changed, got reloaded=%v err=%v", reloaded, err)
+		}
+		if serial := handshakeSerial(t, addr); serial != 3 {
+			t.Errorf("Expected the changed certificate, got serial %d", serial)
+		}
+		if reloaded, _ := certs.reloadIfChanged(); reloaded {
+			t.Error("Expected no reload when the files are unchanged")
+		}
+	})
+}
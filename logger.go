@@ -7,7 +7,12 @@ import (
 )
 
 func InitLogger() {
-	loglevel := config.GetLogLevel()
+	setLogLevel(config.GetLogLevel(), config.GetStrictLogLevel())
+}
+
+// setLogLevel applies loglevel to the global logger. An unknown level falls
+// back to info with a warning, or exits when strict is set.
+func setLogLevel(loglevel string, strict bool) {
 	switch loglevel {
 	case "none":
 		log.Logger = log.Output(zerolog.Nop())
@@ -18,6 +23,10 @@ func InitLogger() {
 	case "debug":
 		log.Logger = log.Level(zerolog.DebugLevel)
 	default:
-		log.Fatal().Msgf("Unknown log level: %s", loglevel)
+		if strict {
+			log.Fatal().Msgf("Unknown log level: %s", loglevel)
+		}
+		log.Logger = log.Level(zerolog.InfoLevel)
+		log.Warn().Str("level", loglevel).Msg("Unknown log level, falling back to info")
 	}
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestSetLogLevel_UnknownFallsBackToInfo(t *testing.T) {
+	origLogger := log.Logger
+	defer func() { log.Logger = origLogger }()
+
+	var buf bytes.Buffer
+	log.Logger = zerolog.New(&buf)
+	setLogLevel("verbose", false)
+
+	if level := log.Logger.GetLevel(); level != zerolog.InfoLevel {
+		t.Errorf("Expected the info level, got %v", level)
+	}
+	if !strings.Contains(buf.String(), "Unknown log level") {
+		t.Errorf("Expected a warning about the unknown level, got %q", buf.String())
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"math/rand/v2"
 	"os"
 	"os/signal"
 	"syscall"
@@ -20,15 +21,138 @@ type (
 	}
 )
 
-func clearCachePeriodically(interval time.Duration) {
+// purgeDelay returns interval plus a random delay in [0, jitter), so the
+// purges of replicas started together drift apart.
+func purgeDelay(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + rand.N(jitter)
+}
+
+// clearCachePeriodically purges the verdict cache every interval, each purge
+// delayed by up to jitter, until ctx is cancelled. The returned channel is
+// closed once the purge goroutine exits.
+func clearCachePeriodically(ctx context.Context, interval, jitter time.Duration) <-chan struct{} {
+	timer := time.NewTimer(purgeDelay(interval, jitter))
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				timer.Reset(purgeDelay(interval, jitter))
+				evicted := webserver.CacheCleanup()
+				metrics.CacheEvictions.Add(float64(evicted))
+				log.Debug().Int("evicted entries", evicted).Msg("Cache cleared")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stopped
+}
+
+// updateDBAge sets the database age gauge to the time between the serving
+// database's build and now. It is left alone while the DB is not ready.
+func updateDBAge(source db.GeoIPSource, now time.Time) {
+	if !source.IsReady() {
+		return
+	}
+	built := time.Unix(int64(source.Info().BuildEpoch), 0)
+	metrics.DBAge.Set(now.Sub(built).Seconds())
+}
+
+// refreshDBAge updates the database age gauge every interval, reading the
+// time from now, until ctx is cancelled. The age grows between fetches, so it
+// cannot be set only when the database changes. The returned channel is
+// closed once the refresh goroutine exits.
+func refreshDBAge(ctx context.Context, source db.GeoIPSource, interval time.Duration, now func() time.Time) <-chan struct{} {
+	ticker := time.NewTicker(interval)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		updateDBAge(source, now())
+		for {
+			select {
+			case <-ticker.C:
+				updateDBAge(source, now())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stopped
+}
+
+// reloadAllowList fetches the allow-list once and purges the verdict cache
+// when it changed, so cached verdicts of the old list are not served.
+func reloadAllowList(ctx context.Context, fetcher *config.AllowListFetcher) {
+	changed, err := fetcher.Fetch(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reload allow-list, keeping the previous one")
+		return
+	}
+	if !changed {
+		return
+	}
+	evicted := webserver.PurgeCache()
+	metrics.CacheEvictions.Add(float64(evicted))
+	log.Info().Int("evicted entries", evicted).Msg("Allow-list reloaded from URL")
+}
+
+// pollAllowList reloads the allow-list now and then every interval until ctx
+// is cancelled. The returned channel is closed once the poll goroutine exits.
+func pollAllowList(ctx context.Context, fetcher *config.AllowListFetcher, interval time.Duration) <-chan struct{} {
 	ticker := time.NewTicker(interval)
+	stopped := make(chan struct{})
 	go func() {
-		for range ticker.C {
-			evicted := webserver.CacheCleanup()
-			metrics.CacheEvictions.Add(float64(evicted))
-			log.Debug().Int("evicted entries", evicted).Msg("Cache cleared")
+		defer close(stopped)
+		defer ticker.Stop()
+		reloadAllowList(ctx, fetcher)
+		for {
+			select {
+			case <-ticker.C:
+				reloadAllowList(ctx, fetcher)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
+	return stopped
+}
+
+// restoreCacheSnapshot warms the verdict cache from path. It is skipped while
+// the DB is not ready, since the snapshot's build cannot be checked yet.
+func restoreCacheSnapshot(source db.GeoIPSource, path string) {
+	if path == "" {
+		return
+	}
+	if !source.IsReady() {
+		log.Warn().Str("path", path).Msg("DB not ready, cache snapshot not restored")
+		return
+	}
+	restored, err := webserver.LoadCacheSnapshot(path, source.Info().BuildEpoch)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Cache snapshot not restored")
+		return
+	}
+	log.Info().Int("entries", restored).Str("path", path).Msg("Cache snapshot restored")
+}
+
+// saveCacheSnapshot writes the verdict cache to path for the next start.
+func saveCacheSnapshot(source db.GeoIPSource, path string) {
+	if path == "" || !source.IsReady() {
+		return
+	}
+	saved, err := webserver.SaveCacheSnapshot(path, source.Info().BuildEpoch)
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to save cache snapshot")
+		return
+	}
+	log.Info().Int("entries", saved).Str("path", path).Msg("Cache snapshot saved")
 }
 
 func main() {
@@ -38,27 +162,62 @@ func main() {
 	}
 
 	InitLogger()
+	config.LogEffective()
 
 	var source db.GeoIPSource
 	switch {
-	case config.GetMaxMindLicenseKey() != "":
+	case config.GetMaxMindLicenseKey() != "" || config.GetDbURL() != "":
 		log.Debug().Msg("Using MaxMind remote fetcher")
 		source = db.NewRemoteFetcher(db.Config{
-			AccountID:   config.GetMaxMindAccountId(),
-			LicenseKey:  config.GetMaxMindLicenseKey(),
-			DBPath:      config.GetDbPath(),
-			Interval:    config.GetMaxMindFetchInterval(),
-			Timeout:     config.GetFetcherTimeout(),
-			MaxRetries:  config.GetFetcherMaxRetries(),
-			BaseBackoff: config.GetFetcherBaseBackoff(),
+			Name:             config.GetFetchSourceName(),
+			AccountID:        config.GetMaxMindAccountId(),
+			LicenseKey:       config.GetMaxMindLicenseKey(),
+			DBPath:           config.GetDbPath(),
+			Storage:          config.GetDbStorage(),
+			Mmap:             config.GetDbMmap(),
+			FileLock:         config.GetDbFileLock(),
+			UpdateWebhook:    config.GetUpdateWebhook(),
+			Interval:         config.GetMaxMindFetchInterval(),
+			Timeout:          config.GetFetcherTimeout(),
+			StopTimeout:      config.GetFetcherStopTimeout(),
+			MaxRetries:       config.GetFetcherMaxRetries(),
+			BaseBackoff:      config.GetFetcherBaseBackoff(),
+			URL:              config.GetDbURL(),
+			URLAuth:          config.GetDbURLAuth(),
+			BreakerThreshold: config.GetFetchBreakerThreshold(),
+			BreakerCooldown:  config.GetFetchBreakerCooldown(),
+			UnhealthyAfter:   config.GetFetchUnhealthyAfter(),
+			ExtractAnyMMDB:   config.GetExtractAnyMMDB(),
+			ExpectedDBType:   config.GetExpectedDBType(),
+			StrictDBType:     config.GetStrictDBType(),
 		})
 	case config.GetDbPath() != "":
 		log.Debug().Msg("Using MaxMind local fetcher")
-		source = db.NewDiskLoader(config.GetDbPath())
+		loader := db.NewDiskLoader(config.GetDbPath())
+		loader.ExpectedDBType = config.GetExpectedDBType()
+		loader.StrictDBType = config.GetStrictDBType()
+		loader.FileLock = config.GetDbFileLock()
+		source = loader
 	default:
-		log.Fatal().Msg("Either --db-path or --maxmind-license-key must be provided")
+		log.Fatal().Msg("Either --db-path, --db-url or --maxmind-license-key must be provided")
+	}
+
+	if interval := config.GetIntegrityInterval(); interval > 0 {
+		source = db.WithIntegrityCheck(source, interval)
 	}
 
+	if config.GetEnableFallbackDB() {
+		fallback, err := db.WithFallback(source)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load fallback DB")
+		}
+		source = fallback
+	}
+
+	metrics.SetNamespace(config.GetMetricsNamespace())
+	metrics.InitMetrics()
+	metrics.SetTopCountries(config.GetMetricsTopCountries(), config.GetMetricsTopWindow())
+	metrics.SetCacheOldestAgeFunc(webserver.CacheOldestEntryAge)
 	if err := source.Start(); err != nil {
 		log.Fatal().Err(err).Msg("Failed to start DB source")
 	}
@@ -66,14 +225,58 @@ func main() {
 
 	defer source.Stop()
 
-	metrics.InitMetrics()
-	clearCachePeriodically(config.GetCachePurgePeriod())
-	errCh := make(chan error, 1)
+	if path := config.GetASNDbPath(); path != "" {
+		asn := db.NewDiskLoader(path)
+		asn.ExpectedDBType = "ASN"
+		asn.StrictDBType = true
+		if err := asn.Start(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load ASN DB")
+		}
+		defer asn.Stop()
+		webserver.SetASNSource(asn)
+	}
+
+	if err := runSelfTest(source, config.GetSelfTestIPs(), config.GetRequireSelfTest(), config.GetSelfTestTimeout()); err != nil {
+		log.Fatal().Err(err).Msg("Startup self-test failed")
+	}
+	restoreCacheSnapshot(source, config.GetCacheSnapshot())
+
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	purgeStopped := clearCachePeriodically(purgeCtx, config.GetCachePurgePeriod(), config.GetCachePurgeJitter())
+	ageCtx, stopAge := context.WithCancel(context.Background())
+	defer stopAge()
+	var ageStopped <-chan struct{}
+	if interval := config.GetDBAgeInterval(); interval > 0 {
+		ageStopped = refreshDBAge(ageCtx, source, interval, time.Now)
+	}
+	allowCtx, stopAllow := context.WithCancel(context.Background())
+	defer stopAllow()
+	var allowStopped <-chan struct{}
+	if url := config.GetAllowURL(); url != "" {
+		allowStopped = pollAllowList(allowCtx, config.NewAllowListFetcher(url), config.GetAllowURLInterval())
+	}
+	errCh := make(chan error, 3)
 	s := webserver.Run(source, errCh)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to start web server")
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.ReloadCertificates(); err != nil {
+				log.Error().Err(err).Msg("Failed to reload TLS certificate")
+			}
+			if err := config.ReloadIPOverrides(); err != nil {
+				log.Error().Err(err).Msg("Failed to reload IP overrides")
+				continue
+			}
+			log.Info().Msg("IP overrides reloaded")
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	select {
@@ -87,11 +290,36 @@ func main() {
 		log.Error().Err(err).Msg("Server error")
 	}
 
+	if period := config.GetDrainPeriod(); period > 0 {
+		log.Info().Dur("period", period).Msg("Draining before shutdown")
+		webserver.Drain()
+		time.Sleep(period)
+	}
+
+	stopPurge()
+	<-purgeStopped
+	if ageStopped != nil {
+		stopAge()
+		<-ageStopped
+	}
+	if allowStopped != nil {
+		stopAllow()
+		<-allowStopped
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := s.Srv.Shutdown(ctx); err != nil {
 		log.Err(err).Msg("Shutdown failed")
 	}
+	if s.Admin != nil {
+		if err := s.Admin.Shutdown(ctx); err != nil {
+			log.Err(err).Msg("Admin shutdown failed")
+		}
+	}
+	s.StopGRPC()
+	s.StopAudit()
+	saveCacheSnapshot(source, config.GetCacheSnapshot())
 	log.Info().Msg("Server gracefully stopped")
 }
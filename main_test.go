@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rdwr-valentineg/GeoIP/internal/metrics"
+)
+
+func TestClearCachePeriodically_StopsOnCancel(t *testing.T) {
+	metrics.InitMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := clearCachePeriodically(ctx, 5*time.Millisecond, time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // let a few purges run
+
+	select {
+	case <-stopped:
+		t.Fatal("purge goroutine exited before the context was cancelled")
+	default:
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("purge goroutine did not exit after the context was cancelled")
+	}
+}
+
+func TestRefreshDBAge(t *testing.T) {
+	metrics.Reset()
+	built := time.Unix(1_700_000_000, 0)
+	var clock atomic.Int64
+	clock.Store(built.Add(time.Hour).UnixNano())
+	now := func() time.Time { return time.Unix(0, clock.Load()) }
+	source := &stubSource{ready: true, info: db.DBInfo{BuildEpoch: uint(built.Unix())}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := refreshDBAge(ctx, source, 5*time.Millisecond, now)
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	waitForAge := func(want float64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for testutil.ToFloat64(metrics.DBAge) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected a db age of %v, got %v", want, testutil.ToFloat64(metrics.DBAge))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForAge(time.Hour.Seconds())
+	// Without a fetch the age keeps growing with the clock.
+	clock.Add(int64(time.Minute))
+	waitForAge((time.Hour + time.Minute).Seconds())
+}
+
+func TestPurgeDelay(t *testing.T) {
+	interval, jitter := time.Minute, 10*time.Second
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		delay := purgeDelay(interval, jitter)
+		if delay < interval || delay >= interval+jitter {
+			t.Fatalf("Expected a delay in [%v, %v), got %v", interval, interval+jitter, delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Expected the purge delay to vary")
+	}
+
+	if delay := purgeDelay(interval, 0); delay != interval {
+		t.Errorf("Expected no jitter to keep the interval, got %v", delay)
+	}
+}
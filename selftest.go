@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+	"github.com/rdwr-valentineg/GeoIP/internal/webserver"
+	"github.com/rs/zerolog/log"
+)
+
+// selfTestPollInterval is how often the self-test checks source readiness.
+var selfTestPollInterval = 100 * time.Millisecond
+
+// runSelfTest waits up to timeout for the source to become ready, then looks
+// up every sanity IP and logs the country /auth resolves for it. Failures are
+// only logged unless required is set, in which case the first one is
+// returned.
+func runSelfTest(source db.GeoIPSource, ips []net.IP, required bool, timeout time.Duration) error {
+	if len(ips) == 0 {
+		return nil
+	}
+	err := selfTest(source, ips, timeout)
+	if err == nil {
+		log.Info().Int("ips", len(ips)).Msg("Startup self-test passed")
+		return nil
+	}
+	if required {
+		return err
+	}
+	log.Warn().Err(err).Msg("Startup self-test failed")
+	return nil
+}
+
+// selfTest waits for the real database: a fallback source reports ready
+// while it serves the embedded, empty database, which would pass trivially.
+func selfTest(source db.GeoIPSource, ips []net.IP, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !source.IsReady() || db.IsFallback(source.GetReader()) {
+		if time.Now().After(deadline) {
+			return errors.New("database did not become ready before the self-test timeout")
+		}
+		time.Sleep(selfTestPollInterval)
+	}
+
+	reader := source.GetReader()
+	if reader == nil {
+		return errors.New("database reader is not available")
+	}
+	for _, ip := range ips {
+		codes, err := webserver.ResolveCountries(reader, ip)
+		if err != nil {
+			return fmt.Errorf("self-test lookup of %s failed: %w", ip, err)
+		}
+		log.Info().Str("ip", ip.String()).Str("country", strings.Join(codes, ",")).Msg("Self-test lookup")
+	}
+	return nil
+}
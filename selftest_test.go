@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rdwr-valentineg/GeoIP/internal/db"
+)
+
+type (
+	stubSource struct {
+		db.GeoIPSource
+		ready  bool
+		reader db.ReaderInterface
+		info   db.DBInfo
+	}
+	stubReader struct {
+		err error
+	}
+)
+
+func (s *stubSource) IsReady() bool                 { return s.ready }
+func (s *stubSource) GetReader() db.ReaderInterface { return s.reader }
+func (s *stubSource) Info() db.DBInfo               { return s.info }
+
+func (r *stubReader) Lookup(ip net.IP, result any) error {
+	return r.err
+}
+func (r *stubReader) LookupNetwork(ip net.IP, result any) (*net.IPNet, bool, error) {
+	return nil, false, r.err
+}
+func (r *stubReader) Close() error { return nil }
+
+func TestRunSelfTest(t *testing.T) {
+	ips := []net.IP{net.ParseIP("8.8.8.8")}
+	broken := &stubSource{ready: true, reader: &stubReader{err: errors.New("unexpected schema")}}
+
+	tests := []struct {
+		name      string
+		source    *stubSource
+		ips       []net.IP
+		required  bool
+		expectErr bool
+	}{
+		{
+			name:     "Healthy database",
+			source:   &stubSource{ready: true, reader: &stubReader{}},
+			ips:      ips,
+			required: true,
+		}, {
+			name:      "Broken reader aborts when required",
+			source:    broken,
+			ips:       ips,
+			required:  true,
+			expectErr: true,
+		}, {
+			name:   "Broken reader only warns when not required",
+			source: broken,
+			ips:    ips,
+		}, {
+			name:      "Never ready aborts when required",
+			source:    &stubSource{ready: false},
+			ips:       ips,
+			required:  true,
+			expectErr: true,
+		}, {
+			name:     "No sanity IPs skips the test",
+			source:   broken,
+			required: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := runSelfTest(tc.source, tc.ips, tc.required, 10*time.Millisecond)
+			if tc.expectErr && err == nil {
+				t.Error("Expected self-test to fail")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("Expected self-test to pass, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRunSelfTest_WaitsForRealDB(t *testing.T) {
+	primary := &stubSource{ready: false}
+	fallback, err := db.WithFallback(primary)
+	if err != nil {
+		t.Fatalf("WithFallback failed: %v", err)
+	}
+	if err := runSelfTest(fallback, []net.IP{net.ParseIP("8.8.8.8")}, true, 10*time.Millisecond); err == nil {
+		t.Error("Expected the self-test to fail while only the fallback DB serves")
+	}
+
+	primary.ready, primary.reader = true, &stubReader{}
+	if err := runSelfTest(fallback, []net.IP{net.ParseIP("8.8.8.8")}, true, 10*time.Millisecond); err != nil {
+		t.Errorf("Expected the self-test to pass once the primary is ready, got %v", err)
+	}
+}